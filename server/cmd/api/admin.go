@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maxDLQPage caps how many dead-lettered messages are returned at once
+	maxDLQPage = 100
+	// maxDLQScan caps how many DLQ entries one request looks through, since
+	// the DLQ is shared by all organizations
+	maxDLQScan = 1000
+)
+
+type dlqEntry struct {
+	ID      string        `json:"id"`
+	Message *data.Message `json:"message"`
+}
+
+// dlqEntryIDRX matches Redis stream entry IDs
+var dlqEntryIDRX = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+
+// parseDLQEntry returns the message held by a DLQ entry. It returns false for
+// entries that do not hold a valid message.
+func parseDLQEntry(entry redis.XMessage) (*data.Message, bool) {
+	messageJSON, ok := entry.Values["message"].(string)
+	if !ok {
+		return nil, false
+	}
+
+	var message data.Message
+	if err := json.Unmarshal([]byte(messageJSON), &message); err != nil {
+		return nil, false
+	}
+
+	// Messages dead-lettered before organizations existed have none
+	if message.OrganizationID == 0 {
+		message.OrganizationID = data.DefaultOrganizationID
+	}
+
+	return &message, true
+}
+
+// listDLQ returns the organization's messages that could not be persisted,
+// newest first. Pass the ID of the last entry as before to get the next page.
+func (app *application) listDLQ(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	count := app.readInt(qs, "count", 20, v)
+	before := qs.Get("before")
+
+	v.Check(count > 0, "count", "Must be greater than zero")
+	v.Check(count <= maxDLQPage, "count", "Must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organizationID := app.contextGetOrganization(r)
+
+	end := "+"
+	if before != "" {
+		end = "(" + before
+	}
+
+	entries := []dlqEntry{}
+
+	for scanned := 0; len(entries) < count && scanned < maxDLQScan; {
+		batch, err := app.redis.XRevRangeN(ctx, app.config.redis.dlq.key, end, "-", int64(count)).Result()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, entry := range batch {
+			end = "(" + entry.ID
+			scanned++
+
+			message, ok := parseDLQEntry(entry)
+			if !ok || message.OrganizationID != organizationID {
+				continue
+			}
+
+			entries = append(entries, dlqEntry{ID: entry.ID, Message: message})
+			if len(entries) == count {
+				break
+			}
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"entries": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replayDLQ takes a message of the organization off the DLQ and puts it back
+// on its stream for the worker to persist again. The entry is removed first,
+// so a message replayed twice at once is only enqueued once.
+func (app *application) replayDLQ(w http.ResponseWriter, r *http.Request) {
+	entryID := httprouter.ParamsFromContext(r.Context()).ByName("entry_id")
+	if !dlqEntryIDRX.MatchString(entryID) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	entries, err := app.redis.XRange(ctx, app.config.redis.dlq.key, entryID, entryID).Result()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(entries) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	message, ok := parseDLQEntry(entries[0])
+	if !ok || message.OrganizationID != app.contextGetOrganization(r) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deleted, err := app.redis.XDel(ctx, app.config.redis.dlq.key, entryID).Result()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if deleted == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.queue.EnqueueMessage(ctx, message)
+	if err != nil {
+		// Put the message back so that it is not lost
+		restoreErr := app.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: app.config.redis.dlq.key,
+			Values: entries[0].Values,
+		}).Err()
+		if restoreErr != nil {
+			app.logError(r, restoreErr)
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditDLQReplayed,
+		TargetType: data.TargetDLQEntry,
+		TargetID:   entryID,
+	}, message, nil)
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUser removes a user of the organization along with their messages,
+// contacts and everything else they own
+func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "user_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(userID != app.contextGetUser(r).ID, "user_id", "You cannot delete yourself"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organizationID := app.contextGetOrganization(r)
+
+	user, err := app.models.Users.Get(ctx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Users.Delete(ctx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditUserDeleted,
+		TargetType: data.TargetUser,
+		TargetID:   strconv.FormatInt(userID, 10),
+	}, user, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+func (app *application) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	keys, err := app.models.APIKeys.GetAll(ctx, app.contextGetOrganization(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAPIKey issues a key for a backend integration. The response is the only
+// time the key itself is shown.
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name           string   `json:"name"`
+		Scopes         []string `json:"scopes"`
+		RateLimitRPS   *int     `json:"rate_limit_rps"`
+		RateLimitBurst *int     `json:"rate_limit_burst"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key := &data.APIKey{
+		OrganizationID: app.contextGetOrganization(r),
+		Name:           input.Name,
+		Scopes:         input.Scopes,
+		RateLimitRPS:   app.config.apiKeys.rps,
+		RateLimitBurst: app.config.apiKeys.burst,
+	}
+
+	if input.RateLimitRPS != nil {
+		key.RateLimitRPS = *input.RateLimitRPS
+	}
+	if input.RateLimitBurst != nil {
+		key.RateLimitBurst = *input.RateLimitBurst
+	}
+
+	if user := app.contextGetUser(r); user.ID != 0 {
+		key.CreatedBy = &user.ID
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	permissions, err := app.contextGetPermissions(ctx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(ctx, key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The key itself must never reach the audit log
+	snapshot := *key
+	snapshot.Plaintext = ""
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditAPIKeyCreated,
+		TargetType: data.TargetAPIKey,
+		TargetID:   strconv.FormatInt(key.ID, 10),
+	}, nil, snapshot)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "api_key_id")
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	key, err := app.models.APIKeys.Revoke(ctx, app.contextGetOrganization(r), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditAPIKeyRevoked,
+		TargetType: data.TargetAPIKey,
+		TargetID:   strconv.FormatInt(key.ID, 10),
+	}, nil, key)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+const (
+	// auditExportTimeout bounds how long an export may stream for
+	auditExportTimeout = 5 * time.Minute
+	// auditExportFlushEvery is how many events are written between flushes
+	auditExportFlushEvery = 100
+)
+
+// auditSnapshot converts the state of a record for an audit event. Missing
+// records have no snapshot.
+func auditSnapshot(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(js, []byte("null")) {
+		return nil, nil
+	}
+
+	return js, nil
+}
+
+// recordAudit adds the request's actor, IP address and ID to the event and
+// publishes it in the background. The worker writes it to the audit log, and
+// events that cannot be published are written to it directly instead. The
+// actor is the API key or user making the request, unless the event names one.
+func (app *application) recordAudit(r *http.Request, event *data.AuditEvent, before, after any) {
+	if event.OrganizationID == 0 {
+		event.OrganizationID = app.contextGetOrganization(r)
+	}
+
+	if event.ActorType == "" {
+		if apiKey := app.contextGetAPIKey(r); apiKey != nil {
+			event.ActorType = data.ActorAPIKey
+			event.ActorID = apiKey.ID
+		} else {
+			event.ActorType = data.ActorUser
+			event.ActorID = app.contextGetUser(r).ID
+		}
+	}
+
+	event.IP = realip.FromRequest(r)
+	event.RequestID = app.contextGetRequestID(r)
+	event.CreatedAt = time.Now()
+
+	var err error
+
+	event.Before, err = auditSnapshot(before)
+	if err == nil {
+		event.After, err = auditSnapshot(after)
+	}
+	if err != nil {
+		app.logError(r, fmt.Errorf("failed to encode audit event %s: %w", event.Action, err))
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := app.audit.Publish(ctx, event)
+		if err == nil {
+			return
+		}
+		app.logger.Warn("failed to publish audit event, recording it directly", "error", err, "action", event.Action, "request_id", event.RequestID)
+
+		// Publish gave the event its ID, so the recorder skips it if the
+		// stream did get it after all
+		insertCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.models.AuditEvents.BulkInsert(insertCtx, []*data.AuditEvent{event})
+		if err != nil {
+			app.logger.Error("failed to record audit event", "error", err, "action", event.Action, "request_id", event.RequestID)
+		}
+	})
+}
+
+func (app *application) readAuditFilter(qs url.Values, v *validator.Validator) data.AuditFilter {
+	return data.AuditFilter{
+		Action:     qs.Get("action"),
+		ActorType:  qs.Get("actor_type"),
+		ActorID:    int64(app.readInt(qs, "actor_id", 0, v)),
+		TargetType: qs.Get("target_type"),
+		TargetID:   qs.Get("target_id"),
+		Since:      app.readTime(qs, "since", time.Time{}, v),
+		Until:      app.readTime(qs, "until", time.Time{}, v),
+	}
+}
+
+// listAuditEvents returns a page of the organization's audit log, newest
+// first, optionally filtered by action, actor, target and time range
+func (app *application) listAuditEvents(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+
+	filters.Cursor = app.readTime(qs, "cursor", time.Now(), v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filter := app.readAuditFilter(qs, v)
+
+	data.ValidateFilters(v, filters)
+	if data.ValidateAuditFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	events, metadata, err := app.models.AuditEvents.GetAll(ctx, app.contextGetOrganization(r), filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportAuditEvents streams every audit event matching the filters as
+// newline-delimited JSON, oldest first. Events are written as they are read,
+// so exports of any size use the same memory.
+func (app *application) exportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filter := app.readAuditFilter(r.URL.Query(), v)
+
+	if data.ValidateAuditFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Exports take longer than the server's write timeout allows
+	rc := http.NewResponseController(w)
+
+	err := rc.SetWriteDeadline(time.Now().Add(auditExportTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), auditExportTimeout)
+	defer cancel()
+
+	// The response starts with the first event, so that errors running the
+	// query can still be reported properly
+	started := false
+	writeHeader := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	enc := json.NewEncoder(w)
+	written := 0
+
+	err = app.models.AuditEvents.Export(ctx, app.contextGetOrganization(r), filter, func(event *data.AuditEvent) error {
+		if !started {
+			writeHeader()
+		}
+
+		err := enc.Encode(event)
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%auditExportFlushEvery == 0 {
+			return rc.Flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		// Once the export has started the status cannot be changed, so the
+		// client sees a truncated body
+		if !started {
+			app.serverErrorResponse(w, r, err)
+		} else {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	if !started {
+		writeHeader()
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+func (app *application) listAutoReplyRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	rules, err := app.models.AutoReplyRules.GetAllForUser(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"auto_reply_rules": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name           string              `json:"name"`
+		MatchType      string              `json:"match_type"`
+		Keywords       []string            `json:"keywords"`
+		Pattern        string              `json:"pattern"`
+		HoursCondition string              `json:"hours_condition"`
+		BusinessHours  *data.BusinessHours `json:"business_hours"`
+		Reply          string              `json:"reply"`
+		Priority       int                 `json:"priority"`
+		Active         *bool               `json:"active"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule := &data.AutoReplyRule{
+		UserID:         userID,
+		Name:           input.Name,
+		MatchType:      input.MatchType,
+		Keywords:       input.Keywords,
+		Pattern:        input.Pattern,
+		HoursCondition: input.HoursCondition,
+		BusinessHours:  input.BusinessHours,
+		Reply:          input.Reply,
+		Priority:       input.Priority,
+		Active:         true,
+	}
+
+	if rule.Keywords == nil {
+		rule.Keywords = []string{}
+	}
+	if rule.HoursCondition == "" {
+		rule.HoursCondition = data.HoursAny
+	}
+	if input.Active != nil {
+		rule.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateAutoReplyRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.AutoReplyRules.Insert(ctx, rule)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"auto_reply_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ruleID, err := app.readIDParam(r, "rule_id")
+	if err != nil || ruleID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	rule, err := app.models.AutoReplyRules.Get(ctx, app.contextGetOrganization(r), ruleID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"auto_reply_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ruleID, err := app.readIDParam(r, "rule_id")
+	if err != nil || ruleID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	rule, err := app.models.AutoReplyRules.Get(ctx, app.contextGetOrganization(r), ruleID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name           *string             `json:"name"`
+		MatchType      *string             `json:"match_type"`
+		Keywords       []string            `json:"keywords"`
+		Pattern        *string             `json:"pattern"`
+		HoursCondition *string             `json:"hours_condition"`
+		BusinessHours  *data.BusinessHours `json:"business_hours"`
+		Reply          *string             `json:"reply"`
+		Priority       *int                `json:"priority"`
+		Active         *bool               `json:"active"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name != nil {
+		rule.Name = *input.Name
+	}
+	if input.MatchType != nil {
+		rule.MatchType = *input.MatchType
+	}
+	if input.Keywords != nil {
+		rule.Keywords = input.Keywords
+	}
+	if input.Pattern != nil {
+		rule.Pattern = *input.Pattern
+	}
+	if input.HoursCondition != nil {
+		rule.HoursCondition = *input.HoursCondition
+	}
+	if input.BusinessHours != nil {
+		rule.BusinessHours = input.BusinessHours
+	}
+	if input.Reply != nil {
+		rule.Reply = *input.Reply
+	}
+	if input.Priority != nil {
+		rule.Priority = *input.Priority
+	}
+	if input.Active != nil {
+		rule.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateAutoReplyRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.AutoReplyRules.Update(ctx, app.contextGetOrganization(r), rule)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"auto_reply_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ruleID, err := app.readIDParam(r, "rule_id")
+	if err != nil || ruleID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.AutoReplyRules.Delete(ctx, app.contextGetOrganization(r), ruleID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Auto reply rule deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/policy"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// createBroadcast fans a message out to many receivers as individual messages.
+// Receivers the sender may not message are skipped instead of failing the
+// whole broadcast.
+func (app *application) createBroadcast(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Content     string  `json:"content"`
+		ReceiverIDs []int64 `json:"receiver_ids"`
+		ListID      *int64  `json:"list_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	v.Check((input.ListID == nil) != (len(input.ReceiverIDs) == 0), "receiver_ids", "Exactly one of receiver_ids and list_id is required")
+	if data.ValidateMessage(v, &data.Message{Content: input.Content}); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Checking every receiver takes a few queries each, so a broadcast gets
+	// more time than a single send
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	receiverIDs := input.ReceiverIDs
+	if input.ListID != nil {
+		list, err := app.models.BroadcastLists.Get(ctx, app.contextGetOrganization(r), *input.ListID, senderID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				v.AddError("list_id", "No broadcast list with this ID exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		receiverIDs = list.ReceiverIDs
+	}
+
+	if data.ValidateBroadcastRecipients(v, senderID, receiverIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), append([]int64{senderID}, receiverIDs...))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("receiver_ids", "All receivers must be existing users")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = policy.CanSend(findUser(users, senderID))
+	if err != nil {
+		app.messagingNotAllowedResponse(w, r, err)
+		return
+	}
+
+	// Each recipient costs one token of the sender's broadcast budget
+	if !app.allowUserCost(w, r, rateLimitBroadcast, senderID, len(receiverIDs)) {
+		return
+	}
+
+	broadcast := &data.Broadcast{
+		SenderID:   senderID,
+		ListID:     input.ListID,
+		Content:    input.Content,
+		Recipients: make([]*data.BroadcastRecipient, 0, len(receiverIDs)),
+	}
+
+	var messages []*data.Message
+	now := time.Now()
+
+	for _, receiverID := range receiverIDs {
+		recipient := &data.BroadcastRecipient{ReceiverID: receiverID, Status: data.BroadcastStatusQueued}
+		broadcast.Recipients = append(broadcast.Recipients, recipient)
+
+		err = app.canMessage(ctx, senderID, findUser(users, receiverID))
+		if err != nil {
+			switch {
+			case app.dropBlockedSend(err):
+				// Reported as queued so the sender cannot tell they are blocked
+				continue
+			case errors.Is(err, policy.ErrSenderBlockedReceiver), errors.Is(err, policy.ErrReceiverBlockedSender), errors.Is(err, policy.ErrMessagingRestricted):
+				recipient.Status = data.BroadcastStatusSkipped
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		messages = append(messages, &data.Message{
+			OrganizationID: app.contextGetOrganization(r),
+			Timestamp:      now,
+			Content:        input.Content,
+			SenderID:       senderID,
+			ReceiverID:     receiverID,
+			ReadStatus:     false,
+			Kind:           data.MessageKindText,
+		})
+	}
+
+	err = app.models.Broadcasts.Insert(ctx, broadcast)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Each message goes through the spam filters on its own, and a failed
+	// enqueue only affects its own recipient, so the rest of the broadcast
+	// still goes out
+	for _, message := range messages {
+		message.BroadcastID = &broadcast.ID
+
+		violation, err := app.spam.Check(ctx, message)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if violation != nil {
+			err = app.holdMessage(ctx, message, violation)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			setRecipientStatus(broadcast, message.ReceiverID, data.BroadcastStatusHeld)
+			continue
+		}
+
+		err = app.queue.EnqueueMessage(r.Context(), message)
+		if err != nil {
+			app.logger.Error("failed to enqueue broadcast message", "error", err, "broadcast_id", broadcast.ID, "receiver_id", message.ReceiverID)
+
+			err = app.models.Broadcasts.SetRecipientStatus(ctx, broadcast.ID, message.ReceiverID, data.BroadcastStatusFailed)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			setRecipientStatus(broadcast, message.ReceiverID, data.BroadcastStatusFailed)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"broadcast": broadcast}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setRecipientStatus changes the status of a recipient in the broadcast
+// returned to the sender
+func setRecipientStatus(broadcast *data.Broadcast, receiverID int64, status string) {
+	for _, recipient := range broadcast.Recipients {
+		if recipient.ReceiverID == receiverID {
+			recipient.Status = status
+		}
+	}
+}
+
+func (app *application) listBroadcasts(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+
+	filters.Cursor = app.readTime(qs, "cursor", time.Now(), v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	broadcasts, metadata, err := app.models.Broadcasts.GetAllForSender(ctx, app.contextGetOrganization(r), senderID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"broadcasts": broadcasts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showBroadcast returns a broadcast with the delivery status of each recipient
+func (app *application) showBroadcast(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	broadcastID, err := app.readIDParam(r, "broadcast_id")
+	if err != nil || broadcastID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	broadcast, err := app.models.Broadcasts.Get(ctx, app.contextGetOrganization(r), broadcastID, senderID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"broadcast": broadcast}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBroadcastLists(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	lists, err := app.models.BroadcastLists.GetAllForUser(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"broadcast_lists": lists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBroadcastList(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name        string  `json:"name"`
+		ReceiverIDs []int64 `json:"receiver_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list := &data.BroadcastList{
+		UserID:      userID,
+		Name:        input.Name,
+		ReceiverIDs: input.ReceiverIDs,
+	}
+
+	v := validator.New()
+
+	if data.ValidateBroadcastList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	if !app.checkBroadcastListUsers(ctx, w, r, list) {
+		return
+	}
+
+	err = app.models.BroadcastLists.Insert(ctx, list)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateBroadcastList) {
+			v.AddError("name", "A broadcast list with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"broadcast_list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBroadcastList(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	listID, err := app.readIDParam(r, "list_id")
+	if err != nil || listID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	list, err := app.models.BroadcastLists.Get(ctx, app.contextGetOrganization(r), listID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"broadcast_list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateBroadcastList(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	listID, err := app.readIDParam(r, "list_id")
+	if err != nil || listID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	list, err := app.models.BroadcastLists.Get(ctx, app.contextGetOrganization(r), listID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		ReceiverIDs []int64 `json:"receiver_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.ReceiverIDs != nil {
+		list.ReceiverIDs = input.ReceiverIDs
+	}
+
+	v := validator.New()
+
+	if data.ValidateBroadcastList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.ReceiverIDs != nil && !app.checkBroadcastListUsers(ctx, w, r, list) {
+		return
+	}
+
+	err = app.models.BroadcastLists.Update(ctx, app.contextGetOrganization(r), list)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateBroadcastList) {
+			v.AddError("name", "A broadcast list with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"broadcast_list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteBroadcastList(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	listID, err := app.readIDParam(r, "list_id")
+	if err != nil || listID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.BroadcastLists.Delete(ctx, app.contextGetOrganization(r), listID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Broadcast list deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkBroadcastListUsers verifies that the owner and every receiver of a list
+// exist. It returns false when it has already responded.
+func (app *application) checkBroadcastListUsers(ctx context.Context, w http.ResponseWriter, r *http.Request, list *data.BroadcastList) bool {
+	_, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), append([]int64{list.UserID}, list.ReceiverIDs...))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v := validator.New()
+			v.AddError("receiver_ids", "All receivers must be existing users")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// recordCannedResponseUse counts a send of a canned response in the
+// background. Failures are logged rather than failing the send.
+func (app *application) recordCannedResponseUse(id int64) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err := app.models.CannedResponses.RecordUse(ctx, id)
+		if err != nil {
+			app.logger.Error("failed to record canned response use", "error", err, "canned_response_id", id)
+		}
+	})
+}
+
+// searchCannedResponses autocompletes shortcuts. It returns the responses
+// shared in the organization and the acting user's own ones. Users search as
+// themselves, and API keys may name the member to search for with user_id.
+func (app *application) searchCannedResponses(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	named := int64(app.readInt(qs, "user_id", 0, v))
+	if v.Check(named >= 0, "user_id", "Must be a positive integer"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, ok := app.actingUserID(r, named)
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	prefix := qs.Get("prefix")
+	sort := qs.Get("sort")
+	if sort == "" {
+		sort = "shortcut"
+	}
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(validator.MaxChars(prefix, 33), "prefix", "Must not be more than 33 characters long")
+	v.Check(validator.PermittedValue(sort, data.CannedResponseSorts...), "sort", "Must be shortcut or -usage_count")
+	v.Check(limit > 0 && limit <= 50, "limit", "Must be between 1 and 50")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	if userID != 0 {
+		_, err := app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.notFoundResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	responses, err := app.models.CannedResponses.Search(ctx, app.contextGetOrganization(r), userID, prefix, sort, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"canned_responses": responses}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCannedResponse(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Shortcut string `json:"shortcut"`
+		Body     string `json:"body"`
+		Shared   bool   `json:"shared"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := &data.CannedResponse{
+		UserID:   userID,
+		Shortcut: input.Shortcut,
+		Body:     input.Body,
+		Shared:   input.Shared,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCannedResponse(v, response); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.CannedResponses.Insert(ctx, response)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateCannedResponse) {
+			v.AddError("shortcut", "You already have a canned response with this shortcut")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"canned_response": response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCannedResponse(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	responseID, err := app.readIDParam(r, "canned_id")
+	if err != nil || responseID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	response, err := app.models.CannedResponses.Get(ctx, responseID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"canned_response": response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCannedResponse(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	responseID, err := app.readIDParam(r, "canned_id")
+	if err != nil || responseID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	response, err := app.models.CannedResponses.Get(ctx, responseID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Shortcut *string `json:"shortcut"`
+		Body     *string `json:"body"`
+		Shared   *bool   `json:"shared"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Shortcut != nil {
+		response.Shortcut = *input.Shortcut
+	}
+	if input.Body != nil {
+		response.Body = *input.Body
+	}
+	if input.Shared != nil {
+		response.Shared = *input.Shared
+	}
+
+	v := validator.New()
+
+	if data.ValidateCannedResponse(v, response); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.CannedResponses.Update(ctx, response)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateCannedResponse) {
+			v.AddError("shortcut", "You already have a canned response with this shortcut")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"canned_response": response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCannedResponse(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	responseID, err := app.readIDParam(r, "canned_id")
+	if err != nil || responseID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.CannedResponses.Delete(ctx, responseID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Canned response deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+func (app *application) listContacts(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	contacts, err := app.models.Contacts.GetAllForOwner(ctx, ownerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"contacts": contacts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createContact(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ContactID int64  `json:"contact_id"`
+		Nickname  string `json:"nickname"`
+		Favorite  bool   `json:"favorite"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contact := &data.Contact{
+		OwnerID:   ownerID,
+		ContactID: input.ContactID,
+		Nickname:  input.Nickname,
+		Favorite:  input.Favorite,
+	}
+
+	v := validator.New()
+
+	if data.ValidateContact(v, contact); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	users, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), []int64{ownerID, contact.ContactID})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	contact.FullName = findUser(users, contact.ContactID).FullName
+
+	err = app.models.Contacts.Insert(ctx, contact)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateContact) {
+			v.AddError("contact_id", "This user is already a contact")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"contact": contact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createExternalContact adds someone reached through an external channel, such
+// as an email address or phone number, to the user's contacts. The person gets
+// a user of their own the first time the organization adds or hears from them,
+// and messages sent to that user are delivered through the channel.
+func (app *application) createExternalContact(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Channel    string `json:"channel"`
+		ExternalID string `json:"external_id"`
+		FullName   string `json:"full_name"`
+		Nickname   string `json:"nickname"`
+		Favorite   bool   `json:"favorite"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	input.ExternalID = data.NormalizeExternalID(input.Channel, input.ExternalID)
+
+	v := validator.New()
+
+	data.ValidateExternalIdentity(v, input.Channel, input.ExternalID)
+	v.Check(validator.MaxChars(input.FullName, 100), "full_name", "Must not be more than 100 characters")
+	v.Check(validator.MaxChars(input.Nickname, 100), "nickname", "Nickname must not be more than 100 characters")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organizationID := app.contextGetOrganization(r)
+
+	_, err = app.models.Users.Get(ctx, organizationID, ownerID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.ExternalIdentities.GetOrCreateUser(ctx, organizationID, input.Channel, input.ExternalID, input.FullName)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	contact := &data.Contact{
+		OwnerID:   ownerID,
+		ContactID: user.ID,
+		FullName:  user.FullName,
+		Nickname:  input.Nickname,
+		Favorite:  input.Favorite,
+	}
+
+	err = app.models.Contacts.Insert(ctx, contact)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateContact) {
+			v.AddError("external_id", "This address is already a contact")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"contact": contact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showContact(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	contactID, err := app.readIDParam(r, "contact_id")
+	if err != nil || contactID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	contact, err := app.models.Contacts.Get(ctx, ownerID, contactID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"contact": contact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateContact(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	contactID, err := app.readIDParam(r, "contact_id")
+	if err != nil || contactID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	contact, err := app.models.Contacts.Get(ctx, ownerID, contactID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Nickname *string `json:"nickname"`
+		Favorite *bool   `json:"favorite"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Nickname != nil {
+		contact.Nickname = *input.Nickname
+	}
+	if input.Favorite != nil {
+		contact.Favorite = *input.Favorite
+	}
+
+	v := validator.New()
+
+	if data.ValidateContact(v, contact); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Contacts.Update(ctx, contact)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"contact": contact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteContact(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	contactID, err := app.readIDParam(r, "contact_id")
+	if err != nil || contactID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.Contacts.Delete(ctx, ownerID, contactID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Contact deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) importContacts(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.readIDParam(r, "sender_id")
+	if err != nil || ownerID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Emails []string `json:"emails"`
+		Phones []string `json:"phones"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for i := range input.Emails {
+		input.Emails[i] = data.NormalizeEmail(input.Emails[i])
+	}
+	for i := range input.Phones {
+		input.Phones[i] = data.NormalizePhone(input.Phones[i])
+	}
+
+	v := validator.New()
+
+	if data.ValidateContactImport(v, input.Emails, input.Phones); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Matches tell whether an address belongs to a user, so every address
+	// looked up costs one token of the owner's import budget
+	if !app.allowUserCost(w, r, rateLimitContactImport, ownerID, len(input.Emails)+len(input.Phones)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), ownerID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	imported, matches, err := app.models.Contacts.Import(ctx, ownerID, input.Emails, input.Phones)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	matched := make(map[string]bool, len(matches))
+	for _, match := range matches {
+		matched[match.Identifier] = true
+	}
+
+	unmatched := []string{}
+	for _, identifier := range append(input.Emails, input.Phones...) {
+		if !matched[identifier] {
+			unmatched = append(unmatched, identifier)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"imported": imported, "matches": matches, "unmatched": unmatched}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
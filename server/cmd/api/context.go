@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+type contextKey string
+
+const (
+	organizationContextKey = contextKey("organization")
+	requestIDContextKey    = contextKey("request_id")
+)
+
+// contextSetRequestID returns a copy of the request with its ID added to its
+// context
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the request's ID, or an empty string for
+// requests that did not pass through the requestID middleware
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// contextSetOrganization returns a copy of the request with the ID of the
+// organization it acts in added to its context
+func (app *application) contextSetOrganization(r *http.Request, organizationID int64) *http.Request {
+	ctx := context.WithValue(r.Context(), organizationContextKey, organizationID)
+	return r.WithContext(ctx)
+}
+
+// contextGetOrganization returns the organization the request acts in. It is
+// only called from handlers behind the organization middleware, so a missing
+// value is a programming error.
+func (app *application) contextGetOrganization(r *http.Request) int64 {
+	organizationID, ok := r.Context().Value(organizationContextKey).(int64)
+	if !ok {
+		panic("missing organization value in request context")
+	}
+
+	return organizationID
+}
+
+// requestUser is stored in the request context by the authenticate middleware.
+// The user's permissions are loaded the first time they are needed and kept
+// for the rest of the request.
+type requestUser struct {
+	user        *data.User
+	apiKey      *data.APIKey
+	permissions data.Permissions
+}
+
+const userContextKey = contextKey("user")
+
+// contextSetUser returns a copy of the request with the authenticated user, or
+// data.AnonymousUser, added to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, &requestUser{user: user})
+	return r.WithContext(ctx)
+}
+
+// contextSetAPIKey returns a copy of the request authenticated with an API
+// key. The key acts as a user of its organization without an ID that holds
+// only the key's scopes.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	requestUser := &requestUser{
+		user:        &data.User{OrganizationID: key.OrganizationID},
+		apiKey:      key,
+		permissions: append(data.Permissions{}, key.Scopes...),
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, requestUser)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or
+// nil if it was not made with one
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	return app.contextGetRequestUser(r).apiKey
+}
+
+// contextGetUser returns the user making the request. It is only called from
+// handlers behind the authenticate middleware, so a missing value is a
+// programming error.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	return app.contextGetRequestUser(r).user
+}
+
+func (app *application) contextGetRequestUser(r *http.Request) *requestUser {
+	requestUser, ok := r.Context().Value(userContextKey).(*requestUser)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return requestUser
+}
+
+// contextGetPermissions returns the permissions of the user making the request,
+// querying them at most once per request
+func (app *application) contextGetPermissions(ctx context.Context, r *http.Request) (data.Permissions, error) {
+	requestUser := app.contextGetRequestUser(r)
+
+	if requestUser.permissions == nil {
+		permissions, err := app.models.Permissions.GetAllForUser(ctx, requestUser.user.ID)
+		if err != nil {
+			return nil, err
+		}
+		requestUser.permissions = permissions
+	}
+
+	return requestUser.permissions, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/policy"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var disappearingTimerLabels = map[string]string{
+	"24h": "24 hours",
+	"7d":  "7 days",
+	"90d": "90 days",
+}
+
+func (app *application) setDisappearingTimer(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	receiverID, err := app.readIDParam(r, "receiver_id")
+	if err != nil || receiverID < 1 || receiverID == senderID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Timer string `json:"timer"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateDisappearingTimer(v, input.Timer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	sender, err := app.models.Users.Get(ctx, app.contextGetOrganization(r), senderID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	receiver, err := app.models.Users.Get(ctx, app.contextGetOrganization(r), receiverID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The timer is announced with a message from the sender, so it can only be
+	// set by someone who could message the receiver. There is no notice to
+	// drop here, so sends to users who blocked the sender are always rejected.
+	err = policy.CanSend(sender)
+	if err == nil {
+		err = app.canMessage(ctx, senderID, receiver)
+	}
+	if err != nil {
+		app.messagingNotAllowedResponse(w, r, err)
+		return
+	}
+
+	conversation, err := app.models.Conversations.SetMessageTTL(ctx, app.contextGetOrganization(r), senderID, receiverID, data.DisappearingTimers[input.Timer])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	content := fmt.Sprintf("%s turned off disappearing messages.", sender.FullName)
+	if label, ok := disappearingTimerLabels[input.Timer]; ok {
+		content = fmt.Sprintf("%s turned on disappearing messages. New messages will disappear from this chat %s after they're sent.", sender.FullName, label)
+	}
+
+	notice := &data.Message{
+		OrganizationID: conversation.OrganizationID,
+		Timestamp:      time.Now(),
+		Content:        content,
+		SenderID:       senderID,
+		ReceiverID:     receiverID,
+		ReadStatus:     false,
+		Kind:           data.MessageKindSystem,
+	}
+
+	err = app.queue.EnqueueMessage(r.Context(), notice)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"conversation": conversation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
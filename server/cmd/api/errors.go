@@ -9,15 +9,17 @@ import (
 
 func (app *application) logError(r *http.Request, err error) {
 	var (
-		method = r.Method
-		uri    = r.URL.RequestURI()
-		trace  = string(debug.Stack())
+		method    = r.Method
+		uri       = r.URL.RequestURI()
+		requestID = app.contextGetRequestID(r)
+		trace     = string(debug.Stack())
 	)
 
 	app.logger.Error(
 		err.Error(),
 		slog.String("method", method),
 		slog.String("uri", uri),
+		slog.String("request_id", requestID),
 		slog.String("trace", trace),
 	)
 }
@@ -52,3 +54,43 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	message := "Rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invalidSignatureResponse(w http.ResponseWriter, r *http.Request) {
+	message := "Invalid or missing request signature"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) replayedRequestResponse(w http.ResponseWriter, r *http.Request) {
+	message := "This request has already been received"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	app.countAuthFailure(r)
+
+	message := "Invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	app.countAuthFailure(r)
+
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "Invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "You must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "Your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/araaavind/zoko-im/internal/data"
 	"github.com/araaavind/zoko-im/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -148,3 +149,46 @@ func (app *application) readTime(qs url.Values, key string, defaultValue time.Ti
 
 	return t
 }
+
+// findUser returns the user with the given ID from a slice returned by
+// UserModel.GetMany, or nil if it is not there
+func findUser(users []*data.User, id int64) *data.User {
+	for _, user := range users {
+		if user.ID == id {
+			return user
+		}
+	}
+	return nil
+}
+
+// actingUserID returns the user that a request to a route without :sender_id
+// acts as. Users act as themselves, so the named user must be left out or be
+// them, and false is returned otherwise. API keys must name the member they act
+// as, and handlers check that member against the records they touch.
+func (app *application) actingUserID(r *http.Request, named int64) (int64, bool) {
+	if app.contextGetAPIKey(r) != nil {
+		return named, true
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	return userID, named == 0 || named == userID
+}
+
+// background runs fn in a goroutine tracked by the application's WaitGroup so
+// that shutdown can wait for it, and recovers from any panic it raises
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
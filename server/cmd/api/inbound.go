@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/inbound"
+	"github.com/araaavind/zoko-im/internal/policy"
+	"github.com/araaavind/zoko-im/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// verifyInbound checks the signature of a request from a channel provider and
+// that the request has not been received before. A signature is only
+// accepted once, so a captured request cannot be replayed while its timestamp
+// is still recent enough to pass verification. It sends the error response and
+// returns false if the request must be rejected.
+func (app *application) verifyInbound(w http.ResponseWriter, r *http.Request, channel inbound.Channel, body []byte) bool {
+	err := channel.Verify(r, body)
+	if err != nil {
+		app.invalidSignatureResponse(w, r)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	first, err := app.events.Allow(ctx, fmt.Sprintf("inbound:%s:%s", channel.Name(), channel.Signature(r)), inbound.ReplayWindow)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !first {
+		app.replayedRequestResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// receiveInbound accepts a message from an external channel provider, maps its
+// sender to a user and enqueues it like any other message
+func (app *application) receiveInbound(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.inbound[httprouter.ParamsFromContext(r.Context()).ByName("channel")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The raw body is needed to verify the signature, so it is read up front
+	// instead of going through readJSON
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "body could not be read")
+		return
+	}
+
+	if !app.verifyInbound(w, r, channel, body) {
+		return
+	}
+
+	in, err := channel.Parse(r, body)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, isSMS := channel.(*inbound.SMS); isSMS {
+		in.ExternalID = data.NormalizePhone(in.ExternalID)
+		in.ToPhone = data.NormalizePhone(in.ToPhone)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	// A phone number identifies its organization. Channels that address users
+	// by ID name the organization in their signed payload.
+	var receiver *data.User
+	if in.ToPhone != "" {
+		receiver, err = app.models.Users.GetByPhone(ctx, in.ToPhone)
+	} else {
+		receiver, err = app.models.Users.Get(ctx, in.OrganizationID, in.ToUserID)
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	message := &data.Message{
+		OrganizationID: receiver.OrganizationID,
+		Timestamp:      in.Timestamp,
+		Content:        in.Content,
+		ReceiverID:     receiver.ID,
+		ReadStatus:     false,
+		Kind:           data.MessageKindText,
+	}
+
+	v := validator.New()
+
+	if data.ValidateMessage(v, message); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The sender is only created once the message is known to be allowed. A
+	// sender the organization has not seen yet cannot have been blocked or
+	// added as a contact, so they are checked as the anonymous user ID 0.
+	sender, err := app.models.ExternalIdentities.GetUser(ctx, receiver.OrganizationID, channel.Name(), in.ExternalID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var senderID int64
+	err = nil
+	if sender != nil {
+		senderID = sender.ID
+		err = policy.CanSend(sender)
+	}
+	if err == nil {
+		err = app.canMessage(ctx, senderID, receiver)
+	}
+	if err != nil {
+		if app.dropBlockedSend(err) {
+			app.logger.Info("dropped inbound message to blocking user", "channel", channel.Name(), "sender_id", senderID, "receiver_id", receiver.ID)
+			err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "Message queued for processing"}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		} else {
+			app.messagingNotAllowedResponse(w, r, err)
+		}
+		return
+	}
+
+	if sender == nil {
+		sender, err = app.models.ExternalIdentities.GetOrCreateUser(ctx, receiver.OrganizationID, channel.Name(), in.ExternalID, in.SenderName)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	message.SenderID = sender.ID
+
+	err = app.queue.EnqueueMessage(r.Context(), message)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "Message queued for processing"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// receiveDeliveryStatus records a provider's delivery status callback for a
+// message we sent to one of its users
+func (app *application) receiveDeliveryStatus(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.inbound[httprouter.ParamsFromContext(r.Context()).ByName("channel")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "body could not be read")
+		return
+	}
+
+	if !app.verifyInbound(w, r, channel, body) {
+		return
+	}
+
+	status, err := channel.ParseStatus(r, body)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if status != nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+		defer cancel()
+
+		err = app.models.Messages.UpdateDeliveryByExternalID(ctx, status.OrganizationID, channel.Name(), status.ExternalMessageID, status.Status)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.notFoundResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/inbound"
+	"github.com/araaavind/zoko-im/internal/webhooks"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestInboundReplay checks that a signed request from a channel provider is
+// only accepted once. It needs the Redis server in IM_TEST_REDIS_ADDR and is
+// skipped unless it is set.
+func TestInboundReplay(t *testing.T) {
+	addr := os.Getenv("IM_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("IM_TEST_REDIS_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		events: events.NewBus(client),
+	}
+
+	channel := &inbound.Generic{Secret: "secret"}
+
+	// The body is unique to this run so that earlier runs cannot have used
+	// the signature
+	body := []byte(`{"message_id":"ext-` + strconv.FormatInt(time.Now().UnixNano(), 10) + `","status":"delivered"}`)
+	timestamp := time.Now().Unix()
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/inbound/generic/status", bytes.NewReader(body))
+		r.Header.Set("X-Zoko-Timestamp", strconv.FormatInt(timestamp, 10))
+		r.Header.Set("X-Zoko-Signature", webhooks.Sign(channel.Secret, timestamp, body))
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	if !app.verifyInbound(w, newRequest(), channel, body) {
+		t.Fatalf("got first request rejected with status %d; want it accepted", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	if app.verifyInbound(w, newRequest(), channel, body) {
+		t.Fatal("got replayed request accepted; want it rejected")
+	}
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/assignment"
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+func (app *application) showInbox(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	inbox, err := app.models.Inboxes.Get(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"inbox": inbox}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateInbox turns the user into a shared inbox, or changes its assignment
+// strategy and SLAs
+func (app *application) updateInbox(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Strategy         string `json:"strategy"`
+		FirstResponseSLA int    `json:"first_response_sla"`
+		ResolutionSLA    int    `json:"resolution_sla"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	inbox := &data.Inbox{
+		UserID:           userID,
+		Strategy:         input.Strategy,
+		FirstResponseSLA: input.FirstResponseSLA,
+		ResolutionSLA:    input.ResolutionSLA,
+	}
+
+	if inbox.Strategy == "" {
+		inbox.Strategy = data.AssignRoundRobin
+	}
+
+	v := validator.New()
+
+	if data.ValidateInbox(v, inbox); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Inboxes.Upsert(ctx, inbox)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"inbox": inbox}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listInboxAgents(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	agents, err := app.models.Inboxes.GetAgents(ctx, app.contextGetOrganization(r), inboxID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"agents": agents}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addInboxAgent(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	agentID, err := app.readIDParam(r, "agent_id")
+	if err != nil || agentID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(agentID != inboxID, "agent_id", "An inbox cannot be its own agent"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err = app.models.Inboxes.Get(ctx, app.contextGetOrganization(r), inboxID)
+	if err == nil {
+		_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), agentID)
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Inboxes.AddAgent(ctx, inboxID, agentID)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateAgent) {
+			v.AddError("agent_id", "This user is already an agent of the inbox")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "Agent added"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeInboxAgent(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	agentID, err := app.readIDParam(r, "agent_id")
+	if err != nil || agentID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.Inboxes.RemoveAgent(ctx, app.contextGetOrganization(r), inboxID, agentID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Agent removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAssignments(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+
+	filters.Cursor = app.readTime(qs, "cursor", time.Now(), v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	status := qs.Get("status")
+	label := qs.Get("label")
+	agentID := app.readInt(qs, "agent_id", 0, v)
+
+	data.ValidateFilters(v, filters)
+	if status != "" {
+		data.ValidateConversationStatus(v, status)
+	}
+	if label != "" {
+		v.Check(validator.Matches(label, data.LabelRX), "label", "Must be a valid label")
+	}
+	v.Check(agentID >= 0, "agent_id", "Must be a positive integer")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	assignments, metadata, err := app.models.Assignments.GetAllForInbox(ctx, app.contextGetOrganization(r), inboxID, status, label, int64(agentID), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assignments": assignments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// transferAssignment hands an open conversation to another agent of the inbox
+func (app *application) transferAssignment(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	assignmentID, err := app.readIDParam(r, "assignment_id")
+	if err != nil || assignmentID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AgentID int64 `json:"agent_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	v := validator.New()
+
+	isAgent, err := app.models.Inboxes.IsAgent(ctx, app.contextGetOrganization(r), inboxID, input.AgentID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if v.Check(isAgent, "agent_id", "Must be an agent of the inbox"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	a, err := app.models.Assignments.Transfer(ctx, app.contextGetOrganization(r), assignmentID, inboxID, input.AgentID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.events.Publish(ctx, events.Event{Type: assignment.EventAssigned, Data: a}, inboxID, input.AgentID)
+	if err != nil {
+		app.logger.Error("failed to publish assignment event", "error", err, "assignment_id", a.ID)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assignment": a}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateAssignmentStatus opens, parks or resolves a conversation with the inbox
+func (app *application) updateAssignmentStatus(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	assignmentID, err := app.readIDParam(r, "assignment_id")
+	if err != nil || assignmentID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateConversationStatus(v, input.Status); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.setAssignmentStatus(w, r, inboxID, assignmentID, input.Status)
+}
+
+func (app *application) resolveAssignment(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	assignmentID, err := app.readIDParam(r, "assignment_id")
+	if err != nil || assignmentID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.setAssignmentStatus(w, r, inboxID, assignmentID, data.ConversationResolved)
+}
+
+func (app *application) setAssignmentStatus(w http.ResponseWriter, r *http.Request, inboxID, assignmentID int64, status string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err := app.models.Assignments.Get(ctx, app.contextGetOrganization(r), assignmentID, inboxID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a, err := app.models.Assignments.SetStatus(ctx, app.contextGetOrganization(r), assignmentID, inboxID, status)
+	if err != nil {
+		if errors.Is(err, data.ErrOpenAssignmentExists) {
+			v := validator.New()
+			v.AddError("status", "The customer already has another open conversation with this inbox")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assignment": a}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAssignmentLabels(w http.ResponseWriter, r *http.Request) {
+	inboxID, err := app.readIDParam(r, "sender_id")
+	if err != nil || inboxID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	assignmentID, err := app.readIDParam(r, "assignment_id")
+	if err != nil || assignmentID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Labels []string `json:"labels"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Labels == nil {
+		input.Labels = []string{}
+	}
+	for i := range input.Labels {
+		input.Labels[i] = strings.ToLower(strings.TrimSpace(input.Labels[i]))
+	}
+
+	v := validator.New()
+
+	if data.ValidateLabels(v, input.Labels); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	a, err := app.models.Assignments.SetLabels(ctx, app.contextGetOrganization(r), assignmentID, inboxID, input.Labels)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assignment": a}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
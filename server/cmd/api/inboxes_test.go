@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// TestAgentResolvesAssignment checks that an agent of an inbox can work its
+// assignments with their own token, and that other members of the
+// organization cannot
+func TestAgentResolvesAssignment(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	tn := newTenant(t, app)
+	inboxID := tn.owner.UserID
+	agentID := tn.member.UserID
+
+	outsider := &data.Member{FullName: "Outsider", Role: data.RoleEndUser}
+	err := app.models.Organizations.AddMember(ctx, tn.organization.ID, outsider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customer := &data.Member{FullName: "Customer", Role: data.RoleEndUser}
+	err = app.models.Organizations.AddMember(ctx, tn.organization.ID, customer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Inboxes.Upsert(ctx, &data.Inbox{UserID: inboxID, Strategy: data.AssignRoundRobin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Inboxes.AddAgent(ctx, inboxID, agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := app.models.Conversations.Get(ctx, tn.organization.ID, inboxID, customer.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assignment, _, err := app.models.Assignments.Open(ctx, conversation.ID, inboxID, customer.UserID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken := func(userID int64) string {
+		t.Helper()
+
+		token, err := app.models.Tokens.New(ctx, userID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Plaintext
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"outsider", newToken(outsider.UserID), http.StatusForbidden},
+		{"agent", newToken(agentID), http.StatusOK},
+	}
+
+	handler := app.routes()
+	path := fmt.Sprintf("/v1/users/%d/assignments/%d/resolve", inboxID, assignment.ID)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, path, nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d; want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	assignment, err = app.models.Assignments.Get(ctx, tn.organization.ID, assignment.ID, inboxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment.Status != data.ConversationResolved {
+		t.Errorf("got status %q; want %q", assignment.Status, data.ConversationResolved)
+	}
+}
@@ -4,12 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata"
 
+	"github.com/araaavind/zoko-im/internal/audit"
 	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/inbound"
+	"github.com/araaavind/zoko-im/internal/moderation"
+	"github.com/araaavind/zoko-im/internal/presence"
 	"github.com/araaavind/zoko-im/internal/queue"
+	"github.com/araaavind/zoko-im/internal/ratelimit"
+	"github.com/araaavind/zoko-im/internal/webhooks"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/redis/go-redis/v9"
 )
@@ -18,9 +29,19 @@ type config struct {
 	port    int
 	env     string
 	limiter struct {
-		rps     int
-		burst   int
-		enabled bool
+		rps       float64
+		burst     int
+		sendRPS   float64
+		sendBurst int
+		authRPS   float64
+		authBurst int
+		enabled   bool
+		policies  map[string]ratelimit.Policy
+	}
+	apiKeys struct {
+		rps         int
+		burst       int
+		auditWindow time.Duration
 	}
 	db struct {
 		dsn          string
@@ -35,15 +56,75 @@ type config struct {
 		stream   struct {
 			key string
 		}
+		dlq struct {
+			key string
+		}
+		webhooks struct {
+			streamKey string
+		}
+		audit struct {
+			streamKey string
+		}
+	}
+	blocks struct {
+		sends string
+	}
+	webhooks struct {
+		allowPrivateNetworks bool
+	}
+	broadcasts struct {
+		rps   float64
+		burst int
+	}
+	contactImports struct {
+		rps   float64
+		burst int
+	}
+	spam struct {
+		enabled            bool
+		newReceivers       int
+		newReceiversWindow time.Duration
+		duplicateReceivers int
+		duplicateWindow    time.Duration
+		blockedDomains     string
+		blockedKeywords    string
+	}
+	presence struct {
+		statusTTL         time.Duration
+		lastSeenRetention time.Duration
+	}
+	typing struct {
+		throttle time.Duration
+	}
+	inbound struct {
+		genericSecret string
+		smsSecret     string
+	}
+	queue struct {
+		mode   string
+		outbox struct {
+			relayInterval time.Duration
+			batchSize     int
+			retention     time.Duration
+		}
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	redis  *redis.Client
-	queue  *queue.MessageQueue
+	config   config
+	logger   *slog.Logger
+	models   data.Models
+	redis    *redis.Client
+	queue    queue.Enqueuer
+	outbox   *queue.Outbox
+	presence *presence.Tracker
+	events   *events.Bus
+	limiter  *ratelimit.Limiter
+	webhooks *webhooks.Publisher
+	audit    *audit.Publisher
+	inbound  map[string]inbound.Channel
+	spam     moderation.Chain
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -53,9 +134,16 @@ func main() {
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
 
 	// Limiter configuration
-	flag.IntVar(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.sendRPS, "limiter-send-rps", 1, "Rate limiter maximum message sends per second")
+	flag.IntVar(&cfg.limiter.sendBurst, "limiter-send-burst", 2, "Rate limiter maximum burst of message sends")
+	flag.Float64Var(&cfg.limiter.authRPS, "limiter-auth-failure-rps", 0.1, "Rate limiter failed authentications per second each IP address regains")
+	flag.IntVar(&cfg.limiter.authBurst, "limiter-auth-failure-burst", 10, "Rate limiter maximum burst of failed authentications per IP address")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.IntVar(&cfg.apiKeys.rps, "api-key-rps", 10, "Default maximum requests per second for new API keys")
+	flag.IntVar(&cfg.apiKeys.burst, "api-key-burst", 20, "Default maximum burst for new API keys")
+	flag.DurationVar(&cfg.apiKeys.auditWindow, "api-key-audit-window", time.Minute, "Interval in which each API key's use is audited once")
 
 	// Database configuration
 	flag.StringVar(&cfg.db.dsn, "dsn", os.Getenv("IM_DB_DSN"), "PostgreSQL connection string")
@@ -70,9 +158,95 @@ func main() {
 
 	// Redis stream configuration
 	flag.StringVar(&cfg.redis.stream.key, "redis-stream-key", "messages_stream", "Redis stream key name")
+	flag.StringVar(&cfg.redis.dlq.key, "redis-dlq-key", "messages_dlq", "Redis DLQ key name")
+	flag.StringVar(&cfg.redis.webhooks.streamKey, "webhooks-stream-key", "webhook_events", "Redis stream key for webhook events")
+	flag.StringVar(&cfg.redis.audit.streamKey, "audit-stream-key", "audit_events", "Redis stream key for audit events")
+
+	// Queue configuration
+	flag.StringVar(&cfg.queue.mode, "queue-mode", "direct", "How accepted messages reach the stream (direct|outbox)")
+	flag.DurationVar(&cfg.queue.outbox.relayInterval, "outbox-relay-interval", 500*time.Millisecond, "Interval between outbox relay runs")
+	flag.IntVar(&cfg.queue.outbox.batchSize, "outbox-batch-size", 100, "Maximum outbox rows published per relay batch")
+	flag.DurationVar(&cfg.queue.outbox.retention, "outbox-retention", 24*time.Hour, "How long sent outbox rows are kept")
+
+	// Webhook configuration
+	flag.BoolVar(&cfg.webhooks.allowPrivateNetworks, "webhooks-allow-private-networks", false, "Allow webhooks to loopback and private addresses (development only)")
+
+	// Privacy configuration
+	flag.StringVar(&cfg.blocks.sends, "blocked-sends", "reject", "How sends to a user who blocked the sender are handled (reject|drop)")
+
+	// Broadcast configuration. Broadcasts are limited by recipients per sender,
+	// on top of the request rate limiter.
+	flag.Float64Var(&cfg.broadcasts.rps, "broadcast-rps", 1, "Broadcast recipients per second each sender regains")
+	flag.IntVar(&cfg.broadcasts.burst, "broadcast-burst", data.MaxBroadcastRecipients, "Maximum broadcast recipients a sender may reach in a burst")
+	flag.Float64Var(&cfg.contactImports.rps, "contact-import-rps", 0.1, "Imported emails and phone numbers per second each user regains")
+	flag.IntVar(&cfg.contactImports.burst, "contact-import-burst", data.MaxContactImport, "Maximum emails and phone numbers a user may import in a burst")
+
+	// Spam filter configuration. Messages caught by a filter are quarantined
+	// instead of delivered.
+	flag.BoolVar(&cfg.spam.enabled, "spam-filters-enabled", true, "Run sent messages through the spam filters")
+	flag.IntVar(&cfg.spam.newReceivers, "spam-new-receivers", 20, "Maximum new receivers a sender may message per window")
+	flag.DurationVar(&cfg.spam.newReceiversWindow, "spam-new-receivers-window", time.Minute, "Window for the new receivers limit")
+	flag.IntVar(&cfg.spam.duplicateReceivers, "spam-duplicate-receivers", 10, "Maximum receivers a sender may send the same content to per window")
+	flag.DurationVar(&cfg.spam.duplicateWindow, "spam-duplicate-window", 10*time.Minute, "Window for the duplicate content limit")
+	flag.StringVar(&cfg.spam.blockedDomains, "spam-blocked-domains", "", "Comma-separated domains whose links are quarantined")
+	flag.StringVar(&cfg.spam.blockedKeywords, "spam-blocked-keywords", "", "Comma-separated keywords that get messages quarantined")
+
+	// Presence configuration
+	flag.DurationVar(&cfg.presence.statusTTL, "presence-ttl", 60*time.Second, "How long a user stays online without activity or heartbeats")
+	flag.DurationVar(&cfg.presence.lastSeenRetention, "presence-last-seen-retention", 30*24*time.Hour, "How long last seen timestamps are kept")
+
+	// Typing indicator configuration
+	flag.DurationVar(&cfg.typing.throttle, "typing-throttle", 3*time.Second, "Minimum interval between typing events for the same chat")
+
+	// Inbound channel configuration. A channel without a secret is disabled.
+	flag.StringVar(&cfg.inbound.genericSecret, "inbound-generic-secret", os.Getenv("IM_INBOUND_GENERIC_SECRET"), "Signing secret for the generic inbound channel")
+	flag.StringVar(&cfg.inbound.smsSecret, "inbound-sms-secret", os.Getenv("IM_INBOUND_SMS_SECRET"), "Signing secret for the SMS inbound channel")
 
 	flag.Parse()
 
+	if cfg.blocks.sends != "reject" && cfg.blocks.sends != "drop" {
+		fmt.Fprintf(os.Stderr, "invalid -blocked-sends %q\n", cfg.blocks.sends)
+		os.Exit(2)
+	}
+
+	if cfg.limiter.rps <= 0 || cfg.limiter.sendRPS <= 0 || cfg.limiter.authRPS <= 0 || cfg.broadcasts.rps <= 0 || cfg.contactImports.rps <= 0 {
+		fmt.Fprintln(os.Stderr, "rate limiter rates must be greater than zero")
+		os.Exit(2)
+	}
+
+	if cfg.apiKeys.auditWindow <= 0 {
+		fmt.Fprintln(os.Stderr, "-api-key-audit-window must be greater than zero")
+		os.Exit(2)
+	}
+
+	if cfg.presence.statusTTL <= 0 || cfg.presence.lastSeenRetention <= 0 {
+		fmt.Fprintln(os.Stderr, "-presence-ttl and -presence-last-seen-retention must be greater than zero")
+		os.Exit(2)
+	}
+
+	if cfg.typing.throttle <= 0 {
+		fmt.Fprintln(os.Stderr, "-typing-throttle must be greater than zero")
+		os.Exit(2)
+	}
+
+	if cfg.queue.mode != "direct" && cfg.queue.mode != "outbox" {
+		fmt.Fprintf(os.Stderr, "invalid -queue-mode %q\n", cfg.queue.mode)
+		os.Exit(2)
+	}
+
+	if cfg.queue.outbox.relayInterval <= 0 || cfg.queue.outbox.batchSize <= 0 || cfg.queue.outbox.retention <= 0 {
+		fmt.Fprintln(os.Stderr, "-outbox-relay-interval, -outbox-batch-size and -outbox-retention must be greater than zero")
+		os.Exit(2)
+	}
+
+	cfg.limiter.policies = map[string]ratelimit.Policy{
+		rateLimitDefault:       {Rate: cfg.limiter.rps, Burst: cfg.limiter.burst},
+		rateLimitSend:          {Rate: cfg.limiter.sendRPS, Burst: cfg.limiter.sendBurst},
+		rateLimitAuthFailures:  {Rate: cfg.limiter.authRPS, Burst: cfg.limiter.authBurst},
+		rateLimitBroadcast:     {Rate: cfg.broadcasts.rps, Burst: cfg.broadcasts.burst},
+		rateLimitContactImport: {Rate: cfg.contactImports.rps, Burst: cfg.contactImports.burst},
+	}
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	db, err := openDB(cfg)
@@ -110,6 +284,47 @@ func main() {
 		models: models,
 		redis:  rdb,
 		queue:  messageQueue,
+		presence: presence.NewTracker(rdb, presence.Config{
+			StatusTTL:         cfg.presence.statusTTL,
+			LastSeenRetention: cfg.presence.lastSeenRetention,
+		}),
+		events:   events.NewBus(rdb),
+		limiter:  ratelimit.NewLimiter(rdb),
+		webhooks: webhooks.NewPublisher(rdb, cfg.redis.webhooks.streamKey),
+		audit:    audit.NewPublisher(rdb, cfg.redis.audit.streamKey),
+		inbound:  make(map[string]inbound.Channel),
+	}
+
+	if cfg.inbound.genericSecret != "" {
+		channel := &inbound.Generic{Secret: cfg.inbound.genericSecret}
+		app.inbound[channel.Name()] = channel
+	}
+	if cfg.inbound.smsSecret != "" {
+		channel := &inbound.SMS{Secret: cfg.inbound.smsSecret}
+		app.inbound[channel.Name()] = channel
+	}
+
+	if cfg.spam.enabled {
+		app.spam = moderation.Chain{
+			&moderation.LinkBlocklist{Domains: splitList(strings.ToLower(cfg.spam.blockedDomains))},
+			&moderation.KeywordBlocklist{Keywords: splitList(cfg.spam.blockedKeywords)},
+			&moderation.NewReceiverRate{Client: rdb, Models: models, Limit: cfg.spam.newReceivers, Window: cfg.spam.newReceiversWindow},
+			&moderation.DuplicateContent{Client: rdb, Limit: cfg.spam.duplicateReceivers, Window: cfg.spam.duplicateWindow},
+		}
+	}
+
+	if cfg.queue.mode == "outbox" {
+		app.outbox = queue.NewOutbox(
+			messageQueue,
+			queue.OutboxConfig{
+				RelayInterval: cfg.queue.outbox.relayInterval,
+				BatchSize:     cfg.queue.outbox.batchSize,
+				Retention:     cfg.queue.outbox.retention,
+			},
+			logger,
+			models,
+		)
+		app.queue = app.outbox
 	}
 
 	err = app.serve()
@@ -119,6 +334,17 @@ func main() {
 	}
 }
 
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func initRedis(cfg config) (*redis.Client, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     cfg.redis.addr,
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/moderation"
+	"github.com/araaavind/zoko-im/internal/policy"
 	"github.com/araaavind/zoko-im/internal/validator"
 )
 
@@ -26,28 +28,14 @@ func (app *application) sendMessage(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
 	defer cancel()
 
-	_, err = app.models.Users.Get(ctx, senderID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-		} else {
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-
-	_, err = app.models.Users.Get(ctx, receiverID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-		} else {
-			app.serverErrorResponse(w, r, err)
-		}
+	if !app.checkSend(ctx, w, r, senderID, receiverID) {
 		return
 	}
 
 	var input struct {
-		Content string `json:"content"`
+		Content          string     `json:"content"`
+		CannedResponseID *int64     `json:"canned_response_id"`
+		SendAt           *time.Time `json:"send_at"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -58,31 +46,118 @@ func (app *application) sendMessage(w http.ResponseWriter, r *http.Request) {
 
 	v := validator.New()
 
+	if input.CannedResponseID != nil {
+		v.Check(input.Content == "", "content", "Must not be set together with canned_response_id")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		canned, err := app.models.CannedResponses.GetVisible(ctx, app.contextGetOrganization(r), *input.CannedResponseID, senderID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				v.AddError("canned_response_id", "No canned response with this ID exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		input.Content = canned.Body
+	}
+
 	message := &data.Message{
-		Timestamp:  time.Now(),
-		Content:    input.Content,
-		SenderID:   senderID,
-		ReceiverID: receiverID,
-		ReadStatus: false,
+		OrganizationID: app.contextGetOrganization(r),
+		Timestamp:      time.Now(),
+		Content:        input.Content,
+		SenderID:       senderID,
+		ReceiverID:     receiverID,
+		ReadStatus:     false,
+		Kind:           data.MessageKindText,
 	}
 
-	if data.ValidateMessage(v, message); !v.Valid() {
+	data.ValidateMessage(v, message)
+	if input.SendAt != nil {
+		data.ValidateSendAt(v, *input.SendAt)
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Messages are filtered when they are sent rather than when they are
+	// delivered, so scheduling cannot be used to get around the filters
+	violation, err := app.spam.Check(ctx, message)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if violation != nil {
+		app.quarantineMessage(ctx, w, r, message, violation)
+		return
+	}
+
+	if input.SendAt != nil {
+		app.scheduleMessage(w, r, message, *input.SendAt, input.CannedResponseID)
+		return
+	}
+
 	err = app.queue.EnqueueMessage(r.Context(), message)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if input.CannedResponseID != nil {
+		app.recordCannedResponseUse(*input.CannedResponseID)
+	}
+
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "Message queued for processing"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// checkSend verifies that both users exist, that the sender is not suspended
+// and that the sender may message the receiver. It returns false when it has
+// already responded, which includes blocked sends that are silently dropped.
+func (app *application) checkSend(ctx context.Context, w http.ResponseWriter, r *http.Request, senderID, receiverID int64) bool {
+	users, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), []int64{senderID, receiverID})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	err = policy.CanSend(findUser(users, senderID))
+	if err != nil {
+		app.messagingNotAllowedResponse(w, r, err)
+		return false
+	}
+
+	err = app.canMessage(ctx, senderID, findUser(users, receiverID))
+	if err != nil {
+		if app.dropBlockedSend(err) {
+			// Respond exactly as for an accepted message so the sender cannot
+			// tell that they have been blocked
+			app.logger.Info("dropped message to blocking user", "sender_id", senderID, "receiver_id", receiverID)
+			err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "Message queued for processing"}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		} else {
+			app.messagingNotAllowedResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
 func (app *application) listMessages(w http.ResponseWriter, r *http.Request) {
 	senderID, err := app.readIDParam(r, "sender_id")
 	if err != nil || senderID < 1 {
@@ -112,7 +187,29 @@ func (app *application) listMessages(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
 	defer cancel()
 
-	messages, metadata, err := app.models.Messages.GetAllForSenderReceiver(ctx, senderID, receiverID, filters)
+	// Users of other organizations are reported as not found
+	_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), receiverID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Conversations involving a blocked user are hidden from both sides
+	blocked, blockedBy, err := app.models.Blocks.Between(ctx, senderID, receiverID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if blocked || blockedBy {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	messages, metadata, err := app.models.Messages.GetAllForSenderReceiver(ctx, app.contextGetOrganization(r), senderID, receiverID, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -134,7 +231,7 @@ func (app *application) readMessage(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
 	defer cancel()
 
-	err = app.models.Messages.UpdateStatus(ctx, messageID, true)
+	message, err := app.models.Messages.Get(ctx, app.contextGetOrganization(r), messageID)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -144,8 +241,169 @@ func (app *application) readMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the receiver can mark a message as read. API keys act for the
+	// receiver, whoever it is.
+	if app.contextGetAPIKey(r) == nil && message.ReceiverID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Messages.UpdateStatus(ctx, app.contextGetOrganization(r), messageID, true)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publishWebhookEvent(app.contextGetOrganization(r), data.EventMessageRead, messageID)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"status": "read"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) forwardMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, err := app.readIDParam(r, "message_id")
+	if err != nil || messageID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		SenderID    int64   `json:"sender_id"`
+		ReceiverIDs []int64 `json:"receiver_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Users forward as themselves. API keys name the participant they forward as.
+	senderID, ok := app.actingUserID(r, input.SenderID)
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(senderID > 0, "sender_id", "Must be given when authenticating with an API key")
+	if data.ValidateForwardRecipients(v, input.ReceiverIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	original, err := app.models.Messages.Get(ctx, app.contextGetOrganization(r), messageID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only a participant of the original conversation may forward the message.
+	// Anyone else gets the same response as for a missing message.
+	if original.SenderID != senderID && original.ReceiverID != senderID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Quarantined messages are only visible to their sender until reviewed
+	if original.ModerationStatus == data.ModerationQuarantined {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if original.Kind == data.MessageKindSystem {
+		v.AddError("message_id", "System messages cannot be forwarded")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), append([]int64{senderID}, input.ReceiverIDs...))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("receiver_ids", "All receivers must be existing users")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = policy.CanSend(findUser(users, senderID))
+	if err != nil {
+		app.messagingNotAllowedResponse(w, r, err)
+		return
+	}
+
+	// Check every receiver before enqueueing anything so that a forward is
+	// either accepted or rejected as a whole
+	forwards := make([]*data.Message, 0, len(input.ReceiverIDs))
+	for _, receiverID := range input.ReceiverIDs {
+		err = app.canMessage(ctx, senderID, findUser(users, receiverID))
+		if err != nil {
+			if app.dropBlockedSend(err) {
+				continue
+			}
+			app.messagingNotAllowedResponse(w, r, err)
+			return
+		}
+		forwards = append(forwards, original.Forward(senderID, receiverID))
+	}
+
+	// Forwards caught by the spam filters are held back like any other send
+	var (
+		held       []*data.Message
+		violations []*moderation.Violation
+		queued     []*data.Message
+	)
+	for _, message := range forwards {
+		violation, err := app.spam.Check(ctx, message)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if violation != nil {
+			held = append(held, message)
+			violations = append(violations, violation)
+		} else {
+			queued = append(queued, message)
+		}
+	}
+
+	// Held forwards are stored first, since the receivers never see them, so a
+	// failure cannot leave only some of the forwards delivered
+	if len(held) > 0 {
+		err = app.holdMessages(ctx, held, violations)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.queue.EnqueueMessages(r.Context(), queued)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Dropped forwards count as accepted, so the count cannot reveal which
+	// receivers have blocked the sender
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "Messages queued for processing", "count": len(input.ReceiverIDs)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
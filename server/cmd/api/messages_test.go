@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// recordingQueue collects the messages enqueued instead of sending them
+type recordingQueue struct {
+	mu       sync.Mutex
+	messages []*data.Message
+}
+
+func (q *recordingQueue) EnqueueMessage(ctx context.Context, message *data.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, message)
+	return nil
+}
+
+func (q *recordingQueue) EnqueueMessages(ctx context.Context, messages []*data.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, messages...)
+	return nil
+}
+
+// TestForwardDroppedCount checks that forwards dropped because a receiver
+// blocked the sender are still counted as accepted, so that the response does
+// not reveal the block
+func TestForwardDroppedCount(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.blocks.sends = "drop"
+
+	q := &recordingQueue{}
+	app.queue = q
+
+	ctx := context.Background()
+	tn := newTenant(t, app)
+
+	blocker := &data.Member{FullName: "Blocker", Role: data.RoleEndUser}
+	err := app.models.Organizations.AddMember(ctx, tn.organization.ID, blocker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Blocks.Insert(ctx, &data.Block{BlockerID: blocker.UserID, BlockedID: tn.owner.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &data.Message{
+		OrganizationID:   tn.organization.ID,
+		Timestamp:        time.Now(),
+		Content:          "Hello",
+		SenderID:         tn.owner.UserID,
+		ReceiverID:       tn.member.UserID,
+		Kind:             data.MessageKindText,
+		ModerationStatus: data.ModerationClean,
+	}
+	err = app.models.Messages.Insert(ctx, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"receiver_ids":[%d,%d]}`, tn.member.UserID, blocker.UserID)
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/messages/%d/forward", message.ID), strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+tn.token)
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	var response struct {
+		Count int `json:"count"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Count != 2 {
+		t.Errorf("got count %d; want 2", response.Count)
+	}
+
+	if len(q.messages) != 1 || q.messages[0].ReceiverID != tn.member.UserID {
+		t.Errorf("got %d enqueued forwards; want only the one to the member", len(q.messages))
+	}
+}
@@ -1,15 +1,50 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/presence"
+	"github.com/araaavind/zoko-im/internal/ratelimit"
+	"github.com/araaavind/zoko-im/internal/validator"
 	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 )
 
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestID gives every request an ID, which is sent back in the X-Request-ID
+// header and recorded in logs and audit events. IDs set by a proxy in front
+// of the API are kept if they look sane.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+
+		if !requestIDRX.MatchString(requestID) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			requestID = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, requestID))
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Create a deferred function (which will always be run in the event of a panic
@@ -27,54 +62,532 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// Rate limit policies that routes can opt into. Every request is counted
+// against the default policy, and routes wrapped with limitRoute are counted
+// against theirs as well. Failed authentications and broadcast recipients are
+// counted against their own policies.
+const (
+	rateLimitDefault       = "default"
+	rateLimitSend          = "send"
+	rateLimitAuthFailures  = "auth_failures"
+	rateLimitBroadcast     = "broadcast"
+	rateLimitContactImport = "contact_import"
+)
+
+// rateLimitPolicy returns the named policy and the identity whose bucket the
+// request spends from: the API key, the authenticated user, or else the
+// client's IP address. API keys carry their own default policy.
+func (app *application) rateLimitPolicy(r *http.Request, name string) (string, ratelimit.Policy) {
+	policy := app.config.limiter.policies[name]
+
+	if apiKey := app.contextGetAPIKey(r); apiKey != nil {
+		if name == rateLimitDefault {
+			policy = ratelimit.Policy{Rate: float64(apiKey.RateLimitRPS), Burst: apiKey.RateLimitBurst}
+		}
+		return fmt.Sprintf("api_key:%d", apiKey.ID), policy
+	}
+
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		return fmt.Sprintf("user:%d", user.ID), policy
+	}
+
+	return "ip:" + realip.FromRequest(r), policy
+}
+
+// allowRequest counts the request against the named policy and sets the
+// RateLimit headers. It responds with 429 and returns false if the request is
+// over the limit. Requests are let through if Redis cannot be reached, so that
+// the limiter never takes the API down with it.
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !app.config.limiter.enabled {
+		return true
+	}
+
+	identity, policy := app.rateLimitPolicy(r, name)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	result, err := app.limiter.Allow(ctx, name+":"+identity, policy)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Burst, int(math.Ceil(float64(policy.Burst)/policy.Rate))))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.ResetAfter.Seconds()))))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// allowUserCost spends cost tokens from a user's budget for the named policy,
+// which every API replica shares. It responds with 429 and returns false if
+// the budget cannot cover the cost. Requests are let through if Redis cannot
+// be reached, like other rate limited requests.
+func (app *application) allowUserCost(w http.ResponseWriter, r *http.Request, name string, userID int64, cost int) bool {
+	if !app.config.limiter.enabled {
+		return true
+	}
+
+	policy := app.config.limiter.policies[name]
+
+	// Costs above the burst can never be allowed, so there is no point in
+	// retrying
+	if cost > policy.Burst {
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	result, err := app.limiter.AllowN(ctx, fmt.Sprintf("%s:user:%d", name, userID), policy, cost)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	// Start a background goroutine which removes old entries from the clients map every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			// Take mutex lock for clients map
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.allowRequest(w, r, rateLimitDefault) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRoute counts requests to the route against a stricter policy on top of
+// the default one
+func (app *application) limitRoute(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.allowRequest(w, r, name) {
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// limitAuthFailures rejects requests from IP addresses that have presented too
+// many invalid credentials, before their credentials cost another lookup. Only
+// failed authentications spend from an address's budget, see
+// countAuthFailure, so clients that authenticate correctly are never limited
+// here.
+func (app *application) limitAuthFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := app.config.limiter.policies[rateLimitAuthFailures]
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+		defer cancel()
+
+		// Spending nothing reports how many failures the address has left
+		result, err := app.limiter.AllowN(ctx, authFailuresKey(r), policy, 0)
+		if err != nil {
+			app.logError(r, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if result.Remaining < 1 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/policy.Rate))))
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func authFailuresKey(r *http.Request) string {
+	return rateLimitAuthFailures + ":ip:" + realip.FromRequest(r)
+}
+
+// countAuthFailure spends one of the client's failed authentications
+func (app *application) countAuthFailure(r *http.Request) {
+	if !app.config.limiter.enabled {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err := app.limiter.Allow(ctx, authFailuresKey(r), app.config.limiter.policies[rateLimitAuthFailures])
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// authenticate adds the user holding the request's bearer token to its
+// context. Requests without an Authorization header are anonymous.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			next.ServeHTTP(w, app.contextSetUser(r, data.AnonymousUser))
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		if data.IsAPIKey(token) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+		defer cancel()
+
+		user, err := app.models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.invalidAuthenticationTokenResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
 			}
-			mu.Unlock()
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetUser(r, user))
+	})
+}
+
+// authenticateAPIKey is the authenticate middleware's path for requests made by
+// backend integrations. Every request made with a key is counted against it
+// and logged with the key's ID.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	key, err := app.models.APIKeys.GetForPlaintext(ctx, plaintext)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.invalidAuthenticationTokenResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Info("api key request",
+		"api_key_id", key.ID,
+		"organization_id", key.OrganizationID,
+		"method", r.Method,
+		"uri", r.URL.RequestURI())
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err := app.models.APIKeys.RecordUsage(ctx, key.ID)
+		if err != nil {
+			app.logger.Error("failed to record api key usage", "api_key_id", key.ID, "error", err)
+		}
+	})
+
+	r = app.contextSetAPIKey(r, key)
+	app.auditAPIKeyUse(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// auditAPIKeyUse records the first use of an API key in each audit window, with
+// the request that used it. Auditing every request would flood the audit log,
+// while the log line above still records each one.
+func (app *application) auditAPIKeyUse(r *http.Request, key *data.APIKey) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		allowed, err := app.events.Allow(ctx, fmt.Sprintf("api_key_used:%d", key.ID), app.config.apiKeys.auditWindow)
+		if err != nil {
+			app.logger.Error("failed to check api key audit window", "api_key_id", key.ID, "error", err)
+			return
+		}
+		if !allowed {
+			return
 		}
-	}()
 
+		app.recordAudit(r, &data.AuditEvent{
+			OrganizationID: key.OrganizationID,
+			Action:         data.AuditAPIKeyUsed,
+			TargetType:     data.TargetAPIKey,
+			TargetID:       strconv.FormatInt(key.ID, 10),
+		}, nil, envelope{"method": r.Method, "path": r.URL.Path})
+	})
+}
+
+// organization sets the organization a request acts in, which is always that
+// of the authenticated user or API key. The X-Organization-ID header can only
+// confirm it: anonymous requests that send it are rejected, so that nobody can
+// select a tenant without credentials for it. Anonymous requests act in no
+// organization, so any tenant data they look up is not found.
+func (app *application) organization(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			ip := realip.FromRequest(r)
-
-			// Take mutex lock for clients map
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
+		w.Header().Add("Vary", "X-Organization-ID")
+
+		user := app.contextGetUser(r)
+		header := r.Header.Get("X-Organization-ID")
+
+		if user.IsAnonymous() {
+			if header != "" {
+				app.authenticationRequiredResponse(w, r)
+				return
 			}
+			next.ServeHTTP(w, app.contextSetOrganization(r, 0))
+			return
+		}
 
-			clients[ip].lastSeen = time.Now()
+		if header != "" {
+			id, err := strconv.ParseInt(header, 10, 64)
+			if err != nil || id < 1 {
+				app.errorResponse(w, r, http.StatusBadRequest, "invalid X-Organization-ID header")
+				return
+			}
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+			if id != user.OrganizationID {
+				app.forbiddenResponse(w, r, "You are not a member of this organization")
 				return
 			}
-			mu.Unlock()
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, app.contextSetOrganization(r, user.OrganizationID))
 	})
 }
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requirePermission only lets authenticated users whose role grants the
+// permission code through
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+		defer cancel()
+
+		permissions, err := app.contextGetPermissions(ctx, r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// requireMember responds with 404 unless the user acting through the
+// :sender_id route parameter belongs to the request's organization, so that
+// nothing owned by users of other organizations can be reached
+func (app *application) requireMember(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := app.readIDParam(r, "sender_id")
+		if err != nil || userID < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+		defer cancel()
+
+		_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.notFoundResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireSelf only lets users act through the :sender_id route parameter as
+// themselves. API keys act for their organization's backend, so they may act as
+// any member, which requireMember checks.
+func (app *application) requireSelf(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			next(w, r)
+			return
+		}
+
+		userID, err := app.readIDParam(r, "sender_id")
+		if err != nil || userID != app.contextGetUser(r).ID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireActingUser guards routes that act as the user named by the :sender_id
+// route parameter. Reads need the messages:read permission and everything else
+// needs messages:send.
+func (app *application) requireActingUser(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requirePermission(code, app.requireSelf(app.requireMember(next)))
+}
+
+// requireSelfOrAgent is requireSelf for routes where the :sender_id route
+// parameter names an inbox, which its agents may also act as
+func (app *application) requireSelfOrAgent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			next(w, r)
+			return
+		}
+
+		inboxID, err := app.readIDParam(r, "sender_id")
+		if err != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		user := app.contextGetUser(r)
+		if inboxID == user.ID {
+			next(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+		defer cancel()
+
+		isAgent, err := app.models.Inboxes.IsAgent(ctx, app.contextGetOrganization(r), inboxID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !isAgent {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireInboxUser is requireActingUser for routes that the inbox's agents
+// use to work its conversations
+func (app *application) requireInboxUser(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requirePermission(code, app.requireSelfOrAgent(app.requireMember(next)))
+}
+
+// statusRecorder remembers the status code of the response it writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// trackActivity marks the user acting through the :sender_id route parameter
+// as online once the request has succeeded. Failed requests are not activity,
+// so they cannot keep a user online. The presence update runs in the
+// background so it never delays the response.
+func (app *application) trackActivity(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sr := &statusRecorder{ResponseWriter: w}
+		next(sr, r)
+
+		// Handlers that write nothing respond with 200 OK
+		if sr.status != 0 && (sr.status < 200 || sr.status > 299) {
+			return
+		}
+
+		userID, err := app.readIDParam(r, "sender_id")
+		if err != nil || userID < 1 {
+			return
+		}
+
+		app.background(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			_, err := app.updatePresence(ctx, userID, presence.StatusOnline)
+			if err != nil {
+				app.logger.Error("failed to update presence", "user_id", userID, "error", err)
+			}
+		})
+	}
+}
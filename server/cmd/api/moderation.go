@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/moderation"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// quarantineMessage stores a message stopped by the spam filters instead of
+// enqueueing it and tells the sender it is held for review
+func (app *application) quarantineMessage(ctx context.Context, w http.ResponseWriter, r *http.Request, message *data.Message, violation *moderation.Violation) {
+	err := app.holdMessage(ctx, message, violation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "Message held for review"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// holdMessage stores a message stopped by the spam filters as quarantined. The
+// receiver never sees it unless a moderator releases it, and the
+// organization's moderators are told about it.
+func (app *application) holdMessage(ctx context.Context, message *data.Message, violation *moderation.Violation) error {
+	return app.holdMessages(ctx, []*data.Message{message}, []*moderation.Violation{violation})
+}
+
+// holdMessages quarantines several messages like holdMessage, in a single
+// transaction. violations holds the violation of each message, at the same
+// index.
+func (app *application) holdMessages(ctx context.Context, messages []*data.Message, violations []*moderation.Violation) error {
+	for _, message := range messages {
+		message.ModerationStatus = data.ModerationQuarantined
+	}
+
+	err := app.models.Messages.BulkInsert(ctx, messages)
+	if err != nil {
+		return err
+	}
+
+	for i, message := range messages {
+		app.logger.Info("message quarantined",
+			"message_id", message.ID,
+			"sender_id", message.SenderID,
+			"receiver_id", message.ReceiverID,
+			"filter", violations[i].Filter)
+
+		app.notifyModerators(message.OrganizationID, events.Event{
+			Type: moderation.EventQuarantined,
+			Data: envelope{"message": message, "violation": violations[i]},
+		})
+	}
+
+	return nil
+}
+
+// notifyModerators publishes an event to the members of the organization who
+// review moderation
+func (app *application) notifyModerators(organizationID int64, event events.Event) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		moderatorIDs, err := app.models.Permissions.GetUserIDsWithPermission(ctx, organizationID, data.PermissionModeration)
+		if err != nil {
+			app.logger.Error("failed to load moderators", "error", err, "organization_id", organizationID, "event", event.Type)
+			return
+		}
+
+		err = app.events.Publish(ctx, event, moderatorIDs...)
+		if err != nil {
+			app.logger.Error("failed to publish moderation event", "error", err, "organization_id", organizationID, "event", event.Type)
+		}
+	})
+}
+
+// listQuarantined returns a page of the messages the spam filters held back in
+// the organization, newest first
+func (app *application) listQuarantined(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+
+	filters.Cursor = app.readTime(qs, "cursor", time.Now(), v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	messages, metadata, err := app.models.Messages.GetQuarantined(ctx, app.contextGetOrganization(r), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"messages": messages, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// releaseMessage lets a moderator deliver a message the spam filters held back
+// by mistake. It shows up in the conversation at the time it was sent.
+func (app *application) releaseMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, err := app.readIDParam(r, "message_id")
+	if err != nil || messageID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organizationID := app.contextGetOrganization(r)
+
+	err = app.models.Messages.Release(ctx, organizationID, messageID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditMessageReleased,
+		TargetType: data.TargetMessage,
+		TargetID:   strconv.FormatInt(messageID, 10),
+	}, envelope{"moderation_status": data.ModerationQuarantined}, envelope{"moderation_status": data.ModerationClean})
+
+	app.publishWebhookEvent(organizationID, data.EventMessagePersisted, messageID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Message released"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// createOrganization signs a business up. The owner given in the request
+// becomes the organization's first member and can log in to add the others.
+func (app *application) createOrganization(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name  string `json:"name"`
+		Owner struct {
+			FullName string `json:"full_name"`
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		} `json:"owner"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	organization := &data.Organization{Name: input.Name}
+
+	owner := &data.Member{
+		FullName: input.Owner.FullName,
+		Email:    data.NormalizeEmail(input.Owner.Email),
+		Role:     data.RoleOwner,
+	}
+
+	v := validator.New()
+
+	data.ValidateOrganization(v, organization)
+	data.ValidateEmail(v, owner.Email)
+	data.ValidatePasswordPlaintext(v, input.Owner.Password)
+
+	if data.ValidateMember(v, owner); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = owner.Password.Set(input.Owner.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.Organizations.InsertWithOwner(ctx, organization, owner)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			v.AddError("email", "A user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": organization, "owner": owner}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrganization(w http.ResponseWriter, r *http.Request) {
+	organizationID, err := app.readIDParam(r, "organization_id")
+	if err != nil || organizationID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organization, err := app.models.Organizations.Get(ctx, organizationID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMembers(w http.ResponseWriter, r *http.Request) {
+	organizationID, err := app.readOrganizationParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	members, err := app.models.Organizations.GetMembers(ctx, organizationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOrganizationParam returns the :organization_id route parameter. Members
+// can only be managed from within their own organization, so any other
+// organization is reported as not found.
+func (app *application) readOrganizationParam(r *http.Request) (int64, error) {
+	organizationID, err := app.readIDParam(r, "organization_id")
+	if err != nil || organizationID != app.contextGetOrganization(r) {
+		return 0, errors.New("invalid organization_id parameter")
+	}
+
+	return organizationID, nil
+}
+
+// createMember adds a new user to the organization. Members with an email and
+// password can log in. Only members who can change roles may add owners and
+// admins.
+func (app *application) createMember(w http.ResponseWriter, r *http.Request) {
+	organizationID, err := app.readOrganizationParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		FullName string  `json:"full_name"`
+		Email    string  `json:"email"`
+		Password *string `json:"password"`
+		Role     string  `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	member := &data.Member{
+		FullName: input.FullName,
+		Email:    data.NormalizeEmail(input.Email),
+		Role:     input.Role,
+	}
+
+	if member.Role == "" {
+		member.Role = data.RoleEndUser
+	}
+
+	v := validator.New()
+
+	data.ValidateMember(v, member)
+
+	if input.Password != nil {
+		v.Check(member.Email != "", "email", "Email is required to set a password")
+		data.ValidatePasswordPlaintext(v, *input.Password)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Password != nil {
+		err = member.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	if member.Role == data.RoleOwner || member.Role == data.RoleAdmin {
+		permissions, err := app.contextGetPermissions(ctx, r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(data.PermissionRolesWrite) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.Organizations.AddMember(ctx, organizationID, member)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			v.AddError("email", "A user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Members created with a privileged role are granted it without a role
+	// change, so the grant is audited here
+	if member.Role == data.RoleOwner || member.Role == data.RoleAdmin {
+		app.recordAudit(r, &data.AuditEvent{
+			OrganizationID: organizationID,
+			Action:         data.AuditRoleChanged,
+			TargetType:     data.TargetUser,
+			TargetID:       strconv.FormatInt(member.UserID, 10),
+		}, nil, envelope{"role": member.Role})
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMemberRole changes a member's role. Neither the owner's role nor the
+// caller's own can be changed, so an organization is never left without the
+// owner who set it up.
+func (app *application) updateMemberRole(w http.ResponseWriter, r *http.Request) {
+	organizationID, err := app.readOrganizationParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readIDParam(r, "user_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, input.Role)
+	v.Check(userID != app.contextGetUser(r).ID, "user_id", "You cannot change your own role")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	member, err := app.models.Organizations.GetMember(ctx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if member.Role == data.RoleOwner {
+		v.AddError("user_id", "The owner's role cannot be changed")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previousRole := member.Role
+
+	err = app.models.Organizations.SetRole(ctx, organizationID, userID, input.Role)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member.Role = input.Role
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditRoleChanged,
+		TargetType: data.TargetUser,
+		TargetID:   strconv.FormatInt(userID, 10),
+	}, envelope{"role": previousRole}, envelope{"role": member.Role})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
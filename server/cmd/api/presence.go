@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/presence"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// maxPresenceBatch caps how many users can be looked up in one batch request
+const maxPresenceBatch = 100
+
+// updatePresence records the user's status and, if it changed, notifies
+// everyone who has the user in their address book
+func (app *application) updatePresence(ctx context.Context, userID int64, status string) (*presence.Presence, error) {
+	p, changed, err := app.presence.Update(ctx, userID, status)
+	if err != nil {
+		return nil, err
+	}
+
+	if !changed {
+		return p, nil
+	}
+
+	watcherIDs, err := app.models.Contacts.GetOwnerIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.events.Publish(ctx, events.Event{Type: "presence", Data: p}, watcherIDs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// visiblePresence returns the presence of the users of the request's
+// organization, leaving out those who have blocked the user making the
+// request. API keys see every member. It returns ErrRecordNotFound if any of
+// the users is in another organization.
+func (app *application) visiblePresence(ctx context.Context, r *http.Request, userIDs []int64) ([]*presence.Presence, error) {
+	_, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.contextGetAPIKey(r) == nil {
+		blockers, err := app.models.Blocks.GetBlockers(ctx, app.contextGetUser(r).ID, userIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		visible := make([]int64, 0, len(userIDs))
+		for _, id := range userIDs {
+			if !blockers[id] {
+				visible = append(visible, id)
+			}
+		}
+		userIDs = visible
+	}
+
+	if len(userIDs) == 0 {
+		return []*presence.Presence{}, nil
+	}
+
+	return app.presence.Get(ctx, userIDs...)
+}
+
+// showPresence looks up the presence of one member of the organization. Unlike
+// other /v1/users/ routes, :sender_id names the user being looked up rather
+// than the one making the request.
+func (app *application) showPresence(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	presences, err := app.visiblePresence(ctx, r, []int64{userID})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Users who have blocked the caller look like missing ones
+	if len(presences) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"presence": presences[0]}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listPresence looks up the presence of many members of the organization at
+// once. Users who have blocked the caller are left out.
+func (app *application) listPresence(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	userIDs := []int64{}
+	for _, s := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+		if s == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("user_ids", "Must be a comma-separated list of user IDs")
+			break
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	v.Check(len(userIDs) > 0, "user_ids", "At least one user ID is required")
+	v.Check(len(userIDs) <= maxPresenceBatch, "user_ids", "Must not contain more than 100 user IDs")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	// Presence of users in other organizations is not visible
+	presences, err := app.visiblePresence(ctx, r, userIDs)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"presence": presences}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// heartbeat is called periodically by real-time clients to keep a user online,
+// or to report that they are away or have disconnected
+func (app *application) heartbeat(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(presence.ValidStatus(input.Status), "status", "Must be one of online, away or offline"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err = app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	p, err := app.updatePresence(ctx, userID, input.Status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"presence": p}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// TestPresenceHidesBlockers checks that users who have blocked the caller look
+// missing when their presence is looked up alone and are left out of batch
+// lookups
+func TestPresenceHidesBlockers(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	tn := newTenant(t, app)
+
+	err := app.models.Blocks.Insert(ctx, &data.Block{BlockerID: tn.member.UserID, BlockedID: tn.owner.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := app.routes()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer "+tn.token)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(fmt.Sprintf("/v1/users/%d/presence", tn.member.UserID))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a single lookup; want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+
+	w = get(fmt.Sprintf("/v1/presence?user_ids=%d", tn.member.UserID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d for a batch lookup; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var response struct {
+		Presence []json.RawMessage `json:"presence"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Presence) != 0 {
+		t.Errorf("got %d presences; want none", len(response.Presence))
+	}
+}
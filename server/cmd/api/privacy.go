@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/policy"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// canMessage enforces blocks and the receiver's privacy settings. It has to pass
+// before a message from senderID to receiver is enqueued.
+func (app *application) canMessage(ctx context.Context, senderID int64, receiver *data.User) error {
+	return policy.CanMessage(ctx, app.models, senderID, receiver)
+}
+
+// dropBlockedSend reports whether a send rejected by canMessage should be
+// accepted and silently discarded instead of failing
+func (app *application) dropBlockedSend(err error) bool {
+	return errors.Is(err, policy.ErrReceiverBlockedSender) && app.config.blocks.sends == "drop"
+}
+
+func (app *application) messagingNotAllowedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, policy.ErrSenderBlockedReceiver):
+		app.forbiddenResponse(w, r, "You have blocked this user. Unblock them to send messages")
+	case errors.Is(err, policy.ErrReceiverBlockedSender):
+		app.forbiddenResponse(w, r, "You cannot send messages to this user")
+	case errors.Is(err, policy.ErrMessagingRestricted):
+		app.forbiddenResponse(w, r, "This user is not accepting messages from you")
+	case errors.Is(err, policy.ErrSenderSuspended):
+		app.forbiddenResponse(w, r, "Your account has been suspended")
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBlocks(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	blocks, err := app.models.Blocks.GetAllForBlocker(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"blocks": blocks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) blockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	blockedID, err := app.readIDParam(r, "blocked_id")
+	if err != nil || blockedID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(userID != blockedID, "blocked_id", "You cannot block yourself"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	_, err = app.models.Users.GetMany(ctx, app.contextGetOrganization(r), []int64{userID, blockedID})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	block := &data.Block{
+		BlockerID: userID,
+		BlockedID: blockedID,
+	}
+
+	err = app.models.Blocks.Insert(ctx, block)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"block": block}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unblockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	blockedID, err := app.readIDParam(r, "blocked_id")
+	if err != nil || blockedID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.Blocks.Delete(ctx, app.contextGetOrganization(r), userID, blockedID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "User unblocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPrivacy(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	user, err := app.models.Users.Get(ctx, app.contextGetOrganization(r), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"privacy": envelope{"message_privacy": user.MessagePrivacy}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePrivacy(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "sender_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		MessagePrivacy string `json:"message_privacy"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMessagePrivacy(v, input.MessagePrivacy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	user := &data.User{
+		ID:             userID,
+		MessagePrivacy: input.MessagePrivacy,
+	}
+
+	err = app.models.Users.UpdateMessagePrivacy(ctx, user)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"privacy": envelope{"message_privacy": user.MessagePrivacy}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
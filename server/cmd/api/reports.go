@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/moderation"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// reportContextSize is how many earlier messages of the conversation are shown
+// with a reported message
+const reportContextSize = 5
+
+// reviewItem is a report together with what a moderator needs to judge it
+type reviewItem struct {
+	Report  *data.Report    `json:"report"`
+	Message *data.Message   `json:"message,omitempty"`
+	Context []*data.Message `json:"context,omitempty"`
+}
+
+// reportMessage lets the receiver of a message flag it for the organization's
+// moderators
+func (app *application) reportMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, err := app.readIDParam(r, "message_id")
+	if err != nil || messageID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ReporterID int64  `json:"reporter_id"`
+		Reason     string `json:"reason"`
+		Details    string `json:"details"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Users report as themselves. API keys name the receiver they report for.
+	reporterID, ok := app.actingUserID(r, input.ReporterID)
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	report := &data.Report{
+		OrganizationID: app.contextGetOrganization(r),
+		MessageID:      &messageID,
+		ReporterID:     reporterID,
+		Reason:         input.Reason,
+		Details:        input.Details,
+	}
+
+	v := validator.New()
+
+	v.Check(reporterID > 0, "reporter_id", "Must be given when authenticating with an API key")
+	if data.ValidateReport(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	message, err := app.models.Messages.Get(ctx, report.OrganizationID, messageID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the receiver can report a message. Anyone else, including the
+	// receiver of a quarantined message they never got, gets the same response
+	// as for a missing message.
+	if message.ReceiverID != reporterID || message.ModerationStatus == data.ModerationQuarantined {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	report.ReportedUserID = message.SenderID
+
+	err = app.models.Reports.Insert(ctx, report)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateReport) {
+			v.AddError("message_id", "You have already reported this message")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyModerators(report.OrganizationID, events.Event{
+		Type: moderation.EventReported,
+		Data: envelope{"report_id": report.ID, "message_id": messageID, "reason": report.Reason},
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loadReviewItem loads the reported message, if it still exists, and the
+// messages leading up to it. The context is a page of the conversation from
+// the reported user's side, so it includes their quarantined messages.
+func (app *application) loadReviewItem(ctx context.Context, report *data.Report) (*reviewItem, error) {
+	item := &reviewItem{Report: report}
+
+	if report.MessageID == nil {
+		return item, nil
+	}
+
+	message, err := app.models.Messages.Get(ctx, report.OrganizationID, *report.MessageID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return item, nil
+		}
+		return nil, err
+	}
+	item.Message = message
+
+	filters := data.Filters{Cursor: message.Timestamp, PageSize: reportContextSize}
+
+	item.Context, _, err = app.models.Messages.GetAllForSenderReceiver(ctx, report.OrganizationID, message.SenderID, message.ReceiverID, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// listReports is the moderators' review queue. It returns open reports by
+// default, each with its message and the conversation leading up to it.
+func (app *application) listReports(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+
+	filters.Cursor = app.readTime(qs, "cursor", time.Now(), v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	status := qs.Get("status")
+	if status == "" {
+		status = data.ReportOpen
+	}
+
+	data.ValidateFilters(v, filters)
+	if data.ValidateReportStatus(v, status); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Every report on the page needs its own context queries
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	reports, metadata, err := app.models.Reports.GetAll(ctx, app.contextGetOrganization(r), status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	items := make([]*reviewItem, 0, len(reports))
+	for _, report := range reports {
+		item, err := app.loadReviewItem(ctx, report)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		items = append(items, item)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reports": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := app.readIDParam(r, "report_id")
+	if err != nil || reportID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	report, err := app.models.Reports.Get(ctx, app.contextGetOrganization(r), reportID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	item, err := app.loadReviewItem(ctx, report)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resolveReport closes a report by dismissing it, deleting the reported
+// message or suspending its sender
+func (app *application) resolveReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := app.readIDParam(r, "report_id")
+	if err != nil || reportID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Resolution string `json:"resolution"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateResolution(v, input.Resolution); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organizationID := app.contextGetOrganization(r)
+
+	report, err := app.models.Reports.Get(ctx, organizationID, reportID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Load what the resolution changes so that webhooks and the audit log can
+	// be told about it
+	var deleted *data.Message
+	var suspended *data.User
+
+	switch input.Resolution {
+	case data.ResolutionMessageDeleted:
+		if report.MessageID != nil {
+			deleted, err = app.models.Messages.Get(ctx, organizationID, *report.MessageID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	case data.ResolutionUserSuspended:
+		suspended, err = app.models.Users.Get(ctx, organizationID, report.ReportedUserID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.notFoundResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	report, err = app.models.Reports.Resolve(ctx, organizationID, reportID, input.Resolution, app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("report_id", "This report has already been resolved")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if suspended != nil {
+		// The report is resolved by now, so a failure here only costs the
+		// audit event its after state
+		after, err := app.models.Users.Get(ctx, organizationID, suspended.ID)
+		if err != nil {
+			app.logError(r, err)
+		}
+
+		app.recordAudit(r, &data.AuditEvent{
+			Action:     data.AuditUserSuspended,
+			TargetType: data.TargetUser,
+			TargetID:   strconv.FormatInt(suspended.ID, 10),
+		}, suspended, after)
+	}
+
+	if deleted != nil {
+		app.recordAudit(r, &data.AuditEvent{
+			Action:     data.AuditMessageDeleted,
+			TargetType: data.TargetMessage,
+			TargetID:   strconv.FormatInt(deleted.ID, 10),
+		}, deleted, nil)
+
+		app.background(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := app.webhooks.Publish(ctx, data.EventMessageDeleted, deleted)
+			if err != nil {
+				app.logger.Error("failed to publish webhook event", "error", err, "message_id", deleted.ID, "event", data.EventMessageDeleted)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reinstateUser lifts a suspension
+func (app *application) reinstateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r, "user_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	organizationID := app.contextGetOrganization(r)
+
+	user, err := app.models.Users.Get(ctx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Users.Reinstate(ctx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reinstated := *user
+	reinstated.SuspendedAt = nil
+
+	app.recordAudit(r, &data.AuditEvent{
+		Action:     data.AuditUserReinstated,
+		TargetType: data.TargetUser,
+		TargetID:   strconv.FormatInt(userID, 10),
+	}, user, reinstated)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/araaavind/zoko-im/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -13,9 +14,115 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheck)
-	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/chats/:receiver_id/messages", app.sendMessage)
-	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/chats/:receiver_id/messages", app.listMessages)
-	router.HandlerFunc(http.MethodPatch, "/v1/messages/:message_id/read", app.readMessage)
 
-	return app.recoverPanic(app.rateLimit(router))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations", app.createOrganization)
+	router.HandlerFunc(http.MethodGet, "/v1/organizations/:organization_id", app.showOrganization)
+	router.HandlerFunc(http.MethodGet, "/v1/organizations/:organization_id/members", app.requirePermission(data.PermissionMembersRead, app.listMembers))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations/:organization_id/members", app.requirePermission(data.PermissionMembersWrite, app.createMember))
+	router.HandlerFunc(http.MethodPut, "/v1/organizations/:organization_id/members/:user_id/role", app.requirePermission(data.PermissionRolesWrite, app.updateMemberRole))
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationToken)
+
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requirePermission(data.PermissionAPIKeysRead, app.listAPIKeys))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requirePermission(data.PermissionAPIKeysWrite, app.createAPIKey))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:api_key_id", app.requirePermission(data.PermissionAPIKeysWrite, app.revokeAPIKey))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/dlq", app.requirePermission(data.PermissionAdminDLQ, app.listDLQ))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/dlq/:entry_id/replay", app.requirePermission(data.PermissionAdminDLQ, app.replayDLQ))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit-events", app.requirePermission(data.PermissionAuditRead, app.listAuditEvents))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit-events/export", app.requirePermission(data.PermissionAuditRead, app.exportAuditEvents))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:user_id", app.requirePermission(data.PermissionAdminUsers, app.deleteUser))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:user_id/suspension", app.requirePermission(data.PermissionModeration, app.reinstateUser))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/quarantine", app.requirePermission(data.PermissionModeration, app.listQuarantined))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/quarantine/:message_id/release", app.requirePermission(data.PermissionModeration, app.releaseMessage))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/reports", app.requirePermission(data.PermissionModeration, app.listReports))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/reports/:report_id", app.requirePermission(data.PermissionModeration, app.showReport))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/reports/:report_id/resolve", app.requirePermission(data.PermissionModeration, app.resolveReport))
+
+	// httprouter requires wildcards at the same position to share a name, so
+	// every /v1/users/ route names the acting user :sender_id. That user must be
+	// the one making the request, or a member of the organization when an API
+	// key makes it. Agents may also act as their inbox on the routes used to
+	// reply to its customers and work its assignments. Routes where that user is active count for presence. Routes
+	// that send messages are rate limited more strictly than the rest.
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/chats/:receiver_id/messages", app.limitRoute(rateLimitSend, app.requireInboxUser(data.PermissionMessagesSend, app.trackActivity(app.sendMessage))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/chats/:receiver_id/messages", app.requireInboxUser(data.PermissionMessagesRead, app.trackActivity(app.listMessages)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/chats/:receiver_id/messages/template", app.limitRoute(rateLimitSend, app.requireInboxUser(data.PermissionMessagesSend, app.trackActivity(app.sendTemplateMessage))))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:sender_id/chats/:receiver_id/disappearing", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.setDisappearingTimer)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/chats/:receiver_id/typing", app.requireInboxUser(data.PermissionMessagesSend, app.typing))
+
+	router.HandlerFunc(http.MethodPost, "/v1/inbound/:channel", app.receiveInbound)
+	router.HandlerFunc(http.MethodPost, "/v1/inbound/:channel/status", app.receiveDeliveryStatus)
+
+	router.HandlerFunc(http.MethodPatch, "/v1/messages/:message_id/read", app.requirePermission(data.PermissionMessagesRead, app.readMessage))
+	router.HandlerFunc(http.MethodPost, "/v1/messages/:message_id/forward", app.limitRoute(rateLimitSend, app.requirePermission(data.PermissionMessagesSend, app.forwardMessage)))
+	router.HandlerFunc(http.MethodPost, "/v1/messages/:message_id/report", app.requirePermission(data.PermissionMessagesSend, app.reportMessage))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/blocks", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listBlocks)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/blocks/:blocked_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.blockUser)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/blocks/:blocked_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.unblockUser)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/privacy", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showPrivacy)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:sender_id/privacy", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updatePrivacy)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/presence", app.requirePermission(data.PermissionMessagesRead, app.showPresence))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/presence/heartbeat", app.requireActingUser(data.PermissionMessagesSend, app.heartbeat))
+	router.HandlerFunc(http.MethodGet, "/v1/presence", app.requirePermission(data.PermissionMessagesRead, app.listPresence))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/contacts", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listContacts)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/contacts", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createContact)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/contacts/external", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createExternalContact)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/contacts/import", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.importContacts)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/contacts/:contact_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showContact)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/contacts/:contact_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateContact)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/contacts/:contact_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.deleteContact)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/webhooks", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listWebhooks)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/webhooks", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createWebhook)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/webhooks/:webhook_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showWebhook)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/webhooks/:webhook_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateWebhook)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/webhooks/:webhook_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.deleteWebhook)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/broadcasts", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listBroadcasts)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/broadcasts", app.limitRoute(rateLimitSend, app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createBroadcast))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/broadcasts/:broadcast_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showBroadcast)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/broadcast-lists", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listBroadcastLists)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/broadcast-lists", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createBroadcastList)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/broadcast-lists/:list_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showBroadcastList)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/broadcast-lists/:list_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateBroadcastList)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/broadcast-lists/:list_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.deleteBroadcastList)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/canned-responses", app.requirePermission(data.PermissionMessagesRead, app.searchCannedResponses))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/canned-responses", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createCannedResponse)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/canned-responses/:canned_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showCannedResponse)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/canned-responses/:canned_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateCannedResponse)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/canned-responses/:canned_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.deleteCannedResponse)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/templates", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listTemplates)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/templates", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createTemplate)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/templates/:template_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showTemplate)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/templates/:template_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateTemplate)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/templates/:template_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.deleteTemplate)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/inbox", app.requireInboxUser(data.PermissionMessagesRead, app.trackActivity(app.showInbox)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:sender_id/inbox", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateInbox)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/inbox/agents", app.requireInboxUser(data.PermissionMessagesRead, app.trackActivity(app.listInboxAgents)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/inbox/agents/:agent_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.addInboxAgent)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/inbox/agents/:agent_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.removeInboxAgent)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/assignments", app.requireInboxUser(data.PermissionMessagesRead, app.trackActivity(app.listAssignments)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:sender_id/assignments/:assignment_id/agent", app.requireInboxUser(data.PermissionMessagesSend, app.trackActivity(app.transferAssignment)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:sender_id/assignments/:assignment_id/status", app.requireInboxUser(data.PermissionMessagesSend, app.trackActivity(app.updateAssignmentStatus)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/assignments/:assignment_id/resolve", app.requireInboxUser(data.PermissionMessagesSend, app.trackActivity(app.resolveAssignment)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:sender_id/assignments/:assignment_id/labels", app.requireInboxUser(data.PermissionMessagesSend, app.trackActivity(app.updateAssignmentLabels)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/auto-replies", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listAutoReplyRules)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:sender_id/auto-replies", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.createAutoReplyRule)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/auto-replies/:rule_id", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.showAutoReplyRule)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/auto-replies/:rule_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.updateAutoReplyRule)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/auto-replies/:rule_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.deleteAutoReplyRule)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:sender_id/scheduled-messages", app.requireActingUser(data.PermissionMessagesRead, app.trackActivity(app.listScheduledMessages)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:sender_id/scheduled-messages/:scheduled_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.rescheduleMessage)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:sender_id/scheduled-messages/:scheduled_id", app.requireActingUser(data.PermissionMessagesSend, app.trackActivity(app.cancelScheduledMessage)))
+
+	return app.requestID(app.recoverPanic(app.limitAuthFailures(app.authenticate(app.rateLimit(app.organization(router))))))
 }
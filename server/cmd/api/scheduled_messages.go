@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// scheduleMessage stores a message and send time that sendMessage has already
+// validated, to be sent at sendAt instead of enqueueing it immediately. The use
+// of a canned response is only counted once the message is stored.
+func (app *application) scheduleMessage(w http.ResponseWriter, r *http.Request, message *data.Message, sendAt time.Time, cannedResponseID *int64) {
+	scheduled := &data.ScheduledMessage{
+		OrganizationID: message.OrganizationID,
+		SendAt:         sendAt,
+		Content:        message.Content,
+		SenderID:       message.SenderID,
+		ReceiverID:     message.ReceiverID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err := app.models.ScheduledMessages.Insert(ctx, scheduled)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if cannedResponseID != nil {
+		app.recordCannedResponseUse(*cannedResponseID)
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"scheduled_message": scheduled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	scheduled, err := app.models.ScheduledMessages.GetAllForSender(ctx, app.contextGetOrganization(r), senderID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"scheduled_messages": scheduled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) rescheduleMessage(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	scheduledID, err := app.readIDParam(r, "scheduled_id")
+	if err != nil || scheduledID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		SendAt time.Time `json:"send_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateSendAt(v, input.SendAt); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	scheduled, err := app.models.ScheduledMessages.Reschedule(ctx, app.contextGetOrganization(r), scheduledID, senderID, input.SendAt)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"scheduled_message": scheduled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	scheduledID, err := app.readIDParam(r, "scheduled_id")
+	if err != nil || scheduledID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	err = app.models.ScheduledMessages.Delete(ctx, app.contextGetOrganization(r), scheduledID, senderID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Scheduled message cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
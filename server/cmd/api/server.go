@@ -22,6 +22,11 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelInfo),
 	}
 
+	ctx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	app.startBackgroundTasks(ctx)
+
 	shutdownError := make(chan error)
 	go func() {
 		// Create a quit channel which carries os.Signal values.
@@ -48,6 +53,9 @@ func (app *application) serve() error {
 
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
+		stopBackground()
+		app.wg.Wait()
+
 		shutdownError <- nil
 	}()
 
@@ -65,3 +73,16 @@ func (app *application) serve() error {
 	app.logger.Info("server stopped", "addr", srv.Addr)
 	return nil
 }
+
+// startBackgroundTasks launches the long-running goroutines the API owns. They
+// stop when ctx is cancelled during shutdown.
+func (app *application) startBackgroundTasks(ctx context.Context) {
+	if app.outbox != nil {
+		app.background(func() {
+			err := app.outbox.Relay(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				app.logger.Error("outbox relay failed", "error", err)
+			}
+		})
+	}
+}
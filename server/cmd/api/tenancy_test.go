@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// newTestApplication returns an application backed by the database in
+// IM_TEST_DB_DSN, which must have every migration applied. Tests that use it
+// are skipped when the variable is not set. Redis is not used, so only paths
+// that respond before reaching it can be tested.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	dsn := os.Getenv("IM_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("IM_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewModels(db),
+	}
+	t.Cleanup(app.wg.Wait)
+
+	return app
+}
+
+// tenant is an organization created for a test, with an owner who can log in
+// and a second member
+type tenant struct {
+	organization *data.Organization
+	owner        *data.Member
+	member       *data.Member
+	token        string
+}
+
+func newTenant(t *testing.T, app *application) *tenant {
+	t.Helper()
+
+	ctx := context.Background()
+
+	tn := &tenant{
+		organization: &data.Organization{Name: "Tenant"},
+		owner:        &data.Member{FullName: "Owner"},
+		member:       &data.Member{FullName: "Member", Role: data.RoleEndUser},
+	}
+
+	err := app.models.Organizations.InsertWithOwner(ctx, tn.organization, tn.owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.models.Organizations.DB.Exec("DELETE FROM organizations WHERE id = $1", tn.organization.ID)
+	})
+
+	err = app.models.Organizations.AddMember(ctx, tn.organization.ID, tn.member)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(ctx, tn.owner.UserID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn.token = token.Plaintext
+
+	return tn
+}
+
+// TestCrossTenantAccess checks that nothing owned by another organization can
+// be reached, whether through a user's own routes or by acting as a user of
+// the other organization with an API key. Other tenants' records must look
+// exactly like missing ones.
+func TestCrossTenantAccess(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	a := newTenant(t, app)
+	b := newTenant(t, app)
+
+	key := &data.APIKey{
+		OrganizationID: a.organization.ID,
+		Name:           "Backend",
+		Scopes:         []string{data.PermissionMessagesRead, data.PermissionMessagesSend},
+		RateLimitRPS:   10,
+		RateLimitBurst: 20,
+	}
+	err := app.models.APIKeys.Insert(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &data.Message{
+		OrganizationID:   b.organization.ID,
+		Timestamp:        time.Now(),
+		Content:          "Hello",
+		SenderID:         b.member.UserID,
+		ReceiverID:       b.owner.UserID,
+		Kind:             data.MessageKindText,
+		ModerationStatus: data.ModerationClean,
+	}
+	err = app.models.Messages.Insert(ctx, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contact := &data.Contact{OwnerID: b.owner.UserID, ContactID: b.member.UserID}
+	err = app.models.Contacts.Insert(ctx, contact)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &data.Webhook{
+		UserID: b.owner.UserID,
+		URL:    "https://example.com/hook",
+		Secret: "secret",
+		Events: []string{data.EventMessagePersisted},
+		Active: true,
+	}
+	err = app.models.Webhooks.Insert(ctx, webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	self := a.owner.UserID
+	other := b.owner.UserID
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		apiKey bool
+	}{
+		{"list messages with another tenant's user", http.MethodGet, fmt.Sprintf("/v1/users/%d/chats/%d/messages", self, other), "", false},
+		{"list messages as another tenant's user", http.MethodGet, fmt.Sprintf("/v1/users/%d/chats/%d/messages", other, b.member.UserID), "", true},
+		{"send message to another tenant's user", http.MethodPost, fmt.Sprintf("/v1/users/%d/chats/%d/messages", self, other), `{"content":"Hi"}`, false},
+		{"read another tenant's message", http.MethodPatch, fmt.Sprintf("/v1/messages/%d/read", message.ID), "", false},
+		{"forward another tenant's message", http.MethodPost, fmt.Sprintf("/v1/messages/%d/forward", message.ID), fmt.Sprintf(`{"receiver_ids":[%d]}`, self), false},
+		{"forward as another tenant's user", http.MethodPost, fmt.Sprintf("/v1/messages/%d/forward", message.ID), fmt.Sprintf(`{"sender_id":%d,"receiver_ids":[%d]}`, other, self), true},
+		{"show another tenant's contact", http.MethodGet, fmt.Sprintf("/v1/users/%d/contacts/%d", self, contact.ID), "", false},
+		{"list another tenant's contacts", http.MethodGet, fmt.Sprintf("/v1/users/%d/contacts", other), "", true},
+		{"show another tenant's webhook", http.MethodGet, fmt.Sprintf("/v1/users/%d/webhooks/%d", self, webhook.ID), "", false},
+		{"list another tenant's webhooks", http.MethodGet, fmt.Sprintf("/v1/users/%d/webhooks", other), "", true},
+		{"report another tenant's message", http.MethodPost, fmt.Sprintf("/v1/messages/%d/report", message.ID), `{"reason":"spam"}`, false},
+		{"report as another tenant's user", http.MethodPost, fmt.Sprintf("/v1/messages/%d/report", message.ID), fmt.Sprintf(`{"reporter_id":%d,"reason":"spam"}`, other), true},
+		{"search another tenant's canned responses", http.MethodGet, fmt.Sprintf("/v1/canned-responses?user_id=%d", other), "", true},
+		{"list another tenant's presence", http.MethodGet, fmt.Sprintf("/v1/presence?user_ids=%d", other), "", false},
+		{"show another tenant's presence", http.MethodGet, fmt.Sprintf("/v1/users/%d/presence", other), "", true},
+		{"show another tenant's inbox", http.MethodGet, fmt.Sprintf("/v1/users/%d/inbox", other), "", true},
+		{"list another tenant's assignments", http.MethodGet, fmt.Sprintf("/v1/users/%d/assignments", other), "", true},
+		{"list another tenant's broadcasts", http.MethodGet, fmt.Sprintf("/v1/users/%d/broadcasts", other), "", true},
+		{"list another tenant's auto-replies", http.MethodGet, fmt.Sprintf("/v1/users/%d/auto-replies", other), "", true},
+		{"list another tenant's templates", http.MethodGet, fmt.Sprintf("/v1/users/%d/templates", other), "", true},
+		{"list another tenant's blocks", http.MethodGet, fmt.Sprintf("/v1/users/%d/blocks", other), "", true},
+		{"list another tenant's scheduled messages", http.MethodGet, fmt.Sprintf("/v1/users/%d/scheduled-messages", other), "", true},
+	}
+
+	handler := app.routes()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.apiKey {
+				r.Header.Set("Authorization", "Bearer "+key.Plaintext)
+			} else {
+				r.Header.Set("Authorization", "Bearer "+a.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("got status %d; want %d: %s", w.Code, http.StatusNotFound, w.Body)
+			}
+		})
+	}
+}
+
+// TestCrossTenantModels checks that the models scope lookups of records owned
+// by users to the organization they are given, so that a record of another
+// organization is missing even when its owner and ID are known
+func TestCrossTenantModels(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	a := newTenant(t, app)
+	b := newTenant(t, app)
+
+	owner := b.owner.UserID
+	member := b.member.UserID
+
+	err := app.models.Inboxes.Upsert(ctx, &data.Inbox{UserID: owner, Strategy: data.AssignRoundRobin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Inboxes.AddAgent(ctx, owner, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := app.models.Conversations.Get(ctx, b.organization.ID, owner, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assignment, _, err := app.models.Assignments.Open(ctx, conversation.ID, owner, member, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &data.Webhook{UserID: owner, URL: "https://example.com/hook", Secret: "secret", Events: []string{data.EventMessagePersisted}, Active: true}
+	err = app.models.Webhooks.Insert(ctx, webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := &data.BroadcastList{UserID: owner, Name: "Customers", ReceiverIDs: []int64{member}}
+	err = app.models.BroadcastLists.Insert(ctx, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broadcast := &data.Broadcast{
+		SenderID:   owner,
+		Content:    "Hello",
+		Recipients: []*data.BroadcastRecipient{{ReceiverID: member, Status: data.BroadcastStatusQueued}},
+	}
+	err = app.models.Broadcasts.Insert(ctx, broadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := &data.AutoReplyRule{UserID: owner, Name: "Greeting", MatchType: data.MatchTypeKeyword, Keywords: []string{"hello"}, HoursCondition: data.HoursAny, Reply: "Hi", Active: true}
+	err = app.models.AutoReplyRules.Insert(ctx, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &data.MessageTemplate{UserID: owner, Name: "greeting", Body: "Hello"}
+	err = app.models.MessageTemplates.Insert(ctx, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Blocks.Insert(ctx, &data.Block{BlockerID: owner, BlockedID: member})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled := &data.ScheduledMessage{OrganizationID: b.organization.ID, SendAt: time.Now().Add(time.Hour), Content: "Later", SenderID: owner, ReceiverID: member}
+	err = app.models.ScheduledMessages.Insert(ctx, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// found turns lookups of many records and existence checks into
+	// ErrRecordNotFound when nothing was found
+	found := func(ok bool, err error) error {
+		if err == nil && !ok {
+			return data.ErrRecordNotFound
+		}
+		return err
+	}
+
+	// Each lookup is made for the other organization, which must find nothing,
+	// and then for the owner's organization, which must find the record.
+	// Deletions come last so that the lookups before them still find their
+	// records.
+	tests := []struct {
+		name   string
+		lookup func(organizationID int64) error
+	}{
+		{"get inbox", func(id int64) error {
+			_, err := app.models.Inboxes.Get(ctx, id, owner)
+			return err
+		}},
+		{"list inbox agents", func(id int64) error {
+			agents, err := app.models.Inboxes.GetAgents(ctx, id, owner)
+			return found(len(agents) > 0, err)
+		}},
+		{"check inbox agent", func(id int64) error {
+			return found(app.models.Inboxes.IsAgent(ctx, id, owner, member))
+		}},
+		{"get assignment", func(id int64) error {
+			_, err := app.models.Assignments.Get(ctx, id, assignment.ID, owner)
+			return err
+		}},
+		{"list assignments", func(id int64) error {
+			assignments, _, err := app.models.Assignments.GetAllForInbox(ctx, id, owner, "", "", 0, data.Filters{Cursor: time.Now(), PageSize: 20})
+			return found(len(assignments) > 0, err)
+		}},
+		{"transfer assignment", func(id int64) error {
+			_, err := app.models.Assignments.Transfer(ctx, id, assignment.ID, owner, member)
+			return err
+		}},
+		{"label assignment", func(id int64) error {
+			_, err := app.models.Assignments.SetLabels(ctx, id, assignment.ID, owner, []string{"vip"})
+			return err
+		}},
+		{"get webhook", func(id int64) error {
+			_, err := app.models.Webhooks.Get(ctx, id, webhook.ID, owner)
+			return err
+		}},
+		{"list webhooks", func(id int64) error {
+			webhooks, err := app.models.Webhooks.GetAllForUser(ctx, id, owner)
+			return found(len(webhooks) > 0, err)
+		}},
+		{"update webhook", func(id int64) error {
+			return app.models.Webhooks.Update(ctx, id, webhook)
+		}},
+		{"get broadcast list", func(id int64) error {
+			_, err := app.models.BroadcastLists.Get(ctx, id, list.ID, owner)
+			return err
+		}},
+		{"list broadcast lists", func(id int64) error {
+			lists, err := app.models.BroadcastLists.GetAllForUser(ctx, id, owner)
+			return found(len(lists) > 0, err)
+		}},
+		{"get broadcast", func(id int64) error {
+			_, err := app.models.Broadcasts.Get(ctx, id, broadcast.ID, owner)
+			return err
+		}},
+		{"list broadcasts", func(id int64) error {
+			broadcasts, _, err := app.models.Broadcasts.GetAllForSender(ctx, id, owner, data.Filters{Cursor: time.Now().Add(time.Minute), PageSize: 20})
+			return found(len(broadcasts) > 0, err)
+		}},
+		{"get auto-reply rule", func(id int64) error {
+			_, err := app.models.AutoReplyRules.Get(ctx, id, rule.ID, owner)
+			return err
+		}},
+		{"list auto-reply rules", func(id int64) error {
+			rules, err := app.models.AutoReplyRules.GetAllForUser(ctx, id, owner)
+			return found(len(rules) > 0, err)
+		}},
+		{"update auto-reply rule", func(id int64) error {
+			return app.models.AutoReplyRules.Update(ctx, id, rule)
+		}},
+		{"get template", func(id int64) error {
+			_, err := app.models.MessageTemplates.Get(ctx, id, template.ID, owner)
+			return err
+		}},
+		{"get template by name", func(id int64) error {
+			_, err := app.models.MessageTemplates.GetByName(ctx, id, owner, template.Name)
+			return err
+		}},
+		{"list templates", func(id int64) error {
+			templates, err := app.models.MessageTemplates.GetAllForUser(ctx, id, owner)
+			return found(len(templates) > 0, err)
+		}},
+		{"update template", func(id int64) error {
+			return app.models.MessageTemplates.Update(ctx, id, template)
+		}},
+		{"list blocks", func(id int64) error {
+			blocks, err := app.models.Blocks.GetAllForBlocker(ctx, id, owner)
+			return found(len(blocks) > 0, err)
+		}},
+		{"list scheduled messages", func(id int64) error {
+			scheduled, err := app.models.ScheduledMessages.GetAllForSender(ctx, id, owner)
+			return found(len(scheduled) > 0, err)
+		}},
+		{"reschedule message", func(id int64) error {
+			_, err := app.models.ScheduledMessages.Reschedule(ctx, id, scheduled.ID, owner, time.Now().Add(2*time.Hour))
+			return err
+		}},
+		{"remove inbox agent", func(id int64) error {
+			return app.models.Inboxes.RemoveAgent(ctx, id, owner, member)
+		}},
+		{"delete webhook", func(id int64) error {
+			return app.models.Webhooks.Delete(ctx, id, webhook.ID, owner)
+		}},
+		{"delete broadcast list", func(id int64) error {
+			return app.models.BroadcastLists.Delete(ctx, id, list.ID, owner)
+		}},
+		{"delete auto-reply rule", func(id int64) error {
+			return app.models.AutoReplyRules.Delete(ctx, id, rule.ID, owner)
+		}},
+		{"delete template", func(id int64) error {
+			return app.models.MessageTemplates.Delete(ctx, id, template.ID, owner)
+		}},
+		{"delete block", func(id int64) error {
+			return app.models.Blocks.Delete(ctx, id, owner, member)
+		}},
+		{"delete scheduled message", func(id int64) error {
+			return app.models.ScheduledMessages.Delete(ctx, id, scheduled.ID, owner)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.lookup(a.organization.ID)
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("got error %v for another organization; want %v", err, data.ErrRecordNotFound)
+			}
+
+			err = tt.lookup(b.organization.ID)
+			if err != nil {
+				t.Errorf("got error %v for the owner's organization; want nil", err)
+			}
+		})
+	}
+}
+
+// TestAnonymousTenantSelection checks that a tenant cannot be picked with the
+// X-Organization-ID header without credentials for it
+func TestAnonymousTenantSelection(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/presence?user_ids=1", nil)
+	r.Header.Set("X-Organization-ID", "1")
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/policy"
+)
+
+// typing tells the receiver that the sender is typing. Clients may call it on
+// every keystroke: at most one event per chat is published per throttle window
+// and the event expires on the client after the same window. Typing events only
+// go over the event bus and are never stored in Postgres or the message stream.
+// Users who could not message the receiver cannot tell them they are typing.
+func (app *application) typing(w http.ResponseWriter, r *http.Request) {
+	senderID, err := app.readIDParam(r, "sender_id")
+	if err != nil || senderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	receiverID, err := app.readIDParam(r, "receiver_id")
+	if err != nil || receiverID < 1 || receiverID == senderID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+	defer cancel()
+
+	users, err := app.models.Users.GetMany(ctx, app.contextGetOrganization(r), []int64{senderID, receiverID})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Typing follows the same rules as sending a message
+	err = policy.CanSend(findUser(users, senderID))
+	if err == nil {
+		err = app.canMessage(ctx, senderID, findUser(users, receiverID))
+	}
+	if err != nil {
+		if app.dropBlockedSend(err) {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			app.messagingNotAllowedResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.events.Allow(ctx, fmt.Sprintf("typing:%d:%d", senderID, receiverID), app.config.typing.throttle)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if allowed {
+		event := events.Event{
+			Type: "typing",
+			Data: envelope{
+				"sender_id":  senderID,
+				"expires_in": app.config.typing.throttle.Milliseconds(),
+			},
+		}
+
+		err = app.events.Publish(ctx, event, receiverID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,15 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"expvar"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
+	"github.com/araaavind/zoko-im/internal/assignment"
+	"github.com/araaavind/zoko-im/internal/audit"
+	"github.com/araaavind/zoko-im/internal/autoreply"
+	"github.com/araaavind/zoko-im/internal/channels"
 	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/araaavind/zoko-im/internal/presence"
 	"github.com/araaavind/zoko-im/internal/queue"
+	"github.com/araaavind/zoko-im/internal/webhooks"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/redis/go-redis/v9"
 )
@@ -39,6 +50,78 @@ type config struct {
 		dlq struct {
 			key string
 		}
+		trim struct {
+			interval       time.Duration
+			streamStrategy string
+			streamMaxLen   int64
+			dlqStrategy    string
+			dlqMaxLen      int64
+		}
+	}
+	metrics struct {
+		addr string
+	}
+	scheduler struct {
+		interval time.Duration
+	}
+	reaper struct {
+		interval time.Duration
+	}
+	webhooks struct {
+		streamKey            string
+		consumerGroup        string
+		consumerName         string
+		retryKey             string
+		dlqKey               string
+		maxAttempts          int
+		initialBackoff       time.Duration
+		retryInterval        time.Duration
+		timeout              time.Duration
+		concurrency          int
+		allowPrivateNetworks bool
+	}
+	audit struct {
+		streamKey     string
+		consumerGroup string
+		consumerName  string
+		dlqKey        string
+		maxAttempts   int
+	}
+	autoreply struct {
+		cooldown time.Duration
+	}
+	sla struct {
+		interval time.Duration
+	}
+	presence struct {
+		sweepInterval time.Duration
+	}
+	channels struct {
+		streamKey      string
+		consumerGroup  string
+		consumerName   string
+		retryKey       string
+		dlqKey         string
+		maxAttempts    int
+		initialBackoff time.Duration
+		retryInterval  time.Duration
+		timeout        time.Duration
+		smtp           struct {
+			host     string
+			port     int
+			username string
+			password string
+			from     string
+		}
+		sms struct {
+			url    string
+			apiKey string
+			from   string
+		}
+		generic struct {
+			url    string
+			secret string
+		}
 	}
 }
 
@@ -63,9 +146,83 @@ func main() {
 	flag.DurationVar(&cfg.redis.stream.retryDelay, "redis-retry-delay", 1*time.Second, "Delay between retry attempts")
 	flag.StringVar(&cfg.redis.dlq.key, "redis-dlq-key", "messages_dlq", "Redis DLQ key name")
 	flag.IntVar(&cfg.redis.stream.batchSize, "redis-batch-size", 100, "Redis batch size")
+	flag.DurationVar(&cfg.reaper.interval, "reap-interval", 1*time.Minute, "Interval between runs of the expired message reaper")
+	flag.DurationVar(&cfg.scheduler.interval, "schedule-interval", 1*time.Second, "Interval between checks for due scheduled messages")
+
+	// Redis stream retention configuration
+	flag.DurationVar(&cfg.redis.trim.interval, "redis-trim-interval", 1*time.Minute, "Interval between stream trim runs")
+	flag.StringVar(&cfg.redis.trim.streamStrategy, "redis-stream-trim", "minid", "Message stream trim strategy (none|maxlen|minid)")
+	flag.Int64Var(&cfg.redis.trim.streamMaxLen, "redis-stream-maxlen", 100_000, "Approximate maximum length of the message stream when trimming by maxlen")
+	flag.StringVar(&cfg.redis.trim.dlqStrategy, "redis-dlq-trim", "maxlen", "DLQ trim strategy (none|maxlen|minid)")
+	flag.Int64Var(&cfg.redis.trim.dlqMaxLen, "redis-dlq-maxlen", 10_000, "Approximate maximum length of the DLQ when trimming by maxlen")
+
+	// Webhook delivery configuration
+	flag.StringVar(&cfg.webhooks.streamKey, "webhooks-stream-key", "webhook_events", "Redis stream key for webhook events")
+	flag.StringVar(&cfg.webhooks.consumerGroup, "webhooks-consumer-group", "webhook_dispatchers", "Redis consumer group for webhook delivery")
+	flag.StringVar(&cfg.webhooks.consumerName, "webhooks-consumer-name", "webhook_dispatcher_1", "Redis consumer name for webhook delivery")
+	flag.StringVar(&cfg.webhooks.retryKey, "webhooks-retry-key", "webhook_events_retry", "Redis key holding webhook deliveries waiting to be retried")
+	flag.StringVar(&cfg.webhooks.dlqKey, "webhooks-dlq-key", "webhooks_dlq", "Redis DLQ key for failed webhook deliveries")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhooks-max-attempts", 5, "Maximum delivery attempts per webhook event")
+	flag.DurationVar(&cfg.webhooks.initialBackoff, "webhooks-initial-backoff", 1*time.Second, "Delay before the first webhook retry, doubled on each further retry")
+	flag.DurationVar(&cfg.webhooks.retryInterval, "webhooks-retry-interval", 1*time.Second, "Interval between checks for webhook deliveries due to be retried")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhooks-timeout", 10*time.Second, "Timeout for a single webhook delivery")
+	flag.IntVar(&cfg.webhooks.concurrency, "webhooks-concurrency", 10, "Maximum concurrent webhook deliveries")
+	flag.BoolVar(&cfg.webhooks.allowPrivateNetworks, "webhooks-allow-private-networks", false, "Allow webhooks to loopback and private addresses (development only)")
+
+	// Audit log configuration
+	flag.StringVar(&cfg.audit.streamKey, "audit-stream-key", "audit_events", "Redis stream key for audit events")
+	flag.StringVar(&cfg.audit.consumerGroup, "audit-consumer-group", "audit_recorders", "Redis consumer group for recording audit events")
+	flag.StringVar(&cfg.audit.consumerName, "audit-consumer-name", "audit_recorder_1", "Redis consumer name for recording audit events")
+	flag.StringVar(&cfg.audit.dlqKey, "audit-dlq-key", "audit_dlq", "Redis DLQ key for audit events that cannot be recorded")
+	flag.IntVar(&cfg.audit.maxAttempts, "audit-max-attempts", 5, "Maximum attempts to record an audit event the database rejects")
+
+	flag.DurationVar(&cfg.sla.interval, "sla-interval", 30*time.Second, "Interval between checks for breached inbox SLAs")
+	flag.DurationVar(&cfg.presence.sweepInterval, "presence-sweep-interval", 5*time.Second, "Interval between checks for users whose presence expired")
+	flag.DurationVar(&cfg.autoreply.cooldown, "autoreply-cooldown", 10*time.Minute, "Minimum interval between auto replies from a user to the same person")
+
+	// Outbound delivery to external channels. Each channel is only enabled when
+	// its provider is configured.
+	flag.StringVar(&cfg.channels.streamKey, "channels-stream-key", "channel_deliveries", "Redis stream key for deliveries to external channels")
+	flag.StringVar(&cfg.channels.consumerGroup, "channels-consumer-group", "channel_routers", "Redis consumer group for deliveries to external channels")
+	flag.StringVar(&cfg.channels.consumerName, "channels-consumer-name", "channel_router_1", "Redis consumer name for deliveries to external channels")
+	flag.StringVar(&cfg.channels.retryKey, "channels-retry-key", "channel_deliveries_retry", "Redis key holding external deliveries waiting to be retried")
+	flag.StringVar(&cfg.channels.dlqKey, "channels-dlq-key", "channels_dlq", "Redis DLQ key for failed deliveries to external channels")
+	flag.IntVar(&cfg.channels.maxAttempts, "channels-max-attempts", 5, "Maximum delivery attempts per message to an external channel")
+	flag.DurationVar(&cfg.channels.initialBackoff, "channels-initial-backoff", 5*time.Second, "Delay before the first retry of an external delivery, doubled on each further retry")
+	flag.DurationVar(&cfg.channels.retryInterval, "channels-retry-interval", 1*time.Second, "Interval between checks for external deliveries due to be retried")
+	flag.DurationVar(&cfg.channels.timeout, "channels-timeout", 10*time.Second, "Timeout for a single delivery to an external channel")
+	flag.StringVar(&cfg.channels.smtp.host, "smtp-host", "", "SMTP host for the email channel")
+	flag.IntVar(&cfg.channels.smtp.port, "smtp-port", 587, "SMTP port for the email channel")
+	flag.StringVar(&cfg.channels.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.channels.smtp.password, "smtp-password", os.Getenv("IM_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.channels.smtp.from, "smtp-from", "", "Sender address for the email channel")
+	flag.StringVar(&cfg.channels.sms.url, "sms-gateway-url", "", "SMS gateway endpoint for the sms channel")
+	flag.StringVar(&cfg.channels.sms.apiKey, "sms-gateway-api-key", os.Getenv("IM_SMS_GATEWAY_API_KEY"), "SMS gateway API key")
+	flag.StringVar(&cfg.channels.sms.from, "sms-from", "", "Sender number for the sms channel")
+	flag.StringVar(&cfg.channels.generic.url, "generic-channel-url", "", "Endpoint that receives messages for the generic channel")
+	flag.StringVar(&cfg.channels.generic.secret, "generic-channel-secret", os.Getenv("IM_GENERIC_CHANNEL_SECRET"), "Secret used to sign messages for the generic channel")
+
+	flag.StringVar(&cfg.metrics.addr, "metrics-addr", ":4001", "Address to expose worker metrics on (empty to disable)")
 
 	flag.Parse()
 
+	// time.NewTicker panics on intervals that are not positive
+	intervals := map[string]time.Duration{
+		"redis-trim-interval":     cfg.redis.trim.interval,
+		"schedule-interval":       cfg.scheduler.interval,
+		"reap-interval":           cfg.reaper.interval,
+		"sla-interval":            cfg.sla.interval,
+		"presence-sweep-interval": cfg.presence.sweepInterval,
+		"channels-retry-interval": cfg.channels.retryInterval,
+		"webhooks-retry-interval": cfg.webhooks.retryInterval,
+	}
+	for name, interval := range intervals {
+		if interval <= 0 {
+			fmt.Fprintf(os.Stderr, "-%s must be greater than zero\n", name)
+			os.Exit(2)
+		}
+	}
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	db, err := openDB(cfg)
@@ -86,6 +243,15 @@ func main() {
 
 	models := data.NewModels(db)
 
+	streamTrim := queue.TrimPolicy{Strategy: cfg.redis.trim.streamStrategy, MaxLen: cfg.redis.trim.streamMaxLen}
+	dlqTrim := queue.TrimPolicy{Strategy: cfg.redis.trim.dlqStrategy, MaxLen: cfg.redis.trim.dlqMaxLen}
+	for _, policy := range []queue.TrimPolicy{streamTrim, dlqTrim} {
+		if err := policy.Validate(); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// Initialize message queue
 	messageQueue := queue.NewMessageQueue(
 		rdb, queue.Config{
@@ -96,6 +262,128 @@ func main() {
 			MaxRetries:       cfg.redis.stream.maxRetries,
 			RetryDelay:       cfg.redis.stream.retryDelay,
 			BatchSize:        cfg.redis.stream.batchSize,
+			DLQKey:           cfg.redis.dlq.key,
+			StreamTrim:       streamTrim,
+			DLQTrim:          dlqTrim,
+			TrimInterval:     cfg.redis.trim.interval,
+			ScheduleInterval: cfg.scheduler.interval,
+			ReapInterval:     cfg.reaper.interval,
+		},
+		logger,
+		models,
+	)
+
+	webhookEvents := webhooks.NewPublisher(rdb, cfg.webhooks.streamKey)
+	messageQueue.OnPersist(func(ctx context.Context, messages []*data.Message) {
+		err := webhookEvents.Publish(ctx, data.EventMessagePersisted, messages...)
+		if err != nil {
+			logger.Error("failed to publish webhook events", "error", err, "count", len(messages))
+		}
+	})
+
+	var adapters []channels.Adapter
+	if cfg.channels.smtp.host != "" {
+		adapters = append(adapters, &channels.Email{
+			Host:     cfg.channels.smtp.host,
+			Port:     cfg.channels.smtp.port,
+			Username: cfg.channels.smtp.username,
+			Password: cfg.channels.smtp.password,
+			From:     cfg.channels.smtp.from,
+		})
+	}
+	if cfg.channels.sms.url != "" {
+		adapters = append(adapters, &channels.SMS{
+			URL:    cfg.channels.sms.url,
+			APIKey: cfg.channels.sms.apiKey,
+			From:   cfg.channels.sms.from,
+			Client: &http.Client{Timeout: cfg.channels.timeout},
+		})
+	}
+	if cfg.channels.generic.url != "" {
+		adapters = append(adapters, &channels.Webhook{
+			URL:    cfg.channels.generic.url,
+			Secret: cfg.channels.generic.secret,
+			Client: &http.Client{Timeout: cfg.channels.timeout},
+		})
+	}
+
+	// Messages to external contacts are queued for their channel once stored
+	// and delivered by the channel router
+	channelRouter := channels.NewRouter(
+		rdb, channels.Config{
+			StreamKey:        cfg.channels.streamKey,
+			ConsumerGroup:    cfg.channels.consumerGroup,
+			ConsumerName:     cfg.channels.consumerName,
+			RetryKey:         cfg.channels.retryKey,
+			DLQKey:           cfg.channels.dlqKey,
+			BlockingDuration: cfg.redis.stream.blockingDuration,
+			BatchSize:        cfg.redis.stream.batchSize,
+			MaxAttempts:      cfg.channels.maxAttempts,
+			InitialBackoff:   cfg.channels.initialBackoff,
+			RetryInterval:    cfg.channels.retryInterval,
+			Timeout:          cfg.channels.timeout,
+		},
+		logger,
+		models,
+		adapters...,
+	)
+	messageQueue.OnPersist(channelRouter.Enqueue)
+
+	// Auto replies go back through the stream, where the engine ignores them
+	autoReplies := autoreply.NewEngine(messageQueue, rdb, cfg.autoreply.cooldown, logger, models)
+	messageQueue.OnPersist(autoReplies.Handle)
+
+	assignments := assignment.NewRouter(
+		assignment.Config{
+			SLAInterval: cfg.sla.interval,
+			BatchSize:   cfg.redis.stream.batchSize,
+		},
+		events.NewBus(rdb),
+		logger,
+		models,
+	)
+	messageQueue.OnPersist(assignments.Handle)
+
+	presenceSweeper := presence.NewSweeper(
+		rdb, presence.SweeperConfig{
+			Interval:  cfg.presence.sweepInterval,
+			BatchSize: cfg.redis.stream.batchSize,
+		},
+		events.NewBus(rdb),
+		logger,
+		models,
+	)
+
+	webhookDispatcher := webhooks.NewDispatcher(
+		rdb, webhooks.Config{
+			StreamKey:            cfg.webhooks.streamKey,
+			ConsumerGroup:        cfg.webhooks.consumerGroup,
+			ConsumerName:         cfg.webhooks.consumerName,
+			RetryKey:             cfg.webhooks.retryKey,
+			DLQKey:               cfg.webhooks.dlqKey,
+			BlockingDuration:     cfg.redis.stream.blockingDuration,
+			BatchSize:            cfg.redis.stream.batchSize,
+			MaxAttempts:          cfg.webhooks.maxAttempts,
+			InitialBackoff:       cfg.webhooks.initialBackoff,
+			RetryInterval:        cfg.webhooks.retryInterval,
+			Timeout:              cfg.webhooks.timeout,
+			Concurrency:          cfg.webhooks.concurrency,
+			AllowPrivateNetworks: cfg.webhooks.allowPrivateNetworks,
+		},
+		logger,
+		models,
+	)
+
+	auditRecorder := audit.NewRecorder(
+		rdb, audit.Config{
+			StreamKey:        cfg.audit.streamKey,
+			ConsumerGroup:    cfg.audit.consumerGroup,
+			ConsumerName:     cfg.audit.consumerName,
+			BlockingDuration: cfg.redis.stream.blockingDuration,
+			BatchSize:        cfg.redis.stream.batchSize,
+			RetryDelay:       cfg.redis.stream.retryDelay,
+			DLQKey:           cfg.audit.dlqKey,
+			MaxAttempts:      cfg.audit.maxAttempts,
 		},
 		logger,
 		models,
@@ -114,6 +402,83 @@ func main() {
 		cancel()
 	}()
 
+	if cfg.metrics.addr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+
+			logger.Info("starting metrics server", "addr", cfg.metrics.addr)
+			err := http.ListenAndServe(cfg.metrics.addr, mux)
+			if err != nil {
+				logger.Error("metrics server failed", "error", err)
+			}
+		}()
+	}
+
+	logger.Info("starting stream trimmer")
+	go func() {
+		err := messageQueue.TrimStreams(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("stream trimmer failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting message scheduler")
+	go func() {
+		err := messageQueue.PromoteScheduled(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("message scheduler failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting expired message reaper")
+	go func() {
+		err := messageQueue.ReapExpired(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("expired message reaper failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting SLA checker")
+	go func() {
+		err := assignments.WatchSLAs(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("SLA checker failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting presence sweeper")
+	go func() {
+		err := presenceSweeper.Run(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("presence sweeper failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting webhook dispatcher")
+	go func() {
+		err := webhookDispatcher.Run(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("webhook dispatcher failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting channel router")
+	go func() {
+		err := channelRouter.Run(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("channel router failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting audit recorder")
+	go func() {
+		err := auditRecorder.Run(ctx)
+		if err != nil && err != context.Canceled {
+			logger.Error("audit recorder failed", "error", err)
+		}
+	}()
+
 	logger.Info("starting DLQ processor")
 	go func() {
 		err := messageQueue.ProcessDLQ(ctx)
@@ -0,0 +1,162 @@
+package assignment
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+)
+
+// Event types published to inbox owners and agents
+const (
+	EventAssigned    = "conversation.assigned"
+	EventReopened    = "conversation.reopened"
+	EventSLABreached = "sla.breached"
+)
+
+type Config struct {
+	SLAInterval time.Duration
+	BatchSize   int
+}
+
+// Router assigns customer conversations with inboxes to agents as their
+// messages are persisted, and watches the inboxes' SLAs
+type Router struct {
+	config Config
+	events *events.Bus
+	logger *slog.Logger
+	models data.Models
+}
+
+func NewRouter(config Config, bus *events.Bus, logger *slog.Logger, models data.Models) *Router {
+	return &Router{
+		config: config,
+		events: bus,
+		logger: logger,
+		models: models,
+	}
+}
+
+// Handle opens an assignment for every customer message to an inbox, reopening
+// resolved conversations, and records the inbox's first reply to it. It is meant to run as a
+// queue.PersistHook.
+func (r *Router) Handle(ctx context.Context, messages []*data.Message) {
+	userIDs := make([]int64, 0, 2*len(messages))
+	for _, message := range messages {
+		if message.Kind == data.MessageKindText {
+			userIDs = append(userIDs, message.SenderID, message.ReceiverID)
+		}
+	}
+
+	if len(userIDs) == 0 {
+		return
+	}
+
+	inboxes, err := r.models.Inboxes.GetMany(ctx, userIDs)
+	if err != nil {
+		r.logger.Error("failed to load inboxes", "error", err)
+		return
+	}
+
+	if len(inboxes) == 0 {
+		return
+	}
+
+	for _, message := range messages {
+		if message.Kind != data.MessageKindText {
+			continue
+		}
+
+		if _, ok := inboxes[message.SenderID]; ok {
+			err := r.models.Assignments.RecordFirstResponse(ctx, message.SenderID, message.ReceiverID, message.Timestamp)
+			if err != nil {
+				r.logger.Error("failed to record first response", "error", err, "message_id", message.ID)
+			}
+		}
+
+		if _, ok := inboxes[message.ReceiverID]; ok {
+			err := r.open(ctx, message)
+			if err != nil {
+				r.logger.Error("failed to assign conversation", "error", err, "message_id", message.ID)
+			}
+		}
+	}
+}
+
+func (r *Router) open(ctx context.Context, message *data.Message) error {
+	// Agents talking to their own inbox are not customers
+	isAgent, err := r.models.Inboxes.IsAgent(ctx, message.OrganizationID, message.ReceiverID, message.SenderID)
+	if err != nil || isAgent {
+		return err
+	}
+
+	conversation, err := r.models.Conversations.Get(ctx, message.OrganizationID, message.SenderID, message.ReceiverID)
+	if err != nil {
+		return err
+	}
+
+	assignment, result, err := r.models.Assignments.Open(ctx, conversation.ID, message.ReceiverID, message.SenderID, message.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case data.AssignmentCreated:
+		r.logger.Info("conversation assigned", "assignment_id", assignment.ID, "inbox_id", assignment.InboxID, "agent_id", assignment.AgentID)
+		return r.Notify(ctx, EventAssigned, assignment, assignment)
+	case data.AssignmentReopened:
+		r.logger.Info("conversation reopened", "assignment_id", assignment.ID, "inbox_id", assignment.InboxID, "agent_id", assignment.AgentID)
+		return r.Notify(ctx, EventReopened, assignment, assignment)
+	}
+
+	return nil
+}
+
+// Notify publishes an event about an assignment to its inbox owner and agent
+func (r *Router) Notify(ctx context.Context, eventType string, assignment *data.Assignment, payload any) error {
+	userIDs := []int64{assignment.InboxID}
+	if assignment.AgentID != nil {
+		userIDs = append(userIDs, *assignment.AgentID)
+	}
+
+	return r.events.Publish(ctx, events.Event{Type: eventType, Data: payload}, userIDs...)
+}
+
+// WatchSLAs periodically marks assignments that have breached their inbox's
+// SLAs and emits an event for each, until the context is cancelled
+func (r *Router) WatchSLAs(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.SLAInterval)
+	defer ticker.Stop()
+
+	r.logger.Info("SLA checker started", "interval", r.config.SLAInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			for {
+				breaches, err := r.models.Assignments.MarkBreached(ctx, r.config.BatchSize)
+				if err != nil {
+					r.logger.Error("failed to check SLAs", "error", err)
+					break
+				}
+
+				for _, breach := range breaches {
+					r.logger.Info("SLA breached", "sla", breach.SLA, "assignment_id", breach.Assignment.ID, "inbox_id", breach.Assignment.InboxID)
+
+					err := r.Notify(ctx, EventSLABreached, breach.Assignment, breach)
+					if err != nil {
+						r.logger.Error("failed to publish SLA breach", "error", err, "assignment_id", breach.Assignment.ID)
+					}
+				}
+
+				if len(breaches) < r.config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
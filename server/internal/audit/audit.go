@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/redis/go-redis/v9"
+)
+
+// Publisher adds audit events to the stream consumed by the Recorder, so that
+// handlers never wait on the audit log being written
+type Publisher struct {
+	client    *redis.Client
+	streamKey string
+}
+
+func NewPublisher(client *redis.Client, streamKey string) *Publisher {
+	return &Publisher{
+		client:    client,
+		streamKey: streamKey,
+	}
+}
+
+// Publish assigns the event its ID and timestamp, unless it already has them,
+// and adds it to the stream
+func (p *Publisher) Publish(ctx context.Context, event *data.AuditEvent) error {
+	if event.EventID == "" {
+		id, err := newEventID()
+		if err != nil {
+			return err
+		}
+		event.EventID = id
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.streamKey,
+		Values: map[string]any{
+			"event_id": event.EventID,
+			"event":    string(eventJSON),
+		},
+	}).Err()
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
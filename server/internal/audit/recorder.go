@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/redis/go-redis/v9"
+)
+
+type Config struct {
+	StreamKey        string
+	ConsumerGroup    string
+	ConsumerName     string
+	BlockingDuration time.Duration
+	BatchSize        int
+	RetryDelay       time.Duration
+	DLQKey           string
+	MaxAttempts      int
+}
+
+// Recorder consumes the audit stream and writes its events to Postgres. A
+// batch is only acknowledged once it has been stored, and batches that fail
+// are read again from the consumer's pending entries until they succeed, so
+// no event is dropped while the database is unavailable. An event the database
+// keeps rejecting while it is up is moved to the DLQ after MaxAttempts, so that
+// it cannot hold up the events behind it.
+type Recorder struct {
+	client *redis.Client
+	config Config
+	logger *slog.Logger
+	models data.Models
+
+	// attempts counts the failed inserts of entries still pending. It is only
+	// used from Run's goroutine.
+	attempts map[string]int
+}
+
+func NewRecorder(client *redis.Client, config Config, logger *slog.Logger, models data.Models) *Recorder {
+	return &Recorder{
+		client:   client,
+		config:   config,
+		logger:   logger,
+		models:   models,
+		attempts: make(map[string]int),
+	}
+}
+
+// Run records events until the context is cancelled
+func (r *Recorder) Run(ctx context.Context) error {
+	err := r.client.XGroupCreateMkStream(ctx, r.config.StreamKey, r.config.ConsumerGroup, "0").Err()
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return err
+	}
+
+	r.logger.Info(
+		"audit recorder started",
+		"group", r.config.ConsumerGroup,
+		"consumer", r.config.ConsumerName,
+	)
+
+	// Start with whatever this consumer left unacknowledged before it stopped
+	pending := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			id := ">"
+			if pending {
+				id = "0"
+			}
+
+			streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    r.config.ConsumerGroup,
+				Consumer: r.config.ConsumerName,
+				Streams:  []string{r.config.StreamKey, id},
+				Block:    r.config.BlockingDuration,
+				Count:    int64(r.config.BatchSize),
+			}).Result()
+
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				r.logger.Error("Error reading audit events", "error", err)
+				time.Sleep(r.config.RetryDelay)
+				continue
+			}
+
+			if len(streams) == 0 || len(streams[0].Messages) == 0 {
+				pending = false
+				continue
+			}
+
+			err = r.recordBatch(ctx, streams[0].Messages)
+			if err != nil {
+				r.logger.Error("failed to record audit events",
+					"error", err,
+					"batch_size", len(streams[0].Messages))
+				pending = true
+				time.Sleep(r.config.RetryDelay)
+			}
+		}
+	}
+}
+
+// recordBatch stores a batch of events and acknowledges it. Entries that
+// cannot be parsed are dead lettered without being stored. If the batch cannot
+// be stored as a whole while the database is up, its events are stored one at
+// a time to find the ones that are rejected.
+func (r *Recorder) recordBatch(ctx context.Context, entries []redis.XMessage) error {
+	events := make([]*data.AuditEvent, 0, len(entries))
+	ids := make([]string, 0, len(entries))
+	// parsed holds the entry of each event, at the same index
+	parsed := make([]redis.XMessage, 0, len(entries))
+
+	for _, entry := range entries {
+		event, err := parseEvent(entry)
+		if err != nil {
+			r.logger.Error("invalid audit event", "error", err, "entry_id", entry.ID)
+			err = r.deadLetter(ctx, entry, err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		events = append(events, event)
+		ids = append(ids, entry.ID)
+		parsed = append(parsed, entry)
+	}
+
+	err := r.models.AuditEvents.BulkInsert(ctx, events)
+	if err == nil {
+		for _, id := range ids {
+			delete(r.attempts, id)
+		}
+		return r.ack(ctx, ids...)
+	}
+
+	// Nothing can be told about the events while the database is unavailable,
+	// so the whole batch waits for it
+	pingErr := r.models.AuditEvents.DB.PingContext(ctx)
+	if pingErr != nil {
+		return err
+	}
+
+	var failed error
+
+	for i, event := range events {
+		entry := parsed[i]
+
+		insertErr := r.models.AuditEvents.BulkInsert(ctx, []*data.AuditEvent{event})
+		if insertErr == nil {
+			delete(r.attempts, entry.ID)
+			err = r.ack(ctx, entry.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		r.attempts[entry.ID]++
+		if r.attempts[entry.ID] < r.config.MaxAttempts {
+			failed = insertErr
+			continue
+		}
+
+		r.logger.Error("audit event failed after retries",
+			"error", insertErr,
+			"event_id", event.EventID,
+			"action", event.Action)
+
+		err = r.deadLetter(ctx, entry, insertErr)
+		if err != nil {
+			return err
+		}
+		delete(r.attempts, entry.ID)
+	}
+
+	return failed
+}
+
+// parseEvent reads an audit event from its stream entry
+func parseEvent(entry redis.XMessage) (*data.AuditEvent, error) {
+	eventJSON, ok := entry.Values["event"].(string)
+	if !ok {
+		return nil, errors.New("invalid audit event format")
+	}
+
+	var event data.AuditEvent
+	err := json.Unmarshal([]byte(eventJSON), &event)
+	if err != nil {
+		return nil, err
+	}
+
+	// The event ID is not part of the event's JSON
+	event.EventID, _ = entry.Values["event_id"].(string)
+	if event.EventID == "" {
+		event.EventID = entry.ID
+	}
+
+	return &event, nil
+}
+
+func (r *Recorder) ack(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.client.XAck(ctx, r.config.StreamKey, r.config.ConsumerGroup, ids...).Err()
+}
+
+// deadLetter moves an entry that cannot be recorded to the DLQ, with the error
+// that stopped it, and acknowledges it
+func (r *Recorder) deadLetter(ctx context.Context, entry redis.XMessage, recordErr error) error {
+	values := make(map[string]any, len(entry.Values)+1)
+	for field, value := range entry.Values {
+		values[field] = value
+	}
+	values["error"] = recordErr.Error()
+
+	err := r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.config.DLQKey,
+		Values: values,
+	}).Err()
+	if err != nil {
+		return err
+	}
+
+	return r.ack(ctx, entry.ID)
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestRecordBatch checks that events the database rejects are dead lettered
+// once all attempts are used, without holding up the rest of their batch. It
+// needs the database in IM_TEST_DB_DSN, which must have every migration
+// applied, and the Redis server in IM_TEST_REDIS_ADDR, and is skipped unless
+// both are set. The audit log is append-only, so the events it records stay.
+func TestRecordBatch(t *testing.T) {
+	dsn := os.Getenv("IM_TEST_DB_DSN")
+	addr := os.Getenv("IM_TEST_REDIS_ADDR")
+	if dsn == "" || addr == "" {
+		t.Skip("IM_TEST_DB_DSN or IM_TEST_REDIS_ADDR is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	ctx := context.Background()
+	prefix := "test:audit:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	config := Config{
+		StreamKey:     prefix + ":events",
+		ConsumerGroup: "recorders",
+		ConsumerName:  "recorder",
+		DLQKey:        prefix + ":dlq",
+		BatchSize:     10,
+		MaxAttempts:   2,
+	}
+	defer client.Del(ctx, config.StreamKey, config.DLQKey)
+
+	err = client.XGroupCreateMkStream(ctx, config.StreamKey, config.ConsumerGroup, "0").Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := data.NewModels(db)
+	r := NewRecorder(client, config, slog.New(slog.NewTextHandler(io.Discard, nil)), models)
+	p := NewPublisher(client, config.StreamKey)
+
+	valid := &data.AuditEvent{
+		OrganizationID: 1,
+		Action:         data.AuditTokenCreated,
+		ActorType:      data.ActorUser,
+		ActorID:        1,
+		TargetType:     data.TargetUser,
+		TargetID:       "1",
+	}
+	// The database only accepts the known actor types
+	rejected := &data.AuditEvent{
+		OrganizationID: 1,
+		Action:         data.AuditTokenCreated,
+		ActorType:      "robot",
+		TargetType:     data.TargetUser,
+		TargetID:       "1",
+	}
+
+	for _, event := range []*data.AuditEvent{valid, rejected} {
+		err = p.Publish(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = client.XAdd(ctx, &redis.XAddArgs{
+		Stream: config.StreamKey,
+		Values: map[string]any{"event": "not json"},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(id string) []redis.XMessage {
+		t.Helper()
+
+		streams, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    config.ConsumerGroup,
+			Consumer: config.ConsumerName,
+			Streams:  []string{config.StreamKey, id},
+			Count:    int64(config.BatchSize),
+			Block:    -1,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			t.Fatal(err)
+		}
+		if len(streams) == 0 {
+			return nil
+		}
+		return streams[0].Messages
+	}
+
+	dlqLength := func() int64 {
+		t.Helper()
+
+		n, err := client.XLen(ctx, config.DLQKey).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// The first attempt stores the valid event and leaves the rejected one
+	// pending
+	err = r.recordBatch(ctx, read(">"))
+	if err == nil {
+		t.Fatal("got no error for a batch with a rejected event")
+	}
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM audit_events WHERE event_id = $1", valid.EventID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d stored valid events; want 1", count)
+	}
+
+	if n := dlqLength(); n != 1 {
+		t.Errorf("got DLQ length %d after the first attempt; want 1 for the malformed entry", n)
+	}
+
+	pending := read("0")
+	if len(pending) != 1 || pending[0].Values["event_id"] != rejected.EventID {
+		t.Fatalf("got pending entries %v; want only the rejected event", pending)
+	}
+
+	// The last attempt moves the rejected event to the DLQ
+	err = r.recordBatch(ctx, pending)
+	if err != nil {
+		t.Fatalf("got error %v; want the rejected event dead lettered", err)
+	}
+
+	if pending := read("0"); len(pending) != 0 {
+		t.Errorf("got %d pending entries; want 0", len(pending))
+	}
+
+	dlq, err := client.XRange(ctx, config.DLQKey, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dlq) != 2 {
+		t.Fatalf("got DLQ length %d; want 2", len(dlq))
+	}
+
+	if dlq[1].Values["event_id"] != rejected.EventID || dlq[1].Values["error"] == "" {
+		t.Errorf("got DLQ entry %v; want the rejected event with its error", dlq[1].Values)
+	}
+}
@@ -0,0 +1,262 @@
+package autoreply
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/policy"
+	"github.com/araaavind/zoko-im/internal/queue"
+	"github.com/araaavind/zoko-im/internal/textmatch"
+	"github.com/redis/go-redis/v9"
+)
+
+// Engine evaluates users' auto reply rules against the messages they receive
+// and enqueues the replies.
+//
+// Two guards keep bots from replying to each other forever: an auto reply never
+// triggers another auto reply, and a user sends at most one auto reply to the
+// same person per cooldown, no matter which rule matched.
+//
+// Auto replies are sent on their owner's behalf, so they follow the same rules
+// as the owner's own messages: suspended users send none, and blocks and the
+// receiver's privacy settings apply.
+type Engine struct {
+	queue    queue.Enqueuer
+	client   *redis.Client
+	cooldown time.Duration
+	logger   *slog.Logger
+	models   data.Models
+
+	mu      sync.Mutex
+	regexps map[string]*regexp.Regexp
+}
+
+// maxCachedPatterns bounds how many compiled patterns the engine keeps, since
+// rules can be edited to new patterns at any time
+const maxCachedPatterns = 1000
+
+func NewEngine(q queue.Enqueuer, client *redis.Client, cooldown time.Duration, logger *slog.Logger, models data.Models) *Engine {
+	return &Engine{
+		queue:    q,
+		client:   client,
+		cooldown: cooldown,
+		logger:   logger,
+		models:   models,
+		regexps:  make(map[string]*regexp.Regexp),
+	}
+}
+
+func cooldownKey(userID, otherID int64) string {
+	return fmt.Sprintf("autoreply:cooldown:%d:%d", userID, otherID)
+}
+
+// Handle answers the persisted messages that match one of their receiver's
+// rules. It is meant to run as a queue.PersistHook.
+func (e *Engine) Handle(ctx context.Context, messages []*data.Message) {
+	var candidates []*data.Message
+	receiverIDs := make([]int64, 0, len(messages))
+
+	for _, message := range messages {
+		if message.Kind != data.MessageKindText || message.SenderID == message.ReceiverID {
+			continue
+		}
+		candidates = append(candidates, message)
+		receiverIDs = append(receiverIDs, message.ReceiverID)
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	rules, err := e.models.AutoReplyRules.GetActiveForUsers(ctx, receiverIDs)
+	if err != nil {
+		e.logger.Error("failed to load auto reply rules", "error", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, message := range candidates {
+		rule := e.Evaluate(rules[message.ReceiverID], message.Content, now)
+		if rule == nil {
+			continue
+		}
+
+		err := e.reply(ctx, rule, message)
+		if err != nil {
+			e.logger.Error("failed to send auto reply", "error", err, "rule_id", rule.ID, "message_id", message.ID)
+		}
+	}
+}
+
+func (e *Engine) reply(ctx context.Context, rule *data.AutoReplyRule, message *data.Message) error {
+	ok, err := e.client.SetNX(ctx, cooldownKey(rule.UserID, message.SenderID), rule.ID, e.cooldown).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	users, err := e.models.Users.GetMany(ctx, message.OrganizationID, []int64{rule.UserID, message.SenderID})
+	if err != nil {
+		return err
+	}
+
+	var owner, sender *data.User
+	for _, user := range users {
+		switch user.ID {
+		case rule.UserID:
+			owner = user
+		case message.SenderID:
+			sender = user
+		}
+	}
+
+	err = policy.CanSend(owner)
+	if err == nil {
+		err = policy.CanMessage(ctx, e.models, owner.ID, sender)
+	}
+	if err != nil {
+		if policy.IsDenied(err) {
+			e.logger.Info("auto reply not allowed", "reason", err, "rule_id", rule.ID, "message_id", message.ID)
+			return nil
+		}
+		return err
+	}
+
+	reply := &data.Message{
+		OrganizationID: message.OrganizationID,
+		Timestamp:      time.Now(),
+		Content:        Render(rule, sender),
+		SenderID:       rule.UserID,
+		ReceiverID:     message.SenderID,
+		ReadStatus:     false,
+		Kind:           data.MessageKindAutoReply,
+	}
+
+	return e.queue.EnqueueMessage(ctx, reply)
+}
+
+// Evaluate returns the first of rules that matches content at the given time,
+// or nil. Rules are expected in priority order.
+func (e *Engine) Evaluate(rules []*data.AutoReplyRule, content string, now time.Time) *data.AutoReplyRule {
+	for _, rule := range rules {
+		if !InHours(rule, now) {
+			continue
+		}
+
+		switch rule.MatchType {
+		case data.MatchTypeKeyword:
+			if textmatch.MatchKeywords(rule.Keywords, content) {
+				return rule
+			}
+		case data.MatchTypeRegex:
+			rx, err := e.compile(rule.Pattern)
+			if err != nil {
+				e.logger.Error("invalid auto reply pattern", "error", err, "rule_id", rule.ID)
+				continue
+			}
+			if rx.MatchString(content) {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+// compile caches compiled patterns, since the same rules are evaluated for
+// every message their owner receives. Once the cache is full an arbitrary
+// pattern makes room for the new one.
+func (e *Engine) compile(pattern string) (*regexp.Regexp, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rx, ok := e.regexps[pattern]; ok {
+		return rx, nil
+	}
+
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.regexps) >= maxCachedPatterns {
+		for cached := range e.regexps {
+			delete(e.regexps, cached)
+			break
+		}
+	}
+
+	e.regexps[pattern] = rx
+	return rx, nil
+}
+
+// InHours reports whether the rule's business hours condition holds at the
+// given time
+func InHours(rule *data.AutoReplyRule, now time.Time) bool {
+	if rule.HoursCondition == data.HoursAny || rule.BusinessHours == nil {
+		return true
+	}
+
+	open := IsOpen(rule.BusinessHours, now)
+	if rule.HoursCondition == data.HoursWithin {
+		return open
+	}
+	return !open
+}
+
+// IsOpen reports whether the given time falls within the business hours
+func IsOpen(hours *data.BusinessHours, now time.Time) bool {
+	loc, err := time.LoadLocation(hours.Timezone)
+	if err != nil {
+		return false
+	}
+
+	start, err := time.Parse("15:04", hours.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", hours.End)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	isOpenDay := func(day time.Weekday) bool {
+		for _, d := range hours.Days {
+			if time.Weekday(d) == day {
+				return true
+			}
+		}
+		return false
+	}
+
+	if startMinute < endMinute {
+		return isOpenDay(local.Weekday()) && minute >= startMinute && minute < endMinute
+	}
+
+	// The window runs past midnight, so early hours belong to the previous
+	// day's opening
+	if minute >= startMinute {
+		return isOpenDay(local.Weekday())
+	}
+	return minute < endMinute && isOpenDay((local.Weekday()+6)%7)
+}
+
+// Render returns the rule's reply with its placeholders filled in for the
+// person being answered
+func Render(rule *data.AutoReplyRule, sender *data.User) string {
+	template := &data.MessageTemplate{Body: rule.Reply}
+	return template.Render(map[string]string{"sender_name": sender.FullName})
+}
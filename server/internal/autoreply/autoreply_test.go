@@ -0,0 +1,330 @@
+package autoreply
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/queue"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/redis/go-redis/v9"
+)
+
+// recordingQueue keeps the messages enqueued by the engine
+type recordingQueue struct {
+	mu       sync.Mutex
+	messages []*data.Message
+}
+
+func (q *recordingQueue) EnqueueMessage(ctx context.Context, message *data.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, message)
+	return nil
+}
+
+func (q *recordingQueue) EnqueueMessages(ctx context.Context, messages []*data.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, messages...)
+	return nil
+}
+
+func (q *recordingQueue) count() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+func newTestEngine(q queue.Enqueuer, client *redis.Client, models data.Models) *Engine {
+	return NewEngine(q, client, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)), models)
+}
+
+// at returns the given wall clock time in Kolkata, which has no daylight
+// saving time. 2026-01-05 is a Monday.
+func at(t *testing.T, day time.Weekday, clock string) time.Time {
+	t.Helper()
+
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hm, err := time.Parse("15:04", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return time.Date(2026, 1, 4+int(day), hm.Hour(), hm.Minute(), 0, 0, loc)
+}
+
+func TestIsOpen(t *testing.T) {
+	weekdays := &data.BusinessHours{Timezone: "Asia/Kolkata", Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"}
+	overnight := &data.BusinessHours{Timezone: "Asia/Kolkata", Days: []int{5}, Start: "22:00", End: "06:00"}
+
+	tests := []struct {
+		name  string
+		hours *data.BusinessHours
+		now   time.Time
+		want  bool
+	}{
+		{"opening minute", weekdays, at(t, time.Monday, "09:00"), true},
+		{"during the day", weekdays, at(t, time.Wednesday, "13:30"), true},
+		{"before opening", weekdays, at(t, time.Monday, "08:59"), false},
+		{"closing minute", weekdays, at(t, time.Friday, "17:00"), false},
+		{"closed day", weekdays, at(t, time.Saturday, "13:30"), false},
+		{"other time zone", weekdays, at(t, time.Monday, "10:00").UTC(), true},
+		{"overnight evening", overnight, at(t, time.Friday, "23:00"), true},
+		{"overnight at midnight", overnight, at(t, time.Saturday, "00:00"), true},
+		{"overnight early hours", overnight, at(t, time.Saturday, "05:59"), true},
+		{"overnight closing minute", overnight, at(t, time.Saturday, "06:00"), false},
+		{"overnight early hours of the open day", overnight, at(t, time.Friday, "03:00"), false},
+		{"overnight evening of the next day", overnight, at(t, time.Saturday, "23:00"), false},
+		{"overnight from Saturday into Sunday", &data.BusinessHours{Timezone: "Asia/Kolkata", Days: []int{6}, Start: "20:00", End: "02:00"}, at(t, time.Sunday, "01:00"), true},
+		{"unknown time zone", &data.BusinessHours{Timezone: "Mars/Olympus", Days: []int{1}, Start: "00:00", End: "23:59"}, at(t, time.Monday, "12:00"), false},
+		{"invalid start", &data.BusinessHours{Timezone: "Asia/Kolkata", Days: []int{1}, Start: "9am", End: "17:00"}, at(t, time.Monday, "12:00"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsOpen(tt.hours, tt.now); got != tt.want {
+				t.Errorf("IsOpen at %s = %t; want %t", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInHours(t *testing.T) {
+	hours := &data.BusinessHours{Timezone: "Asia/Kolkata", Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"}
+	open := at(t, time.Monday, "10:00")
+	closed := at(t, time.Sunday, "10:00")
+
+	tests := []struct {
+		name      string
+		condition string
+		hours     *data.BusinessHours
+		now       time.Time
+		want      bool
+	}{
+		{"any while open", data.HoursAny, hours, open, true},
+		{"any while closed", data.HoursAny, hours, closed, true},
+		{"within while open", data.HoursWithin, hours, open, true},
+		{"within while closed", data.HoursWithin, hours, closed, false},
+		{"outside while open", data.HoursOutside, hours, open, false},
+		{"outside while closed", data.HoursOutside, hours, closed, true},
+		{"no hours", data.HoursWithin, nil, closed, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &data.AutoReplyRule{HoursCondition: tt.condition, BusinessHours: tt.hours}
+			if got := InHours(rule, tt.now); got != tt.want {
+				t.Errorf("InHours = %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	hours := &data.BusinessHours{Timezone: "Asia/Kolkata", Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"}
+
+	afterHours := &data.AutoReplyRule{ID: 1, MatchType: data.MatchTypeKeyword, Keywords: []string{"hello"}, HoursCondition: data.HoursOutside, BusinessHours: hours}
+	pricing := &data.AutoReplyRule{ID: 2, MatchType: data.MatchTypeKeyword, Keywords: []string{"price", "cost"}, HoursCondition: data.HoursAny}
+	order := &data.AutoReplyRule{ID: 3, MatchType: data.MatchTypeRegex, Pattern: `(?i)order\s+#?\d+`, HoursCondition: data.HoursAny}
+	invalid := &data.AutoReplyRule{ID: 4, MatchType: data.MatchTypeRegex, Pattern: `(`, HoursCondition: data.HoursAny}
+	greeting := &data.AutoReplyRule{ID: 5, MatchType: data.MatchTypeKeyword, Keywords: []string{"hello"}, HoursCondition: data.HoursAny}
+
+	rules := []*data.AutoReplyRule{afterHours, pricing, invalid, order, greeting}
+
+	open := at(t, time.Monday, "10:00")
+	closed := at(t, time.Monday, "20:00")
+
+	tests := []struct {
+		name    string
+		rules   []*data.AutoReplyRule
+		content string
+		now     time.Time
+		want    *data.AutoReplyRule
+	}{
+		{"keyword", rules, "How much does it cost?", open, pricing},
+		{"regex", rules, "Where is ORDER #1234?", open, order},
+		{"first match wins", rules, "hello, what is the price", closed, afterHours},
+		{"skips rules out of hours", rules, "hello", open, greeting},
+		{"skips invalid patterns", []*data.AutoReplyRule{invalid, greeting}, "hello", open, greeting},
+		{"no match", rules, "Thanks!", open, nil},
+		{"no rules", nil, "hello", open, nil},
+	}
+
+	e := newTestEngine(&recordingQueue{}, nil, data.Models{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.Evaluate(tt.rules, tt.content, tt.now)
+			if got != tt.want {
+				t.Errorf("Evaluate(%q) = %v; want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileCacheIsBounded(t *testing.T) {
+	e := newTestEngine(&recordingQueue{}, nil, data.Models{})
+
+	for i := 0; i < maxCachedPatterns+10; i++ {
+		_, err := e.compile(fmt.Sprintf("pattern %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(e.regexps) != maxCachedPatterns {
+		t.Errorf("got %d cached patterns; want %d", len(e.regexps), maxCachedPatterns)
+	}
+}
+
+// TestHandleSkipsMessagesThatCannotTriggerReplies checks the first loop guard.
+// The engine has no database, so it would fail if it looked any rules up.
+func TestHandleSkipsMessagesThatCannotTriggerReplies(t *testing.T) {
+	q := &recordingQueue{}
+	e := newTestEngine(q, nil, data.Models{})
+
+	e.Handle(context.Background(), []*data.Message{
+		{ID: 1, SenderID: 1, ReceiverID: 2, Content: "hello", Kind: data.MessageKindAutoReply},
+		{ID: 2, SenderID: 1, ReceiverID: 2, Content: "hello", Kind: data.MessageKindSystem},
+		{ID: 3, SenderID: 1, ReceiverID: 1, Content: "hello", Kind: data.MessageKindText},
+	})
+
+	if q.count() != 0 {
+		t.Errorf("got %d replies; want none", q.count())
+	}
+}
+
+// TestHandle runs the engine against the database in IM_TEST_DB_DSN, which
+// must have every migration applied, and the Redis server in
+// IM_TEST_REDIS_ADDR. It is skipped unless both are set.
+func TestHandle(t *testing.T) {
+	dsn := os.Getenv("IM_TEST_DB_DSN")
+	addr := os.Getenv("IM_TEST_REDIS_ADDR")
+	if dsn == "" || addr == "" {
+		t.Skip("IM_TEST_DB_DSN or IM_TEST_REDIS_ADDR is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	ctx := context.Background()
+	models := data.NewModels(db)
+
+	organization := &data.Organization{Name: "Auto replies"}
+	business := &data.Member{FullName: "Business"}
+	err = models.Organizations.InsertWithOwner(ctx, organization, business)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM organizations WHERE id = $1", organization.ID)
+
+	newCustomer := func(name string) *data.Member {
+		customer := &data.Member{FullName: name, Role: data.RoleEndUser}
+		err := models.Organizations.AddMember(ctx, organization.ID, customer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return customer
+	}
+
+	customer := newCustomer("Customer")
+	blocked := newCustomer("Blocked")
+
+	err = models.AutoReplyRules.Insert(ctx, &data.AutoReplyRule{
+		UserID:         business.UserID,
+		Name:           "Greeting",
+		MatchType:      data.MatchTypeKeyword,
+		Keywords:       []string{"hello"},
+		HoursCondition: data.HoursAny,
+		Reply:          "Hi {{sender_name}}",
+		Active:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Blocks.Insert(ctx, &data.Block{BlockerID: blocked.UserID, BlockedID: business.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := func(senderID int64) []*data.Message {
+		return []*data.Message{{
+			OrganizationID: organization.ID,
+			SenderID:       senderID,
+			ReceiverID:     business.UserID,
+			Content:        "hello",
+			Kind:           data.MessageKindText,
+		}}
+	}
+
+	t.Run("replies once per cooldown", func(t *testing.T) {
+		client.Del(ctx, cooldownKey(business.UserID, customer.UserID))
+
+		q := &recordingQueue{}
+		e := newTestEngine(q, client, models)
+
+		e.Handle(ctx, hello(customer.UserID))
+		e.Handle(ctx, hello(customer.UserID))
+
+		if q.count() != 1 {
+			t.Fatalf("got %d replies; want 1", q.count())
+		}
+
+		reply := q.messages[0]
+		if reply.Kind != data.MessageKindAutoReply || reply.ReceiverID != customer.UserID || reply.Content != "Hi Customer" {
+			t.Errorf("got reply %+v", reply)
+		}
+	})
+
+	t.Run("does not reply to someone who blocked the user", func(t *testing.T) {
+		client.Del(ctx, cooldownKey(business.UserID, blocked.UserID))
+
+		q := &recordingQueue{}
+		e := newTestEngine(q, client, models)
+
+		e.Handle(ctx, hello(blocked.UserID))
+
+		if q.count() != 0 {
+			t.Errorf("got %d replies; want none", q.count())
+		}
+	})
+
+	t.Run("does not reply for a suspended user", func(t *testing.T) {
+		other := newCustomer("Other")
+		client.Del(ctx, cooldownKey(business.UserID, other.UserID))
+
+		_, err := db.Exec("UPDATE users SET suspended_at = NOW() WHERE id = $1", business.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q := &recordingQueue{}
+		e := newTestEngine(q, client, models)
+
+		e.Handle(ctx, hello(other.UserID))
+
+		if q.count() != 0 {
+			t.Errorf("got %d replies; want none", q.count())
+		}
+	})
+}
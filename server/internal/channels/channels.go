@@ -0,0 +1,315 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+// Adapter delivers messages to people reached through an external channel.
+// Send returns the provider's ID for the message, if it has one, so that later
+// delivery status callbacks can be matched to it.
+type Adapter interface {
+	Channel() string
+	Send(ctx context.Context, sender *data.User, to string, message *data.Message) (string, error)
+}
+
+type Config struct {
+	StreamKey        string
+	ConsumerGroup    string
+	ConsumerName     string
+	RetryKey         string
+	DLQKey           string
+	BlockingDuration time.Duration
+	BatchSize        int
+	MaxAttempts      int
+	InitialBackoff   time.Duration
+	RetryInterval    time.Duration
+	Timeout          time.Duration
+}
+
+// Router hands persisted messages whose receiver is an external contact to the
+// adapter for that contact's channel. Deliveries go through their own stream,
+// so a slow or failing provider never holds up the message stream. Failed
+// deliveries are retried with exponential backoff and end up in their own DLQ
+// once all attempts are used.
+type Router struct {
+	client   *redis.Client
+	config   Config
+	adapters map[string]Adapter
+	retries  *queue.RetrySet
+	logger   *slog.Logger
+	models   data.Models
+}
+
+func NewRouter(client *redis.Client, config Config, logger *slog.Logger, models data.Models, adapters ...Adapter) *Router {
+	r := &Router{
+		client:   client,
+		config:   config,
+		adapters: make(map[string]Adapter, len(adapters)),
+		retries:  queue.NewRetrySet(client, config.RetryKey, config.StreamKey, config.RetryInterval, config.BatchSize, logger),
+		logger:   logger,
+		models:   models,
+	}
+
+	for _, adapter := range adapters {
+		r.adapters[adapter.Channel()] = adapter
+	}
+
+	return r
+}
+
+// Enqueue adds a delivery for every message addressed to an external contact
+// to the delivery stream. It is meant to run as a queue.PersistHook.
+func (r *Router) Enqueue(ctx context.Context, messages []*data.Message) {
+	receiverIDs := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		receiverIDs = append(receiverIDs, message.ReceiverID)
+	}
+
+	identities, err := r.models.ExternalIdentities.GetForUsers(ctx, receiverIDs)
+	if err != nil {
+		r.logger.Error("failed to load external identities", "error", err)
+		return
+	}
+
+	if len(identities) == 0 {
+		return
+	}
+
+	pipe := r.client.Pipeline()
+
+	for _, message := range messages {
+		if _, ok := identities[message.ReceiverID]; !ok || message.Kind == data.MessageKindSystem {
+			continue
+		}
+
+		messageJSON, err := json.Marshal(message)
+		if err != nil {
+			r.logger.Error("failed to encode message for delivery", "error", err, "message_id", message.ID)
+			continue
+		}
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.config.StreamKey,
+			Values: map[string]any{
+				"message": string(messageJSON),
+				"attempt": "1",
+			},
+		})
+	}
+
+	_, err = pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		r.logger.Error("failed to enqueue external deliveries", "error", err)
+	}
+}
+
+// Run delivers messages until the context is cancelled. It also moves failed
+// deliveries back onto the stream once they are due to be retried.
+func (r *Router) Run(ctx context.Context) error {
+	err := r.client.XGroupCreateMkStream(ctx, r.config.StreamKey, r.config.ConsumerGroup, "0").Err()
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return err
+	}
+
+	go func() {
+		err := r.retries.Run(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			r.logger.Error("delivery retries stopped", "error", err)
+		}
+	}()
+
+	r.logger.Info(
+		"channel router started",
+		"group", r.config.ConsumerGroup,
+		"consumer", r.config.ConsumerName,
+	)
+
+	// Start with whatever this consumer left unacknowledged before it stopped
+	pending := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			id := ">"
+			if pending {
+				id = "0"
+			}
+
+			streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    r.config.ConsumerGroup,
+				Consumer: r.config.ConsumerName,
+				Streams:  []string{r.config.StreamKey, id},
+				Block:    r.config.BlockingDuration,
+				Count:    int64(r.config.BatchSize),
+			}).Result()
+
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				r.logger.Error("Error reading external deliveries", "error", err)
+				time.Sleep(r.config.InitialBackoff)
+				continue
+			}
+
+			if len(streams) == 0 || len(streams[0].Messages) == 0 {
+				pending = false
+				continue
+			}
+
+			pending = !r.deliverBatch(ctx, streams[0].Messages)
+		}
+	}
+}
+
+// deliverBatch attempts every delivery in the batch and acknowledges the ones
+// that were handled, whether they were sent, scheduled for a retry or dead
+// lettered. It returns false if any delivery has to be read again.
+func (r *Router) deliverBatch(ctx context.Context, entries []redis.XMessage) bool {
+	ids := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		err := r.handle(ctx, entry)
+		if err != nil {
+			r.logger.Error("failed to handle external delivery", "error", err, "entry_id", entry.ID)
+			continue
+		}
+		ids = append(ids, entry.ID)
+	}
+
+	if len(ids) > 0 {
+		// The batch is acknowledged even while shutting down, so that handled
+		// deliveries are not sent again
+		ackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		err := r.client.XAck(ackCtx, r.config.StreamKey, r.config.ConsumerGroup, ids...).Err()
+		if err != nil {
+			r.logger.Error("failed to acknowledge external deliveries", "error", err)
+			return false
+		}
+	}
+
+	return len(ids) == len(entries)
+}
+
+// handle makes one delivery attempt. It only returns an error if the attempt
+// could neither be completed nor scheduled for a retry.
+func (r *Router) handle(ctx context.Context, entry redis.XMessage) error {
+	messageJSON, _ := entry.Values["message"].(string)
+	attempt, _ := strconv.Atoi(fmt.Sprint(entry.Values["attempt"]))
+
+	var message data.Message
+	err := json.Unmarshal([]byte(messageJSON), &message)
+	if err != nil || attempt < 1 {
+		r.logger.Error("invalid external delivery", "error", err, "entry_id", entry.ID)
+		return nil
+	}
+
+	channel, externalMessageID, err := r.deliver(ctx, &message)
+	if err == nil {
+		// The message is out, so it must not be sent again even if its status
+		// cannot be recorded
+		err = r.recordDelivery(ctx, &message, data.DeliveryStatusSent, channel, externalMessageID)
+		if err != nil {
+			r.logger.Error("failed to record delivery status", "error", err, "message_id", message.ID)
+		}
+		return nil
+	}
+
+	r.logger.Warn("external delivery failed",
+		"error", err,
+		"message_id", message.ID,
+		"attempt", attempt)
+
+	if attempt < r.config.MaxAttempts && !errors.Is(err, errNoAdapter) {
+		return r.retries.Add(ctx, map[string]string{
+			"message": messageJSON,
+			"attempt": strconv.Itoa(attempt + 1),
+		}, queue.Backoff(r.config.InitialBackoff, attempt+1))
+	}
+
+	return r.deadLetter(ctx, &message, messageJSON, err)
+}
+
+// errNoAdapter is returned for channels the worker has not been configured for
+var errNoAdapter = errors.New("no adapter configured for channel")
+
+// deliver sends the message through its receiver's channel and returns the
+// channel along with the provider's ID for the message
+func (r *Router) deliver(ctx context.Context, message *data.Message) (string, string, error) {
+	identity, err := r.models.ExternalIdentities.GetForUser(ctx, message.ReceiverID)
+	if err != nil {
+		return "", "", err
+	}
+
+	adapter, ok := r.adapters[identity.Channel]
+	if !ok {
+		return "", "", fmt.Errorf("%w %s", errNoAdapter, identity.Channel)
+	}
+
+	sender, err := r.models.Users.Get(ctx, message.OrganizationID, message.SenderID)
+	if err != nil {
+		return "", "", err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	externalMessageID, err := adapter.Send(sendCtx, sender, identity.ExternalID, message)
+	return identity.Channel, externalMessageID, err
+}
+
+// deadLetter moves a delivery that used all its attempts to the DLQ and marks
+// the message as failed
+func (r *Router) deadLetter(ctx context.Context, message *data.Message, messageJSON string, deliveryErr error) error {
+	r.logger.Error("external delivery failed after retries",
+		"error", deliveryErr,
+		"message_id", message.ID)
+
+	// The delivery is settled even while shutting down, so that it is not
+	// attempted again
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	err := r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.config.DLQKey,
+		Values: map[string]any{
+			"message": messageJSON,
+			"error":   deliveryErr.Error(),
+		},
+	}).Err()
+	if err != nil {
+		return err
+	}
+
+	err = r.recordDelivery(ctx, message, data.DeliveryStatusFailed, "", "")
+	if err != nil {
+		r.logger.Error("failed to record delivery status", "error", err, "message_id", message.ID)
+	}
+
+	return nil
+}
+
+func (r *Router) recordDelivery(ctx context.Context, message *data.Message, status, channel, externalMessageID string) error {
+	err := r.models.Messages.UpdateDelivery(ctx, message.OrganizationID, message.ID, status, channel, externalMessageID)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		// The message was deleted or has disappeared since it was sent
+		return nil
+	}
+	return err
+}
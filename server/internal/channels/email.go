@@ -0,0 +1,80 @@
+package channels
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// Email delivers messages as plain text emails over SMTP. The external ID of
+// an email contact is their address.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (e *Email) Channel() string {
+	return data.ChannelEmail
+}
+
+func (e *Email) Send(ctx context.Context, sender *data.User, to string, message *data.Message) (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	_, domain, _ := strings.Cut(e.From, "@")
+	messageID := fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s <%s>\r\n", sanitizeHeader(sender.FullName), e.From)
+	fmt.Fprintf(&b, "To: <%s>\r\n", to)
+	fmt.Fprintf(&b, "Subject: New message from %s\r\n", sanitizeHeader(sender.FullName))
+	fmt.Fprintf(&b, "Date: %s\r\n", message.Timestamp.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: %s\r\n", messageID)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(message.Content)
+	b.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	// net/smtp has no context support, so the send runs in a goroutine and is
+	// abandoned if the context expires first
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(e.Host, strconv.Itoa(e.Port)), auth, e.From, []string{to}, []byte(b.String()))
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return messageID, nil
+}
+
+// sanitizeHeader keeps user supplied values from injecting extra headers
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ", "<", "", ">", "").Replace(value)
+}
@@ -0,0 +1,195 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// smtpMail is what the stub server received in one SMTP transaction
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// newSMTPStub starts an SMTP server on a local port that accepts any mail and
+// sends what it received on the returned channel
+func newSMTPStub(t *testing.T) (host string, port int, mails <-chan smtpMail) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan smtpMail, 1)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, received)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+func serveSMTP(conn net.Conn, received chan<- smtpMail) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	var mail smtpMail
+
+	tp.PrintfLine("220 localhost ESMTP stub")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			mail.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			body, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(body)
+			tp.PrintfLine("250 OK")
+			received <- mail
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+	host, port, mails := newSMTPStub(t)
+
+	adapter := &Email{Host: host, Port: port, From: "chat@example.com"}
+
+	sender := &data.User{ID: 1, FullName: "Alice\r\nBcc: <victim@example.com>"}
+	message := &data.Message{
+		ID:        7,
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Content:   "Hello there",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	messageID, err := adapter.Send(ctx, sender, "bob@example.net", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(messageID, "<") || !strings.HasSuffix(messageID, "@example.com>") {
+		t.Errorf("got message ID %q; want <...@example.com>", messageID)
+	}
+
+	var mail smtpMail
+	select {
+	case mail = <-mails:
+	case <-ctx.Done():
+		t.Fatal("the stub server received no mail")
+	}
+
+	if mail.from != "chat@example.com" {
+		t.Errorf("got sender %q; want %q", mail.from, "chat@example.com")
+	}
+	if len(mail.to) != 1 || mail.to[0] != "bob@example.net" {
+		t.Errorf("got recipients %q; want [bob@example.net]", mail.to)
+	}
+
+	header, body, _ := strings.Cut(mail.data, "\n\n")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"from", "From: Alice  Bcc: victim@example.com <chat@example.com>"},
+		{"to", "To: <bob@example.net>"},
+		{"subject", "Subject: New message from Alice  Bcc: victim@example.com"},
+		{"date", "Date: Fri, 02 Jan 2026 03:04:05 +0000"},
+		{"message ID", "Message-ID: " + messageID},
+	}
+
+	lines := strings.Split(header, "\n")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, line := range lines {
+				if line == tt.want {
+					return
+				}
+			}
+			t.Errorf("header %q not found in:\n%s", tt.want, header)
+		})
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("the sender's name injected a header: %q", line)
+		}
+	}
+
+	if strings.TrimSpace(body) != "Hello there" {
+		t.Errorf("got body %q; want %q", body, "Hello there")
+	}
+}
+
+func TestEmailSendTimeout(t *testing.T) {
+	// The server accepts connections but never greets the client
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	adapter := &Email{Host: addr.IP.String(), Port: addr.Port, From: "chat@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = adapter.Send(ctx, &data.User{FullName: "Alice"}, "bob@example.net", &data.Message{Content: "Hi"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+}
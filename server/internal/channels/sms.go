@@ -0,0 +1,76 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// SMS delivers messages through an HTTP SMS gateway that accepts form posts
+// with From, To and Body fields and answers with the ID it assigned to the
+// message. The external ID of an SMS contact is their phone number.
+type SMS struct {
+	URL    string
+	APIKey string
+	From   string
+	Client *http.Client
+}
+
+func (s *SMS) Channel() string {
+	return data.ChannelSMS
+}
+
+func (s *SMS) Send(ctx context.Context, sender *data.User, to string, message *data.Message) (string, error) {
+	form := url.Values{
+		"From": {s.From},
+		"To":   {to},
+		"Body": {message.Content},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+
+	return doProviderRequest(s.Client, req)
+}
+
+// doProviderRequest sends a request to a provider and returns the message ID
+// from a JSON response of the form {"id": "..."}. A missing ID is not an error.
+func doProviderRequest(client *http.Client, req *http.Request) (string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	// Providers are not required to return an ID
+	_ = json.Unmarshal(body, &response)
+
+	return response.ID, nil
+}
@@ -0,0 +1,113 @@
+package channels
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+func TestSMSSend(t *testing.T) {
+	var got *http.Request
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"SM123"}`))
+	}))
+	defer gateway.Close()
+
+	adapter := &SMS{
+		URL:    gateway.URL,
+		APIKey: "key",
+		From:   "+15550000000",
+		Client: gateway.Client(),
+	}
+
+	messageID, err := adapter.Send(context.Background(), &data.User{ID: 1}, "+15551234567", &data.Message{Content: "Hello there"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if messageID != "SM123" {
+		t.Errorf("got message ID %q; want %q", messageID, "SM123")
+	}
+
+	if got.Method != http.MethodPost {
+		t.Errorf("got method %s; want %s", got.Method, http.MethodPost)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer key" {
+		t.Errorf("got Authorization %q; want %q", auth, "Bearer key")
+	}
+
+	fields := map[string]string{
+		"From": "+15550000000",
+		"To":   "+15551234567",
+		"Body": "Hello there",
+	}
+	for field, want := range fields {
+		if value := got.PostForm.Get(field); value != want {
+			t.Errorf("got %s %q; want %q", field, value, want)
+		}
+	}
+}
+
+func TestSMSSendResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantID  string
+		wantErr bool
+	}{
+		{"accepted with ID", http.StatusCreated, `{"id":"SM1"}`, "SM1", false},
+		{"accepted without ID", http.StatusAccepted, ``, "", false},
+		{"accepted with other body", http.StatusOK, `queued`, "", false},
+		{"rejected", http.StatusBadRequest, `{"error":"invalid number"}`, "", true},
+		{"gateway failure", http.StatusServiceUnavailable, ``, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer gateway.Close()
+
+			adapter := &SMS{URL: gateway.URL, Client: gateway.Client()}
+
+			messageID, err := adapter.Send(context.Background(), &data.User{}, "+15551234567", &data.Message{Content: "Hi"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error %t", err, tt.wantErr)
+			}
+			if messageID != tt.wantID {
+				t.Errorf("got message ID %q; want %q", messageID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestSMSSendTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer gateway.Close()
+	defer close(release)
+
+	adapter := &SMS{
+		URL:    gateway.URL,
+		Client: &http.Client{Timeout: 100 * time.Millisecond},
+	}
+
+	_, err := adapter.Send(context.Background(), &data.User{}, "+15551234567", &data.Message{Content: "Hi"})
+	if err == nil {
+		t.Fatal("got no error from a gateway that never answers")
+	}
+}
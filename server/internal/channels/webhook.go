@@ -0,0 +1,55 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/webhooks"
+)
+
+// Webhook delivers messages for the generic channel by POSTing them as JSON to
+// a single URL, signed the same way as our outbound webhooks. The receiving
+// service forwards them to the external contact identified by "to".
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (w *Webhook) Channel() string {
+	return data.ChannelGeneric
+}
+
+func (w *Webhook) Send(ctx context.Context, sender *data.User, to string, message *data.Message) (string, error) {
+	payload := map[string]any{
+		"to": to,
+		"from": map[string]any{
+			"id":   sender.ID,
+			"name": sender.FullName,
+		},
+		"message": message,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Zoko-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Zoko-Signature", webhooks.Sign(w.Secret, timestamp, body))
+
+	return doProviderRequest(w.Client, req)
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// APIKeyPrefix starts every API key, which is how the authenticate middleware
+// tells them apart from user tokens
+const APIKeyPrefix = "zk_"
+
+// apiKeyLength is the length of an API key including its prefix
+const apiKeyLength = len(APIKeyPrefix) + 32
+
+// APIKey lets a backend integration call the API on behalf of an organization
+// with a fixed set of permissions. Only a hash of the key is stored, so the
+// plaintext is returned once when the key is created.
+type APIKey struct {
+	ID             int64      `json:"id"`
+	OrganizationID int64      `json:"organization_id"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	Plaintext      string     `json:"key,omitempty"`
+	Hash           []byte     `json:"-"`
+	Scopes         []string   `json:"scopes"`
+	RateLimitRPS   int        `json:"rate_limit_rps"`
+	RateLimitBurst int        `json:"rate_limit_burst"`
+	UsageCount     int64      `json:"usage_count"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	CreatedBy      *int64     `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// ValidateAPIKey checks the key's settings. Keys can only be given permissions
+// that their creator holds.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Check(validator.NotBlank(key.Name), "name", "Name is required")
+	v.Check(validator.MaxChars(key.Name, 100), "name", "Name must not be more than 100 characters")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "At least one scope is required")
+	v.Check(validator.Unique(key.Scopes), "scopes", "Must not contain duplicate scopes")
+	for _, scope := range key.Scopes {
+		v.Check(granted.Include(scope), "scopes", "Must only contain permissions you hold")
+	}
+
+	v.Check(key.RateLimitRPS > 0, "rate_limit_rps", "Must be greater than zero")
+	v.Check(key.RateLimitRPS <= 1000, "rate_limit_rps", "Must be a maximum of 1000")
+	v.Check(key.RateLimitBurst >= key.RateLimitRPS, "rate_limit_burst", "Must not be less than rate_limit_rps")
+	v.Check(key.RateLimitBurst <= 2000, "rate_limit_burst", "Must be a maximum of 2000")
+}
+
+// IsAPIKey reports whether a bearer token looks like an API key
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(IsAPIKey(plaintext), "key", "Key must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == apiKeyLength, "key", "Key has the wrong length")
+}
+
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	key.Prefix = key.Plaintext[:len(APIKeyPrefix)+8]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return nil
+}
+
+const apiKeyColumns = `id, organization_id, name, prefix, scopes, rate_limit_rps, rate_limit_burst, usage_count, last_used_at, created_by, created_at, revoked_at`
+
+func scanAPIKey(scanner interface{ Scan(...any) error }) (*APIKey, error) {
+	var key APIKey
+
+	err := scanner.Scan(
+		&key.ID,
+		&key.OrganizationID,
+		&key.Name,
+		&key.Prefix,
+		pgArray(&key.Scopes),
+		&key.RateLimitRPS,
+		&key.RateLimitBurst,
+		&key.UsageCount,
+		&key.LastUsedAt,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// Insert generates the key and stores its hash. key.Plaintext is only set on
+// the key passed in.
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO api_keys (organization_id, name, prefix, hash, scopes, rate_limit_rps, rate_limit_burst, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	args := []any{
+		key.OrganizationID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		key.Scopes,
+		key.RateLimitRPS,
+		key.RateLimitBurst,
+		key.CreatedBy,
+	}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForPlaintext returns the unrevoked key matching the plaintext
+func (m APIKeyModel) GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE hash = $1 AND revoked_at IS NULL`
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash[:]))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetAll returns the organization's keys, revoked ones included, newest first
+func (m APIKeyModel) GetAll(ctx context.Context, organizationID int64) ([]*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE organization_id = $1
+		ORDER BY id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke stops the key from authenticating. Keys that are already revoked are
+// reported as not found.
+func (m APIKeyModel) Revoke(ctx context.Context, organizationID, id int64) (*APIKey, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND organization_id = $2 AND revoked_at IS NULL
+		RETURNING ` + apiKeyColumns
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, id, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// RecordUsage counts a request made with the key
+func (m APIKeyModel) RecordUsage(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET usage_count = usage_count + 1, last_used_at = NOW()
+		WHERE id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
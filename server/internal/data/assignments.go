@@ -0,0 +1,499 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrOpenAssignmentExists = errors.New("customer already has an open assignment")
+
+// Statuses of a conversation with an inbox. Pending conversations are waiting
+// on the customer.
+const (
+	ConversationOpen     = "open"
+	ConversationPending  = "pending"
+	ConversationResolved = "resolved"
+)
+
+// What opening an assignment for a customer message did
+const (
+	AssignmentExisting = "existing"
+	AssignmentCreated  = "created"
+	AssignmentReopened = "reopened"
+)
+
+// MaxLabels caps how many labels a conversation can have
+const MaxLabels = 20
+
+var LabelRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// SLAs an assignment can breach
+const (
+	SLAFirstResponse = "first_response"
+	SLAResolution    = "resolution"
+)
+
+// Assignment is a customer conversation with an inbox and the agent handling
+// it. AgentID is nil while nobody is assigned.
+type Assignment struct {
+	ID                      int64      `json:"id"`
+	ConversationID          int64      `json:"conversation_id"`
+	InboxID                 int64      `json:"inbox_id"`
+	CustomerID              int64      `json:"customer_id"`
+	AgentID                 *int64     `json:"agent_id"`
+	Status                  string     `json:"status"`
+	Labels                  []string   `json:"labels"`
+	OpenedAt                time.Time  `json:"opened_at"`
+	FirstResponseAt         *time.Time `json:"first_response_at"`
+	ResolvedAt              *time.Time `json:"resolved_at"`
+	FirstResponseBreachedAt *time.Time `json:"first_response_breached_at,omitempty"`
+	ResolutionBreachedAt    *time.Time `json:"resolution_breached_at,omitempty"`
+}
+
+// SLABreach is an assignment that has just gone past one of its inbox's SLAs
+type SLABreach struct {
+	SLA        string      `json:"sla"`
+	Assignment *Assignment `json:"assignment"`
+}
+
+type AssignmentModel struct {
+	DB *sql.DB
+}
+
+func ValidateConversationStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, ConversationOpen, ConversationPending, ConversationResolved), "status", "Must be open, pending or resolved")
+}
+
+func ValidateLabels(v *validator.Validator, labels []string) {
+	v.Check(len(labels) <= MaxLabels, "labels", fmt.Sprintf("Must not contain more than %d labels", MaxLabels))
+	v.Check(validator.Unique(labels), "labels", "Must not contain duplicate labels")
+
+	for _, label := range labels {
+		v.Check(validator.Matches(label, LabelRX), "labels", "Labels must be up to 32 lowercase letters, digits, hyphens or underscores")
+	}
+}
+
+const assignmentColumns = `id, conversation_id, inbox_id, customer_id, agent_id, status, labels, opened_at, first_response_at, resolved_at, first_response_breached_at, resolution_breached_at`
+
+func scanAssignment(scanner interface{ Scan(...any) error }) (*Assignment, error) {
+	var a Assignment
+
+	err := scanner.Scan(
+		&a.ID,
+		&a.ConversationID,
+		&a.InboxID,
+		&a.CustomerID,
+		&a.AgentID,
+		&a.Status,
+		pgArray(&a.Labels),
+		&a.OpenedAt,
+		&a.FirstResponseAt,
+		&a.ResolvedAt,
+		&a.FirstResponseBreachedAt,
+		&a.ResolutionBreachedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// Open makes sure the customer has an open assignment with the inbox when they
+// message it. The customer's latest conversation is reopened if it was
+// resolved or waiting on them, and otherwise a new one is created and assigned
+// to an agent with the inbox's strategy. It returns the assignment and which
+// of those happened.
+func (m AssignmentModel) Open(ctx context.Context, conversationID, inboxID, customerID int64, openedAt time.Time) (*Assignment, string, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	// Locking the inbox serialises assignments, so that round robin and load
+	// counts see every earlier assignment
+	query := `
+		SELECT strategy, last_assigned_agent_id
+		FROM inboxes
+		WHERE user_id = $1
+		FOR UPDATE`
+
+	var strategy string
+	var lastAgentID *int64
+
+	err = tx.QueryRowContext(ctx, query, inboxID).Scan(&strategy, &lastAgentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", err
+		}
+	}
+
+	query = `
+		SELECT ` + assignmentColumns + `
+		FROM assignments
+		WHERE inbox_id = $1 AND customer_id = $2
+		ORDER BY resolved_at IS NULL DESC, opened_at DESC
+		LIMIT 1`
+
+	assignment, err := scanAssignment(tx.QueryRowContext(ctx, query, inboxID, customerID))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", err
+	}
+
+	switch {
+	case assignment != nil && assignment.Status == ConversationOpen:
+		return assignment, AssignmentExisting, nil
+
+	case assignment != nil && assignment.Status == ConversationPending:
+		query = `
+			UPDATE assignments
+			SET status = 'open'
+			WHERE id = $1
+			RETURNING ` + assignmentColumns
+
+		assignment, err = scanAssignment(tx.QueryRowContext(ctx, query, assignment.ID))
+		if err != nil {
+			return nil, "", err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, "", err
+		}
+
+		return assignment, AssignmentExisting, nil
+	}
+
+	// A reopened conversation stays with its agent while they are still part
+	// of the inbox
+	var agentID *int64
+
+	if assignment != nil && assignment.AgentID != nil {
+		query = `
+			SELECT agent_id
+			FROM inbox_agents
+			WHERE inbox_id = $1 AND agent_id = $2`
+
+		err = tx.QueryRowContext(ctx, query, inboxID, *assignment.AgentID).Scan(&agentID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, "", err
+		}
+	}
+
+	assignedNew := agentID == nil
+
+	if assignedNew {
+		agentID, err = pickAgent(ctx, tx, inboxID, strategy, lastAgentID)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	result := AssignmentCreated
+
+	if assignment != nil {
+		// Reopening starts the conversation's SLAs over
+		query = `
+			UPDATE assignments
+			SET status = 'open', agent_id = $1, opened_at = $2, resolved_at = NULL,
+				first_response_at = NULL, first_response_breached_at = NULL, resolution_breached_at = NULL
+			WHERE id = $3
+			RETURNING ` + assignmentColumns
+
+		assignment, err = scanAssignment(tx.QueryRowContext(ctx, query, agentID, openedAt, assignment.ID))
+		result = AssignmentReopened
+	} else {
+		query = `
+			INSERT INTO assignments (conversation_id, inbox_id, customer_id, agent_id, opened_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING ` + assignmentColumns
+
+		assignment, err = scanAssignment(tx.QueryRowContext(ctx, query, conversationID, inboxID, customerID, agentID, openedAt))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if assignedNew && agentID != nil {
+		query = `
+			UPDATE inboxes
+			SET last_assigned_agent_id = $1
+			WHERE user_id = $2`
+
+		_, err = tx.ExecContext(ctx, query, *agentID, inboxID)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assignment, result, nil
+}
+
+// pickAgent chooses the agent for a conversation with the inbox's strategy. It
+// returns nil when the inbox has no agents, in which case the conversation
+// waits to be assigned by hand.
+func pickAgent(ctx context.Context, tx *sql.Tx, inboxID int64, strategy string, lastAgentID *int64) (*int64, error) {
+	var query string
+	var args []any
+
+	switch strategy {
+	case AssignLeastLoaded:
+		query = `
+			SELECT a.agent_id
+			FROM inbox_agents a
+			LEFT JOIN assignments s ON s.inbox_id = a.inbox_id AND s.agent_id = a.agent_id AND s.resolved_at IS NULL
+			WHERE a.inbox_id = $1
+			GROUP BY a.agent_id
+			ORDER BY COUNT(s.id), a.agent_id
+			LIMIT 1`
+		args = []any{inboxID}
+	default:
+		// The next agent after the last one assigned, wrapping around
+		query = `
+			SELECT agent_id
+			FROM inbox_agents
+			WHERE inbox_id = $1
+			ORDER BY agent_id <= COALESCE($2, 0), agent_id
+			LIMIT 1`
+		args = []any{inboxID, lastAgentID}
+	}
+
+	var agentID *int64
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&agentID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return agentID, nil
+}
+
+// RecordFirstResponse stamps the first reply from the inbox on the customer's
+// open assignment, if it has not been answered yet
+func (m AssignmentModel) RecordFirstResponse(ctx context.Context, inboxID, customerID int64, at time.Time) error {
+	query := `
+		UPDATE assignments
+		SET first_response_at = $1
+		WHERE inbox_id = $2 AND customer_id = $3
+		AND resolved_at IS NULL AND first_response_at IS NULL`
+
+	_, err := m.DB.ExecContext(ctx, query, at, inboxID, customerID)
+	return err
+}
+
+func (m AssignmentModel) Get(ctx context.Context, organizationID, id, inboxID int64) (*Assignment, error) {
+	query := `
+		SELECT ` + assignmentColumns + `
+		FROM assignments
+		WHERE id = $1 AND inbox_id = $2
+		AND inbox_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	assignment, err := scanAssignment(m.DB.QueryRowContext(ctx, query, id, inboxID, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return assignment, nil
+}
+
+// GetAllForInbox lists an inbox's assignments, newest first. An empty status
+// or label and an agentID of 0 match any.
+func (m AssignmentModel) GetAllForInbox(ctx context.Context, organizationID, inboxID int64, status, label string, agentID int64, filters Filters) ([]*Assignment, Metadata, error) {
+	query := `
+		SELECT ` + assignmentColumns + `
+		FROM assignments
+		WHERE inbox_id = $1
+		AND inbox_id IN (SELECT id FROM users WHERE organization_id = $7)
+		AND ($2 = '' OR status = $2)
+		AND ($3 = '' OR labels @> ARRAY[$3]::text[])
+		AND ($4 = 0 OR agent_id = $4)
+		AND opened_at < $5
+		ORDER BY opened_at DESC
+		LIMIT $6`
+
+	rows, err := m.DB.QueryContext(ctx, query, inboxID, status, label, agentID, filters.Cursor, filters.PageSize, organizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	assignments := []*Assignment{}
+
+	for rows.Next() {
+		assignment, err := scanAssignment(rows)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		assignments = append(assignments, assignment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var nextCursor time.Time
+
+	if len(assignments) > 0 {
+		nextCursor = assignments[len(assignments)-1].OpenedAt.UTC()
+	}
+
+	metadata := calculateMetadata(filters.Cursor, nextCursor, len(assignments), filters.PageSize)
+
+	return assignments, metadata, nil
+}
+
+// Transfer hands an open assignment to another agent of the inbox
+func (m AssignmentModel) Transfer(ctx context.Context, organizationID, id, inboxID, agentID int64) (*Assignment, error) {
+	query := `
+		UPDATE assignments
+		SET agent_id = $1
+		WHERE id = $2 AND inbox_id = $3 AND resolved_at IS NULL
+		AND inbox_id IN (SELECT id FROM users WHERE organization_id = $4)
+		RETURNING ` + assignmentColumns
+
+	assignment, err := scanAssignment(m.DB.QueryRowContext(ctx, query, agentID, id, inboxID, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return assignment, nil
+}
+
+// SetStatus changes the status of an assignment. Resolving it again keeps the
+// original time. Reopening fails with ErrOpenAssignmentExists when the
+// customer has since started another conversation with the inbox.
+func (m AssignmentModel) SetStatus(ctx context.Context, organizationID, id, inboxID int64, status string) (*Assignment, error) {
+	query := `
+		UPDATE assignments
+		SET status = $1,
+			resolved_at = CASE WHEN $1 = 'resolved' THEN COALESCE(resolved_at, NOW()) END
+		WHERE id = $2 AND inbox_id = $3
+		AND inbox_id IN (SELECT id FROM users WHERE organization_id = $4)
+		AND ($1 = 'resolved' OR NOT EXISTS (
+			SELECT 1 FROM assignments s
+			WHERE s.inbox_id = $3 AND s.customer_id = assignments.customer_id
+			AND s.resolved_at IS NULL AND s.id <> $2
+		))
+		RETURNING ` + assignmentColumns
+
+	assignment, err := scanAssignment(m.DB.QueryRowContext(ctx, query, status, id, inboxID, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrOpenAssignmentExists
+		default:
+			return nil, err
+		}
+	}
+
+	return assignment, nil
+}
+
+// SetLabels replaces the labels of an assignment
+func (m AssignmentModel) SetLabels(ctx context.Context, organizationID, id, inboxID int64, labels []string) (*Assignment, error) {
+	query := `
+		UPDATE assignments
+		SET labels = $1
+		WHERE id = $2 AND inbox_id = $3
+		AND inbox_id IN (SELECT id FROM users WHERE organization_id = $4)
+		RETURNING ` + assignmentColumns
+
+	assignment, err := scanAssignment(m.DB.QueryRowContext(ctx, query, labels, id, inboxID, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return assignment, nil
+}
+
+// MarkBreached records up to limit assignments per SLA that have gone past
+// their inbox's threshold without a first response or resolution, and returns
+// them. Each breach is only returned once.
+func (m AssignmentModel) MarkBreached(ctx context.Context, limit int) ([]*SLABreach, error) {
+	queries := map[string]string{
+		SLAFirstResponse: `
+			UPDATE assignments
+			SET first_response_breached_at = NOW()
+			WHERE id IN (
+				SELECT s.id
+				FROM assignments s
+				INNER JOIN inboxes i ON i.user_id = s.inbox_id
+				WHERE s.first_response_breached_at IS NULL
+				AND i.first_response_sla > 0
+				AND COALESCE(s.first_response_at, s.resolved_at, NOW()) > s.opened_at + make_interval(secs => i.first_response_sla)
+				LIMIT $1
+				FOR UPDATE OF s SKIP LOCKED
+			)
+			RETURNING ` + assignmentColumns,
+		SLAResolution: `
+			UPDATE assignments
+			SET resolution_breached_at = NOW()
+			WHERE id IN (
+				SELECT s.id
+				FROM assignments s
+				INNER JOIN inboxes i ON i.user_id = s.inbox_id
+				WHERE s.resolution_breached_at IS NULL
+				AND i.resolution_sla > 0
+				AND COALESCE(s.resolved_at, NOW()) > s.opened_at + make_interval(secs => i.resolution_sla)
+				LIMIT $1
+				FOR UPDATE OF s SKIP LOCKED
+			)
+			RETURNING ` + assignmentColumns,
+	}
+
+	breaches := []*SLABreach{}
+
+	for _, sla := range []string{SLAFirstResponse, SLAResolution} {
+		rows, err := m.DB.QueryContext(ctx, queries[sla], limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			assignment, err := scanAssignment(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			breaches = append(breaches, &SLABreach{SLA: sla, Assignment: assignment})
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return breaches, nil
+}
@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// Audited actions
+const (
+	AuditTokenCreated    = "token.created"
+	AuditAPIKeyCreated   = "api_key.created"
+	AuditAPIKeyRevoked   = "api_key.revoked"
+	AuditAPIKeyUsed      = "api_key.used"
+	AuditRoleChanged     = "member.role_changed"
+	AuditMessageDeleted  = "message.deleted"
+	AuditMessageReleased = "message.released"
+	AuditUserSuspended   = "user.suspended"
+	AuditUserReinstated  = "user.reinstated"
+	AuditUserDeleted     = "user.deleted"
+	AuditDLQReplayed     = "dlq.replayed"
+)
+
+var AuditActions = []string{
+	AuditTokenCreated,
+	AuditAPIKeyCreated,
+	AuditAPIKeyRevoked,
+	AuditAPIKeyUsed,
+	AuditRoleChanged,
+	AuditMessageDeleted,
+	AuditMessageReleased,
+	AuditUserSuspended,
+	AuditUserReinstated,
+	AuditUserDeleted,
+	AuditDLQReplayed,
+}
+
+// Who performed an audited action
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+)
+
+// Kinds of records that audited actions target
+const (
+	TargetUser     = "user"
+	TargetAPIKey   = "api_key"
+	TargetMessage  = "message"
+	TargetDLQEntry = "dlq_entry"
+)
+
+// AuditEvent records who did what to which record. Before and After hold the
+// record's state on either side of the action, where it applies.
+type AuditEvent struct {
+	ID             int64           `json:"id"`
+	EventID        string          `json:"-"`
+	OrganizationID int64           `json:"organization_id"`
+	Action         string          `json:"action"`
+	ActorType      string          `json:"actor_type"`
+	ActorID        int64           `json:"actor_id"`
+	TargetType     string          `json:"target_type"`
+	TargetID       string          `json:"target_id"`
+	IP             string          `json:"ip"`
+	RequestID      string          `json:"request_id"`
+	Before         json.RawMessage `json:"before,omitempty"`
+	After          json.RawMessage `json:"after,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows down audit events. Empty fields match everything.
+type AuditFilter struct {
+	Action     string
+	ActorType  string
+	ActorID    int64
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+}
+
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+func ValidateAuditFilter(v *validator.Validator, filter AuditFilter) {
+	if filter.Action != "" {
+		v.Check(validator.PermittedValue(filter.Action, AuditActions...), "action", "Must be a known audit action")
+	}
+	if filter.ActorType != "" {
+		v.Check(validator.PermittedValue(filter.ActorType, ActorUser, ActorAPIKey), "actor_type", "Must be one of user or api_key")
+	}
+	v.Check(filter.ActorID >= 0, "actor_id", "Must be a positive integer")
+	v.Check(filter.Until.IsZero() || filter.Since.Before(filter.Until), "until", "Must be after since")
+}
+
+// jsonValue converts a snapshot for a nullable jsonb column
+func jsonValue(raw json.RawMessage) []byte {
+	if len(raw) == 0 {
+		return nil
+	}
+	return []byte(raw)
+}
+
+// BulkInsert stores a batch of events. Events that were already stored are
+// skipped, so a batch can safely be written again after a failure.
+func (m AuditEventModel) BulkInsert(ctx context.Context, events []*AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO audit_events (event_id, organization_id, action, actor_type, actor_id, target_type, target_id, ip, request_id, before, after, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (event_id) DO NOTHING`
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		args := []any{
+			event.EventID,
+			event.OrganizationID,
+			event.Action,
+			event.ActorType,
+			event.ActorID,
+			event.TargetType,
+			event.TargetID,
+			event.IP,
+			event.RequestID,
+			jsonValue(event.Before),
+			jsonValue(event.After),
+			event.CreatedAt,
+		}
+
+		_, err = stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const auditEventColumns = `id, event_id, organization_id, action, actor_type, actor_id, target_type, target_id, ip, request_id, before, after, created_at`
+
+func scanAuditEvent(scanner interface{ Scan(...any) error }) (*AuditEvent, error) {
+	var event AuditEvent
+	var before, after []byte
+
+	err := scanner.Scan(
+		&event.ID,
+		&event.EventID,
+		&event.OrganizationID,
+		&event.Action,
+		&event.ActorType,
+		&event.ActorID,
+		&event.TargetType,
+		&event.TargetID,
+		&event.IP,
+		&event.RequestID,
+		&before,
+		&after,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Before = before
+	event.After = after
+
+	return &event, nil
+}
+
+// auditFilterClause matches the filter's fields, which are passed as the
+// arguments after the organization
+const auditFilterClause = `
+		WHERE organization_id = $1
+		AND (action = $2 OR $2 = '')
+		AND (actor_type = $3 OR $3 = '')
+		AND (actor_id = $4 OR $4 = 0)
+		AND (target_type = $5 OR $5 = '')
+		AND (target_id = $6 OR $6 = '')
+		AND created_at >= $7
+		AND created_at < $8`
+
+func auditFilterArgs(organizationID int64, filter AuditFilter) []any {
+	until := filter.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	return []any{
+		organizationID,
+		filter.Action,
+		filter.ActorType,
+		filter.ActorID,
+		filter.TargetType,
+		filter.TargetID,
+		filter.Since,
+		until,
+	}
+}
+
+// GetAll returns a page of the organization's audit events, newest first
+func (m AuditEventModel) GetAll(ctx context.Context, organizationID int64, filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := `
+		SELECT ` + auditEventColumns + `
+		FROM audit_events` + auditFilterClause + `
+		AND created_at < $9
+		ORDER BY created_at DESC, id DESC
+		LIMIT $10`
+
+	args := append(auditFilterArgs(organizationID, filter), filters.Cursor, filters.PageSize)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+	}
+
+	var nextCursor time.Time
+
+	if len(events) > 0 {
+		nextCursor = events[len(events)-1].CreatedAt.UTC()
+	}
+
+	metadata := calculateMetadata(filters.Cursor, nextCursor, len(events), filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// Export calls fn with every audit event of the organization that matches the
+// filter, oldest first, as they are read from the database. It stops at the
+// first error fn returns.
+func (m AuditEventModel) Export(ctx context.Context, organizationID int64, filter AuditFilter, fn func(*AuditEvent) error) error {
+	query := `
+		SELECT ` + auditEventColumns + `
+		FROM audit_events` + auditFilterClause + `
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, auditFilterArgs(organizationID, filter)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,328 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+const (
+	MatchTypeKeyword = "keyword"
+	MatchTypeRegex   = "regex"
+)
+
+// Conditions on the rule owner's business hours under which a rule applies
+const (
+	HoursAny     = "any"
+	HoursWithin  = "within"
+	HoursOutside = "outside"
+)
+
+// AutoReplyPlaceholders are the placeholders an auto reply may use
+var AutoReplyPlaceholders = []string{"sender_name"}
+
+// AutoReplyRule answers incoming messages that match it with an automatic
+// reply. When several of a user's rules match, the one with the highest
+// priority wins.
+type AutoReplyRule struct {
+	ID             int64          `json:"id"`
+	UserID         int64          `json:"user_id"`
+	Name           string         `json:"name"`
+	MatchType      string         `json:"match_type"`
+	Keywords       []string       `json:"keywords"`
+	Pattern        string         `json:"pattern"`
+	HoursCondition string         `json:"hours_condition"`
+	BusinessHours  *BusinessHours `json:"business_hours,omitempty"`
+	Reply          string         `json:"reply"`
+	Priority       int            `json:"priority"`
+	Active         bool           `json:"active"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// BusinessHours is a weekly opening window in a time zone. Days are numbered
+// from Sunday (0) to Saturday (6). An end before the start means the window
+// runs past midnight.
+type BusinessHours struct {
+	Timezone string `json:"timezone"`
+	Days     []int  `json:"days"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+type AutoReplyRuleModel struct {
+	DB *sql.DB
+}
+
+func ValidateAutoReplyRule(v *validator.Validator, rule *AutoReplyRule) {
+	v.Check(validator.NotBlank(rule.Name), "name", "Name is required")
+	v.Check(validator.MaxChars(rule.Name, 100), "name", "Must not be more than 100 characters long")
+
+	v.Check(validator.PermittedValue(rule.MatchType, MatchTypeKeyword, MatchTypeRegex), "match_type", "Must be keyword or regex")
+
+	switch rule.MatchType {
+	case MatchTypeKeyword:
+		v.Check(len(rule.Keywords) > 0, "keywords", "At least one keyword is required")
+		v.Check(len(rule.Keywords) <= 20, "keywords", "Must not contain more than 20 keywords")
+		v.Check(validator.Unique(rule.Keywords), "keywords", "Must not contain duplicate keywords")
+		for _, keyword := range rule.Keywords {
+			v.Check(validator.NotBlank(keyword), "keywords", "Keywords must not be blank")
+			v.Check(validator.MaxChars(keyword, 50), "keywords", "Keywords must not be more than 50 characters long")
+		}
+		v.Check(rule.Pattern == "", "pattern", "Must be empty for keyword rules")
+	case MatchTypeRegex:
+		v.Check(rule.Pattern != "", "pattern", "Pattern is required")
+		v.Check(validator.MaxChars(rule.Pattern, 200), "pattern", "Must not be more than 200 characters long")
+		_, err := regexp.Compile(rule.Pattern)
+		v.Check(err == nil, "pattern", "Must be a valid regular expression")
+		v.Check(len(rule.Keywords) == 0, "keywords", "Must be empty for regex rules")
+	}
+
+	v.Check(validator.PermittedValue(rule.HoursCondition, HoursAny, HoursWithin, HoursOutside), "hours_condition", "Must be any, within or outside")
+	if rule.HoursCondition != HoursAny {
+		v.Check(rule.BusinessHours != nil, "business_hours", "Business hours are required unless hours_condition is any")
+	}
+	if rule.BusinessHours != nil {
+		ValidateBusinessHours(v, rule.BusinessHours)
+	}
+
+	v.Check(validator.NotBlank(rule.Reply), "reply", "Reply is required")
+	v.Check(len(rule.Reply) <= 1000, "reply", "Reply must be less than 1000 characters")
+	validatePlaceholderSyntax(v, "reply", rule.Reply)
+	for _, name := range (&MessageTemplate{Body: rule.Reply}).Placeholders() {
+		v.Check(validator.PermittedValue(name, AutoReplyPlaceholders...), "reply", "The only placeholder available is {{sender_name}}")
+	}
+
+	v.Check(rule.Priority >= 0 && rule.Priority <= 1000, "priority", "Must be between 0 and 1000")
+}
+
+func ValidateBusinessHours(v *validator.Validator, hours *BusinessHours) {
+	_, err := time.LoadLocation(hours.Timezone)
+	v.Check(hours.Timezone != "" && err == nil, "business_hours.timezone", "Must be a valid IANA time zone")
+
+	v.Check(len(hours.Days) > 0, "business_hours.days", "At least one day is required")
+	v.Check(validator.Unique(hours.Days), "business_hours.days", "Must not contain duplicate days")
+	for _, day := range hours.Days {
+		v.Check(day >= 0 && day <= 6, "business_hours.days", "Days must be between 0 (Sunday) and 6 (Saturday)")
+	}
+
+	_, startErr := time.Parse("15:04", hours.Start)
+	v.Check(startErr == nil, "business_hours.start", "Must be a time of day formatted as HH:MM")
+	_, endErr := time.Parse("15:04", hours.End)
+	v.Check(endErr == nil, "business_hours.end", "Must be a time of day formatted as HH:MM")
+	v.Check(hours.Start != hours.End, "business_hours.end", "Must be different from the start")
+}
+
+// businessHoursValue converts business hours for a jsonb column
+func businessHoursValue(hours *BusinessHours) ([]byte, error) {
+	if hours == nil {
+		return nil, nil
+	}
+	return json.Marshal(hours)
+}
+
+func (m AutoReplyRuleModel) Insert(ctx context.Context, rule *AutoReplyRule) error {
+	hours, err := businessHoursValue(rule.BusinessHours)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO auto_reply_rules (user_id, name, match_type, keywords, pattern, hours_condition, business_hours, reply, priority, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`
+
+	args := []any{
+		rule.UserID,
+		rule.Name,
+		rule.MatchType,
+		rule.Keywords,
+		rule.Pattern,
+		rule.HoursCondition,
+		hours,
+		rule.Reply,
+		rule.Priority,
+		rule.Active,
+	}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+const selectAutoReplyRuleColumns = `
+	SELECT id, user_id, name, match_type, keywords, pattern, hours_condition, business_hours, reply, priority, active, created_at
+	FROM auto_reply_rules`
+
+func scanAutoReplyRule(scanner interface{ Scan(...any) error }) (*AutoReplyRule, error) {
+	var rule AutoReplyRule
+	var hours []byte
+
+	err := scanner.Scan(
+		&rule.ID,
+		&rule.UserID,
+		&rule.Name,
+		&rule.MatchType,
+		pgArray(&rule.Keywords),
+		&rule.Pattern,
+		&rule.HoursCondition,
+		&hours,
+		&rule.Reply,
+		&rule.Priority,
+		&rule.Active,
+		&rule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if hours != nil {
+		rule.BusinessHours = &BusinessHours{}
+		err = json.Unmarshal(hours, rule.BusinessHours)
+		if err != nil {
+			return nil, fmt.Errorf("decoding business hours of rule %d: %w", rule.ID, err)
+		}
+	}
+
+	return &rule, nil
+}
+
+func (m AutoReplyRuleModel) Get(ctx context.Context, organizationID, id, userID int64) (*AutoReplyRule, error) {
+	query := selectAutoReplyRuleColumns + `
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	rule, err := scanAutoReplyRule(m.DB.QueryRowContext(ctx, query, id, userID, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return rule, nil
+}
+
+func (m AutoReplyRuleModel) GetAllForUser(ctx context.Context, organizationID, userID int64) ([]*AutoReplyRule, error) {
+	query := selectAutoReplyRuleColumns + `
+		WHERE user_id = $1
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $2)
+		ORDER BY priority DESC, id`
+
+	return m.getAll(ctx, query, userID, organizationID)
+}
+
+// GetActiveForUsers returns the active rules of each of the given users,
+// highest priority first
+func (m AutoReplyRuleModel) GetActiveForUsers(ctx context.Context, userIDs []int64) (map[int64][]*AutoReplyRule, error) {
+	query := selectAutoReplyRuleColumns + `
+		WHERE user_id = ANY($1) AND active
+		ORDER BY priority DESC, id`
+
+	rules, err := m.getAll(ctx, query, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[int64][]*AutoReplyRule)
+	for _, rule := range rules {
+		byUser[rule.UserID] = append(byUser[rule.UserID], rule)
+	}
+
+	return byUser, nil
+}
+
+func (m AutoReplyRuleModel) getAll(ctx context.Context, query string, args ...any) ([]*AutoReplyRule, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*AutoReplyRule{}
+
+	for rows.Next() {
+		rule, err := scanAutoReplyRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (m AutoReplyRuleModel) Update(ctx context.Context, organizationID int64, rule *AutoReplyRule) error {
+	hours, err := businessHoursValue(rule.BusinessHours)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE auto_reply_rules
+		SET name = $1, match_type = $2, keywords = $3, pattern = $4, hours_condition = $5,
+			business_hours = $6, reply = $7, priority = $8, active = $9
+		WHERE id = $10 AND user_id = $11
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $12)`
+
+	args := []any{
+		rule.Name,
+		rule.MatchType,
+		rule.Keywords,
+		rule.Pattern,
+		rule.HoursCondition,
+		hours,
+		rule.Reply,
+		rule.Priority,
+		rule.Active,
+		rule.ID,
+		rule.UserID,
+		organizationID,
+	}
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m AutoReplyRuleModel) Delete(ctx context.Context, organizationID, id, userID int64) error {
+	query := `
+		DELETE FROM auto_reply_rules
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Block struct {
+	BlockerID int64     `json:"blocker_id"`
+	BlockedID int64     `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BlockModel struct {
+	DB *sql.DB
+}
+
+// Insert blocks blockedID on behalf of blockerID. Blocking a user twice is not
+// an error.
+func (m BlockModel) Insert(ctx context.Context, block *Block) error {
+	query := `
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT (blocker_id, blocked_id) DO UPDATE SET blocker_id = EXCLUDED.blocker_id
+		RETURNING created_at`
+
+	return m.DB.QueryRowContext(ctx, query, block.BlockerID, block.BlockedID).Scan(&block.CreatedAt)
+}
+
+func (m BlockModel) Delete(ctx context.Context, organizationID, blockerID, blockedID int64) error {
+	query := `
+		DELETE FROM user_blocks
+		WHERE blocker_id = $1 AND blocked_id = $2
+		AND blocker_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	res, err := m.DB.ExecContext(ctx, query, blockerID, blockedID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m BlockModel) GetAllForBlocker(ctx context.Context, organizationID, blockerID int64) ([]*Block, error) {
+	query := `
+		SELECT blocker_id, blocked_id, created_at
+		FROM user_blocks
+		WHERE blocker_id = $1
+		AND blocker_id IN (SELECT id FROM users WHERE organization_id = $2)
+		ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, blockerID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []*Block{}
+
+	for rows.Next() {
+		var block Block
+		err := rows.Scan(&block.BlockerID, &block.BlockedID, &block.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, &block)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// GetBlockers returns which of userIDs have blocked blockedID
+func (m BlockModel) GetBlockers(ctx context.Context, blockedID int64, userIDs []int64) (map[int64]bool, error) {
+	query := `
+		SELECT blocker_id
+		FROM user_blocks
+		WHERE blocked_id = $1 AND blocker_id = ANY($2)`
+
+	rows, err := m.DB.QueryContext(ctx, query, blockedID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blockers := make(map[int64]bool)
+
+	for rows.Next() {
+		var blockerID int64
+		err := rows.Scan(&blockerID)
+		if err != nil {
+			return nil, err
+		}
+		blockers[blockerID] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return blockers, nil
+}
+
+// Between reports whether userID has blocked otherID and whether otherID has
+// blocked userID
+func (m BlockModel) Between(ctx context.Context, userID, otherID int64) (blocked bool, blockedBy bool, err error) {
+	query := `
+		SELECT
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2),
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $2 AND blocked_id = $1)`
+
+	err = m.DB.QueryRowContext(ctx, query, userID, otherID).Scan(&blocked, &blockedBy)
+	return blocked, blockedBy, err
+}
@@ -0,0 +1,384 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrDuplicateBroadcastList = errors.New("duplicate broadcast list")
+
+// MaxBroadcastRecipients caps how many receivers a single broadcast or saved
+// broadcast list may have
+const MaxBroadcastRecipients = 256
+
+// Statuses of a broadcast recipient that are recorded when the broadcast is
+// created. Once the recipient's message is stored its status follows the
+// message instead: sent, then delivered or failed for external contacts, and
+// read. Messages quarantined by the spam filters are held.
+const (
+	BroadcastStatusQueued  = "queued"
+	BroadcastStatusSkipped = "skipped"
+	BroadcastStatusFailed  = "failed"
+	BroadcastStatusHeld    = "held"
+)
+
+// BroadcastList is a saved set of receivers that broadcasts can be sent to
+type BroadcastList struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Name        string    `json:"name"`
+	ReceiverIDs []int64   `json:"receiver_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Broadcast is one message fanned out as individual messages to many
+// receivers
+type Broadcast struct {
+	ID         int64                 `json:"id"`
+	SenderID   int64                 `json:"sender_id"`
+	ListID     *int64                `json:"list_id,omitempty"`
+	Content    string                `json:"content"`
+	Recipients []*BroadcastRecipient `json:"recipients,omitempty"`
+	CreatedAt  time.Time             `json:"created_at"`
+}
+
+type BroadcastRecipient struct {
+	ReceiverID int64  `json:"receiver_id"`
+	Status     string `json:"status"`
+	MessageID  *int64 `json:"message_id,omitempty"`
+}
+
+type BroadcastListModel struct {
+	DB *sql.DB
+}
+
+type BroadcastModel struct {
+	DB *sql.DB
+}
+
+func ValidateBroadcastRecipients(v *validator.Validator, senderID int64, receiverIDs []int64) {
+	v.Check(len(receiverIDs) > 0, "receiver_ids", "At least one receiver is required")
+	v.Check(len(receiverIDs) <= MaxBroadcastRecipients, "receiver_ids", fmt.Sprintf("Must not contain more than %d receivers", MaxBroadcastRecipients))
+	v.Check(validator.Unique(receiverIDs), "receiver_ids", "Must not contain duplicate receivers")
+
+	for _, id := range receiverIDs {
+		v.Check(id > 0, "receiver_ids", "Receiver IDs must be positive integers")
+		v.Check(id != senderID, "receiver_ids", "Must not contain the sender")
+	}
+}
+
+func ValidateBroadcastList(v *validator.Validator, list *BroadcastList) {
+	v.Check(validator.NotBlank(list.Name), "name", "Name is required")
+	v.Check(validator.MaxChars(list.Name, 100), "name", "Must not be more than 100 characters long")
+
+	ValidateBroadcastRecipients(v, list.UserID, list.ReceiverIDs)
+}
+
+func (m BroadcastListModel) Insert(ctx context.Context, list *BroadcastList) error {
+	query := `
+		INSERT INTO broadcast_lists (user_id, name, receiver_ids)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, name) DO NOTHING
+		RETURNING id, created_at`
+
+	args := []any{list.UserID, list.Name, list.ReceiverIDs}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateBroadcastList
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m BroadcastListModel) Get(ctx context.Context, organizationID, id, userID int64) (*BroadcastList, error) {
+	query := `
+		SELECT id, user_id, name, receiver_ids, created_at
+		FROM broadcast_lists
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	var list BroadcastList
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID, organizationID).Scan(
+		&list.ID,
+		&list.UserID,
+		&list.Name,
+		pgArray(&list.ReceiverIDs),
+		&list.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+func (m BroadcastListModel) GetAllForUser(ctx context.Context, organizationID, userID int64) ([]*BroadcastList, error) {
+	query := `
+		SELECT id, user_id, name, receiver_ids, created_at
+		FROM broadcast_lists
+		WHERE user_id = $1
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $2)
+		ORDER BY name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*BroadcastList{}
+
+	for rows.Next() {
+		var list BroadcastList
+		err := rows.Scan(&list.ID, &list.UserID, &list.Name, pgArray(&list.ReceiverIDs), &list.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (m BroadcastListModel) Update(ctx context.Context, organizationID int64, list *BroadcastList) error {
+	query := `
+		UPDATE broadcast_lists
+		SET name = $1, receiver_ids = $2
+		WHERE id = $3 AND user_id = $4
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $5)
+		AND NOT EXISTS (
+			SELECT 1 FROM broadcast_lists
+			WHERE user_id = $4 AND name = $1 AND id <> $3
+		)`
+
+	args := []any{list.Name, list.ReceiverIDs, list.ID, list.UserID, organizationID}
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// The list was already loaded by the caller, so no rows means the new name
+	// is taken
+	if rowsAffected == 0 {
+		return ErrDuplicateBroadcastList
+	}
+	return nil
+}
+
+func (m BroadcastListModel) Delete(ctx context.Context, organizationID, id, userID int64) error {
+	query := `
+		DELETE FROM broadcast_lists
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Insert stores the broadcast together with its recipients and their initial
+// statuses
+func (m BroadcastModel) Insert(ctx context.Context, broadcast *Broadcast) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO broadcasts (sender_id, list_id, content)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, broadcast.SenderID, broadcast.ListID, broadcast.Content).Scan(&broadcast.ID, &broadcast.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	receiverIDs := make([]int64, len(broadcast.Recipients))
+	statuses := make([]string, len(broadcast.Recipients))
+	for i, recipient := range broadcast.Recipients {
+		receiverIDs[i] = recipient.ReceiverID
+		statuses[i] = recipient.Status
+	}
+
+	query = `
+		INSERT INTO broadcast_recipients (broadcast_id, receiver_id, status)
+		SELECT $1, receiver_id, status
+		FROM UNNEST($2::bigint[], $3::text[]) AS r (receiver_id, status)`
+
+	_, err = tx.ExecContext(ctx, query, broadcast.ID, receiverIDs, statuses)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRecipientStatus overrides the recorded status of one recipient
+func (m BroadcastModel) SetRecipientStatus(ctx context.Context, broadcastID, receiverID int64, status string) error {
+	query := `
+		UPDATE broadcast_recipients
+		SET status = $1
+		WHERE broadcast_id = $2 AND receiver_id = $3`
+
+	res, err := m.DB.ExecContext(ctx, query, status, broadcastID, receiverID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Get returns a broadcast with the current status of each recipient
+func (m BroadcastModel) Get(ctx context.Context, organizationID, id, senderID int64) (*Broadcast, error) {
+	query := `
+		SELECT id, sender_id, list_id, content, created_at
+		FROM broadcasts
+		WHERE id = $1 AND sender_id = $2
+		AND sender_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	var broadcast Broadcast
+
+	err := m.DB.QueryRowContext(ctx, query, id, senderID, organizationID).Scan(
+		&broadcast.ID,
+		&broadcast.SenderID,
+		&broadcast.ListID,
+		&broadcast.Content,
+		&broadcast.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	// A recipient's message is matched through the broadcast ID it was sent
+	// with. Until the worker has stored it the recorded status applies.
+	query = `
+		SELECT r.receiver_id, m.id,
+			CASE
+				WHEN m.id IS NULL THEN r.status
+				WHEN m.moderation_status = 'quarantined' THEN 'held'
+				WHEN m.read_status THEN 'read'
+				ELSE COALESCE(m.delivery_status, 'sent')
+			END
+		FROM broadcast_recipients r
+		LEFT JOIN messages m ON m.broadcast_id = r.broadcast_id AND m.receiver_id = r.receiver_id
+		WHERE r.broadcast_id = $1
+		ORDER BY r.receiver_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, broadcast.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	broadcast.Recipients = []*BroadcastRecipient{}
+
+	for rows.Next() {
+		var recipient BroadcastRecipient
+		err := rows.Scan(&recipient.ReceiverID, &recipient.MessageID, &recipient.Status)
+		if err != nil {
+			return nil, err
+		}
+		broadcast.Recipients = append(broadcast.Recipients, &recipient)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &broadcast, nil
+}
+
+func (m BroadcastModel) GetAllForSender(ctx context.Context, organizationID, senderID int64, filters Filters) ([]*Broadcast, Metadata, error) {
+	query := `
+		SELECT id, sender_id, list_id, content, created_at
+		FROM broadcasts
+		WHERE sender_id = $1
+		AND sender_id IN (SELECT id FROM users WHERE organization_id = $4)
+		AND created_at < $2
+		ORDER BY created_at DESC
+		LIMIT $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, senderID, filters.Cursor, filters.PageSize, organizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	broadcasts := []*Broadcast{}
+
+	for rows.Next() {
+		var broadcast Broadcast
+		err := rows.Scan(&broadcast.ID, &broadcast.SenderID, &broadcast.ListID, &broadcast.Content, &broadcast.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		broadcasts = append(broadcasts, &broadcast)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var nextCursor time.Time
+
+	if len(broadcasts) > 0 {
+		nextCursor = broadcasts[len(broadcasts)-1].CreatedAt.UTC()
+	}
+
+	metadata := calculateMetadata(filters.Cursor, nextCursor, len(broadcasts), filters.PageSize)
+
+	return broadcasts, metadata, nil
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrDuplicateCannedResponse = errors.New("duplicate canned response")
+
+var ShortcutRX = regexp.MustCompile(`^/[a-z0-9_-]{1,32}$`)
+
+// CannedResponse is a saved reply an agent can send by its shortcut. Shared
+// responses are available to every user of the owner's organization, the rest
+// only to their owner.
+type CannedResponse struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Shortcut   string     `json:"shortcut"`
+	Body       string     `json:"body"`
+	Shared     bool       `json:"shared"`
+	UsageCount int64      `json:"usage_count"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CannedResponseModel struct {
+	DB *sql.DB
+}
+
+// CannedResponseSorts are the orders canned responses can be listed in
+var CannedResponseSorts = []string{"shortcut", "-usage_count"}
+
+func ValidateCannedResponse(v *validator.Validator, response *CannedResponse) {
+	v.Check(response.Shortcut != "", "shortcut", "Shortcut is required")
+	v.Check(validator.Matches(response.Shortcut, ShortcutRX), "shortcut", "Must start with / followed by up to 32 lowercase letters, digits, dashes or underscores")
+
+	v.Check(validator.NotBlank(response.Body), "body", "Body is required")
+	v.Check(len(response.Body) <= 1000, "body", "Body must be less than 1000 characters")
+}
+
+func (m CannedResponseModel) Insert(ctx context.Context, response *CannedResponse) error {
+	query := `
+		INSERT INTO canned_responses (user_id, shortcut, body, shared)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, shortcut) DO NOTHING
+		RETURNING id, usage_count, created_at`
+
+	args := []any{response.UserID, response.Shortcut, response.Body, response.Shared}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&response.ID, &response.UsageCount, &response.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateCannedResponse
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns a canned response owned by userID
+func (m CannedResponseModel) Get(ctx context.Context, id, userID int64) (*CannedResponse, error) {
+	query := `
+		SELECT id, user_id, shortcut, body, shared, usage_count, last_used_at, created_at
+		FROM canned_responses
+		WHERE id = $1 AND user_id = $2`
+
+	return m.get(ctx, query, id, userID)
+}
+
+// GetVisible returns a canned response userID may send: one of their own or
+// one shared within their organization
+func (m CannedResponseModel) GetVisible(ctx context.Context, organizationID, id, userID int64) (*CannedResponse, error) {
+	query := `
+		SELECT c.id, c.user_id, c.shortcut, c.body, c.shared, c.usage_count, c.last_used_at, c.created_at
+		FROM canned_responses c
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.organization_id = $3 AND (c.user_id = $2 OR c.shared)`
+
+	return m.get(ctx, query, id, userID, organizationID)
+}
+
+func (m CannedResponseModel) get(ctx context.Context, query string, args ...any) (*CannedResponse, error) {
+	var response CannedResponse
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&response.ID,
+		&response.UserID,
+		&response.Shortcut,
+		&response.Body,
+		&response.Shared,
+		&response.UsageCount,
+		&response.LastUsedAt,
+		&response.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &response, nil
+}
+
+// Search returns the canned responses of the organization visible to userID
+// whose shortcut starts with prefix. A userID of 0 only matches shared
+// responses.
+func (m CannedResponseModel) Search(ctx context.Context, organizationID, userID int64, prefix, sort string, limit int) ([]*CannedResponse, error) {
+	order := "c.shortcut, c.id"
+	if sort == "-usage_count" {
+		order = "c.usage_count DESC, c.shortcut, c.id"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.id, c.user_id, c.shortcut, c.body, c.shared, c.usage_count, c.last_used_at, c.created_at
+		FROM canned_responses c
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE u.organization_id = $4 AND (c.user_id = $1 OR c.shared)
+		AND starts_with(c.shortcut, $2)
+		ORDER BY %s
+		LIMIT $3`, order)
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, prefix, limit, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := []*CannedResponse{}
+
+	for rows.Next() {
+		var response CannedResponse
+		err := rows.Scan(
+			&response.ID,
+			&response.UserID,
+			&response.Shortcut,
+			&response.Body,
+			&response.Shared,
+			&response.UsageCount,
+			&response.LastUsedAt,
+			&response.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, &response)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+func (m CannedResponseModel) Update(ctx context.Context, response *CannedResponse) error {
+	query := `
+		UPDATE canned_responses
+		SET shortcut = $1, body = $2, shared = $3
+		WHERE id = $4 AND user_id = $5
+		AND NOT EXISTS (
+			SELECT 1 FROM canned_responses
+			WHERE user_id = $5 AND shortcut = $1 AND id <> $4
+		)`
+
+	args := []any{response.Shortcut, response.Body, response.Shared, response.ID, response.UserID}
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// The response was already loaded by the caller, so no rows means the new
+	// shortcut is taken
+	if rowsAffected == 0 {
+		return ErrDuplicateCannedResponse
+	}
+	return nil
+}
+
+func (m CannedResponseModel) Delete(ctx context.Context, id, userID int64) error {
+	query := `
+		DELETE FROM canned_responses
+		WHERE id = $1 AND user_id = $2`
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RecordUse counts a send of the canned response
+func (m CannedResponseModel) RecordUse(ctx context.Context, id int64) error {
+	query := `
+		UPDATE canned_responses
+		SET usage_count = usage_count + 1, last_used_at = NOW()
+		WHERE id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,323 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrDuplicateContact = errors.New("duplicate contact")
+
+// MaxContactImport caps how many identifiers a single import may contain
+const MaxContactImport = 1000
+
+// Contact is an entry in a user's address book. FullName is the contact
+// user's own name, Nickname is what the owner chose to call them.
+type Contact struct {
+	ID        int64     `json:"id"`
+	OwnerID   int64     `json:"owner_id"`
+	ContactID int64     `json:"contact_id"`
+	FullName  string    `json:"full_name"`
+	Nickname  string    `json:"nickname"`
+	Favorite  bool      `json:"favorite"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ContactMatch links an identifier from an import to the user it belongs to
+type ContactMatch struct {
+	Identifier string `json:"identifier"`
+	UserID     int64  `json:"user_id"`
+	FullName   string `json:"full_name"`
+}
+
+type ContactModel struct {
+	DB *sql.DB
+}
+
+func ValidateContact(v *validator.Validator, contact *Contact) {
+	v.Check(contact.ContactID > 0, "contact_id", "Contact ID must be a positive integer")
+	v.Check(contact.ContactID != contact.OwnerID, "contact_id", "You cannot add yourself as a contact")
+	v.Check(validator.MaxChars(contact.Nickname, 100), "nickname", "Nickname must not be more than 100 characters")
+}
+
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone strips the formatting characters people commonly use when
+// writing phone numbers
+func NormalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+}
+
+func ValidateContactImport(v *validator.Validator, emails, phones []string) {
+	v.Check(len(emails)+len(phones) > 0, "identifiers", "At least one email or phone number is required")
+	v.Check(len(emails)+len(phones) <= MaxContactImport, "identifiers", "Must not contain more than 1000 emails and phone numbers combined")
+
+	for _, email := range emails {
+		v.Check(validator.Matches(email, validator.EmailRX), "emails", "Must only contain valid email addresses")
+	}
+	for _, phone := range phones {
+		v.Check(validator.Matches(phone, validator.PhoneRX), "phones", "Must only contain valid phone numbers")
+	}
+}
+
+func (m ContactModel) Insert(ctx context.Context, contact *Contact) error {
+	query := `
+		INSERT INTO contacts (owner_id, contact_id, nickname, favorite)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (owner_id, contact_id) DO NOTHING
+		RETURNING id, created_at`
+
+	args := []any{contact.OwnerID, contact.ContactID, contact.Nickname, contact.Favorite}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&contact.ID, &contact.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateContact
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ContactModel) Get(ctx context.Context, ownerID, contactID int64) (*Contact, error) {
+	query := `
+		SELECT c.id, c.owner_id, c.contact_id, u.full_name, c.nickname, c.favorite, c.created_at
+		FROM contacts c
+		INNER JOIN users u ON u.id = c.contact_id
+		WHERE c.owner_id = $1 AND c.contact_id = $2`
+
+	var c Contact
+
+	err := m.DB.QueryRowContext(ctx, query, ownerID, contactID).Scan(&c.ID, &c.OwnerID, &c.ContactID, &c.FullName, &c.Nickname, &c.Favorite, &c.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// GetAllForOwner returns a user's address book with favorites first
+func (m ContactModel) GetAllForOwner(ctx context.Context, ownerID int64) ([]*Contact, error) {
+	query := `
+		SELECT c.id, c.owner_id, c.contact_id, u.full_name, c.nickname, c.favorite, c.created_at
+		FROM contacts c
+		INNER JOIN users u ON u.id = c.contact_id
+		WHERE c.owner_id = $1
+		ORDER BY c.favorite DESC, COALESCE(NULLIF(c.nickname, ''), u.full_name), c.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []*Contact{}
+
+	for rows.Next() {
+		var c Contact
+		err := rows.Scan(&c.ID, &c.OwnerID, &c.ContactID, &c.FullName, &c.Nickname, &c.Favorite, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+func (m ContactModel) Update(ctx context.Context, contact *Contact) error {
+	query := `
+		UPDATE contacts
+		SET nickname = $1, favorite = $2
+		WHERE owner_id = $3 AND contact_id = $4`
+
+	res, err := m.DB.ExecContext(ctx, query, contact.Nickname, contact.Favorite, contact.OwnerID, contact.ContactID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m ContactModel) Delete(ctx context.Context, ownerID, contactID int64) error {
+	query := `
+		DELETE FROM contacts
+		WHERE owner_id = $1 AND contact_id = $2`
+
+	res, err := m.DB.ExecContext(ctx, query, ownerID, contactID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// IsContact reports whether contactID is in ownerID's address book
+func (m ContactModel) IsContact(ctx context.Context, ownerID, contactID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM contacts
+			WHERE owner_id = $1 AND contact_id = $2
+		)`
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, ownerID, contactID).Scan(&exists)
+	return exists, err
+}
+
+// GetOwnerIDs returns the IDs of every user who has contactID in their address book
+func (m ContactModel) GetOwnerIDs(ctx context.Context, contactID int64) ([]int64, error) {
+	query := `
+		SELECT owner_id
+		FROM contacts
+		WHERE contact_id = $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, contactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ownerIDs := []int64{}
+
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ownerIDs = append(ownerIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ownerIDs, nil
+}
+
+// Import matches normalized emails and phone numbers against the users of
+// ownerID's organization and adds every match to ownerID's address book. Users
+// who already are contacts are matched but not imported again. It returns the
+// number of new contacts and all matches.
+func (m ContactModel) Import(ctx context.Context, ownerID int64, emails, phones []string) (int64, []*ContactMatch, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, full_name, COALESCE(email, ''), COALESCE(phone, '')
+		FROM users
+		WHERE (lower(email) = ANY($1) OR phone = ANY($2)) AND id <> $3
+		AND organization_id = (SELECT organization_id FROM users WHERE id = $3)`
+
+	rows, err := tx.QueryContext(ctx, query, emails, phones, ownerID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	requestedEmails := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		requestedEmails[email] = true
+	}
+
+	matches := []*ContactMatch{}
+	userIDs := []int64{}
+
+	for rows.Next() {
+		var (
+			userID       int64
+			fullName     string
+			email, phone string
+		)
+
+		err := rows.Scan(&userID, &fullName, &email, &phone)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Matches are reported by the normalized identifier that was imported
+		identifier := phone
+		if email = strings.ToLower(email); requestedEmails[email] {
+			identifier = email
+		}
+
+		matches = append(matches, &ContactMatch{Identifier: identifier, UserID: userID, FullName: fullName})
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if len(userIDs) == 0 {
+		return 0, matches, nil
+	}
+
+	query = `
+		INSERT INTO contacts (owner_id, contact_id)
+		SELECT $1, unnest($2::bigint[])
+		ON CONFLICT (owner_id, contact_id) DO NOTHING`
+
+	res, err := tx.ExecContext(ctx, query, ownerID, userIDs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	imported, err := res.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return imported, matches, nil
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// Disappearing message timers a conversation can be set to, keyed by the value
+// clients send. A zero duration turns the timer off.
+var DisappearingTimers = map[string]time.Duration{
+	"off": 0,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+}
+
+// Conversation holds settings shared by the two participants of a 1:1 chat.
+// The participants are stored ordered so that each pair maps to a single row.
+type Conversation struct {
+	ID             int64     `json:"id"`
+	OrganizationID int64     `json:"organization_id"`
+	UserAID        int64     `json:"user_a_id"`
+	UserBID        int64     `json:"user_b_id"`
+	MessageTTL     int64     `json:"message_ttl"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type ConversationModel struct {
+	DB *sql.DB
+}
+
+func ValidateDisappearingTimer(v *validator.Validator, timer string) {
+	_, ok := DisappearingTimers[timer]
+	v.Check(ok, "timer", "Timer must be one of off, 24h, 7d or 90d")
+}
+
+func orderedPair(userID, otherID int64) (int64, int64) {
+	if userID < otherID {
+		return userID, otherID
+	}
+	return otherID, userID
+}
+
+// Get returns the conversation between two users of the organization, creating
+// it with default settings if it does not exist yet. A conversation of another
+// organization is reported as not found.
+func (m ConversationModel) Get(ctx context.Context, organizationID, userID, otherID int64) (*Conversation, error) {
+	a, b := orderedPair(userID, otherID)
+
+	query := `
+		INSERT INTO conversations (organization_id, user_a_id, user_b_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_a_id, user_b_id) DO UPDATE SET user_a_id = EXCLUDED.user_a_id
+		WHERE conversations.organization_id = EXCLUDED.organization_id
+		RETURNING id, organization_id, user_a_id, user_b_id, message_ttl, created_at`
+
+	return m.scan(m.DB.QueryRowContext(ctx, query, organizationID, a, b))
+}
+
+// SetMessageTTL changes the disappearing messages timer of a conversation
+func (m ConversationModel) SetMessageTTL(ctx context.Context, organizationID, userID, otherID int64, ttl time.Duration) (*Conversation, error) {
+	a, b := orderedPair(userID, otherID)
+
+	query := `
+		INSERT INTO conversations (organization_id, user_a_id, user_b_id, message_ttl)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_a_id, user_b_id) DO UPDATE SET message_ttl = EXCLUDED.message_ttl
+		WHERE conversations.organization_id = EXCLUDED.organization_id
+		RETURNING id, organization_id, user_a_id, user_b_id, message_ttl, created_at`
+
+	return m.scan(m.DB.QueryRowContext(ctx, query, organizationID, a, b, int64(ttl.Seconds())))
+}
+
+func (m ConversationModel) scan(row *sql.Row) (*Conversation, error) {
+	var c Conversation
+
+	err := row.Scan(&c.ID, &c.OrganizationID, &c.UserAID, &c.UserBID, &c.MessageTTL, &c.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// External channels that contacts can be reached through
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelGeneric = "generic"
+)
+
+var ExternalChannels = []string{ChannelEmail, ChannelSMS, ChannelGeneric}
+
+// ExternalIdentity ties a user to their address on an external messaging
+// channel, such as a phone number on an SMS gateway. Users with an external
+// identity only exist to represent people reached through that channel.
+type ExternalIdentity struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Channel    string    `json:"channel"`
+	ExternalID string    `json:"external_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExternalIdentityModel struct {
+	DB *sql.DB
+}
+
+// NormalizeExternalID converts an address to the form it is stored in for its
+// channel, so that the same person always maps to the same user
+func NormalizeExternalID(channel, externalID string) string {
+	switch channel {
+	case ChannelEmail:
+		return NormalizeEmail(externalID)
+	case ChannelSMS:
+		return NormalizePhone(externalID)
+	default:
+		return externalID
+	}
+}
+
+func ValidateExternalIdentity(v *validator.Validator, channel, externalID string) {
+	v.Check(validator.PermittedValue(channel, ExternalChannels...), "channel", "Must be one of email, sms or generic")
+	v.Check(externalID != "", "external_id", "Must be provided")
+	v.Check(validator.MaxChars(externalID, 255), "external_id", "Must not be more than 255 characters")
+
+	switch channel {
+	case ChannelEmail:
+		v.Check(validator.Matches(externalID, validator.EmailRX), "external_id", "Must be a valid email address")
+	case ChannelSMS:
+		v.Check(validator.Matches(externalID, validator.PhoneRX), "external_id", "Must be a valid phone number")
+	}
+}
+
+// GetOrCreateUser returns the organization's user behind an external address,
+// creating the user and the identity the first time the organization sees the
+// address
+func (m ExternalIdentityModel) GetOrCreateUser(ctx context.Context, organizationID int64, channel, externalID, fullName string) (*User, error) {
+	user, err := m.GetUser(ctx, organizationID, channel, externalID)
+	if err == nil || !errors.Is(err, ErrRecordNotFound) {
+		return user, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if fullName == "" {
+		fullName = externalID
+	}
+
+	user = &User{OrganizationID: organizationID, FullName: fullName}
+
+	query := `
+		INSERT INTO users (organization_id, full_name)
+		VALUES ($1, $2)
+		RETURNING id, message_privacy`
+
+	err = tx.QueryRowContext(ctx, query, organizationID, user.FullName).Scan(&user.ID, &user.MessagePrivacy)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO memberships (organization_id, user_id)
+		VALUES ($1, $2)`
+
+	_, err = tx.ExecContext(ctx, query, organizationID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO external_identities (organization_id, user_id, channel, external_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, channel, external_id) DO NOTHING`
+
+	res, err := tx.ExecContext(ctx, query, organizationID, user.ID, channel, externalID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Someone else created the identity concurrently. Drop our user and use theirs.
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return m.GetUser(ctx, organizationID, channel, externalID)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUser returns the organization's user behind an external address, or
+// ErrRecordNotFound if the organization has not seen the address yet
+func (m ExternalIdentityModel) GetUser(ctx context.Context, organizationID int64, channel, externalID string) (*User, error) {
+	query := `
+		SELECT u.id, u.organization_id, u.full_name, u.message_privacy, u.suspended_at
+		FROM external_identities e
+		INNER JOIN users u ON u.id = e.user_id
+		WHERE e.organization_id = $1 AND e.channel = $2 AND e.external_id = $3`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, organizationID, channel, externalID).Scan(&user.ID, &user.OrganizationID, &user.FullName, &user.MessagePrivacy, &user.SuspendedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetForUser returns the external identity of a user, or ErrRecordNotFound for
+// regular users
+func (m ExternalIdentityModel) GetForUser(ctx context.Context, userID int64) (*ExternalIdentity, error) {
+	query := `
+		SELECT id, user_id, channel, external_id, created_at
+		FROM external_identities
+		WHERE user_id = $1`
+
+	var identity ExternalIdentity
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&identity.ID, &identity.UserID, &identity.Channel, &identity.ExternalID, &identity.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+// GetForUsers returns the external identities among the given users, keyed by
+// user ID
+func (m ExternalIdentityModel) GetForUsers(ctx context.Context, userIDs []int64) (map[int64]*ExternalIdentity, error) {
+	query := `
+		SELECT id, user_id, channel, external_id, created_at
+		FROM external_identities
+		WHERE user_id = ANY($1)`
+
+	rows, err := m.DB.QueryContext(ctx, query, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make(map[int64]*ExternalIdentity)
+
+	for rows.Next() {
+		var identity ExternalIdentity
+		err := rows.Scan(&identity.ID, &identity.UserID, &identity.Channel, &identity.ExternalID, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities[identity.UserID] = &identity
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrDuplicateAgent = errors.New("duplicate agent")
+
+// Strategies for assigning new conversations to an inbox's agents
+const (
+	AssignRoundRobin  = "round_robin"
+	AssignLeastLoaded = "least_loaded"
+)
+
+// maxSLA bounds SLA thresholds, in seconds
+const maxSLA = 30 * 24 * 60 * 60
+
+// Inbox turns a user, typically a business account, into a shared inbox whose
+// incoming conversations are assigned to agents. SLA thresholds are in seconds
+// from when a conversation is opened, and zero disables them.
+type Inbox struct {
+	UserID           int64     `json:"user_id"`
+	Strategy         string    `json:"strategy"`
+	FirstResponseSLA int       `json:"first_response_sla"`
+	ResolutionSLA    int       `json:"resolution_sla"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// InboxAgent is an agent of an inbox along with how many open conversations
+// they are assigned
+type InboxAgent struct {
+	AgentID         int64     `json:"agent_id"`
+	FullName        string    `json:"full_name"`
+	OpenAssignments int       `json:"open_assignments"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type InboxModel struct {
+	DB *sql.DB
+}
+
+func ValidateInbox(v *validator.Validator, inbox *Inbox) {
+	v.Check(validator.PermittedValue(inbox.Strategy, AssignRoundRobin, AssignLeastLoaded), "strategy", "Must be round_robin or least_loaded")
+	v.Check(inbox.FirstResponseSLA >= 0 && inbox.FirstResponseSLA <= maxSLA, "first_response_sla", "Must be between 0 and 30 days in seconds")
+	v.Check(inbox.ResolutionSLA >= 0 && inbox.ResolutionSLA <= maxSLA, "resolution_sla", "Must be between 0 and 30 days in seconds")
+}
+
+func (m InboxModel) Get(ctx context.Context, organizationID, userID int64) (*Inbox, error) {
+	query := `
+		SELECT user_id, strategy, first_response_sla, resolution_sla, created_at
+		FROM inboxes
+		WHERE user_id = $1
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $2)`
+
+	var inbox Inbox
+
+	err := m.DB.QueryRowContext(ctx, query, userID, organizationID).Scan(
+		&inbox.UserID,
+		&inbox.Strategy,
+		&inbox.FirstResponseSLA,
+		&inbox.ResolutionSLA,
+		&inbox.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &inbox, nil
+}
+
+// GetMany returns the inboxes among the given users, keyed by user ID
+func (m InboxModel) GetMany(ctx context.Context, userIDs []int64) (map[int64]*Inbox, error) {
+	query := `
+		SELECT user_id, strategy, first_response_sla, resolution_sla, created_at
+		FROM inboxes
+		WHERE user_id = ANY($1)`
+
+	rows, err := m.DB.QueryContext(ctx, query, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inboxes := make(map[int64]*Inbox)
+
+	for rows.Next() {
+		var inbox Inbox
+		err := rows.Scan(&inbox.UserID, &inbox.Strategy, &inbox.FirstResponseSLA, &inbox.ResolutionSLA, &inbox.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		inboxes[inbox.UserID] = &inbox
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return inboxes, nil
+}
+
+// Upsert creates the inbox or updates its settings
+func (m InboxModel) Upsert(ctx context.Context, inbox *Inbox) error {
+	query := `
+		INSERT INTO inboxes (user_id, strategy, first_response_sla, resolution_sla)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET strategy = EXCLUDED.strategy,
+			first_response_sla = EXCLUDED.first_response_sla,
+			resolution_sla = EXCLUDED.resolution_sla
+		RETURNING created_at`
+
+	args := []any{inbox.UserID, inbox.Strategy, inbox.FirstResponseSLA, inbox.ResolutionSLA}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&inbox.CreatedAt)
+}
+
+func (m InboxModel) AddAgent(ctx context.Context, inboxID, agentID int64) error {
+	query := `
+		INSERT INTO inbox_agents (inbox_id, agent_id)
+		VALUES ($1, $2)
+		ON CONFLICT (inbox_id, agent_id) DO NOTHING`
+
+	res, err := m.DB.ExecContext(ctx, query, inboxID, agentID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateAgent
+	}
+	return nil
+}
+
+// RemoveAgent removes an agent from the inbox. Their open conversations are
+// left unassigned so that they can be transferred.
+func (m InboxModel) RemoveAgent(ctx context.Context, organizationID, inboxID, agentID int64) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM inbox_agents
+		WHERE inbox_id = $1 AND agent_id = $2
+		AND inbox_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	res, err := tx.ExecContext(ctx, query, inboxID, agentID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		UPDATE assignments
+		SET agent_id = NULL
+		WHERE inbox_id = $1 AND agent_id = $2 AND resolved_at IS NULL`
+
+	_, err = tx.ExecContext(ctx, query, inboxID, agentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m InboxModel) GetAgents(ctx context.Context, organizationID, inboxID int64) ([]*InboxAgent, error) {
+	query := `
+		SELECT a.agent_id, u.full_name, COUNT(s.id), a.created_at
+		FROM inbox_agents a
+		INNER JOIN users u ON u.id = a.agent_id
+		LEFT JOIN assignments s ON s.inbox_id = a.inbox_id AND s.agent_id = a.agent_id AND s.resolved_at IS NULL
+		WHERE a.inbox_id = $1 AND u.organization_id = $2
+		GROUP BY a.agent_id, u.full_name, a.created_at
+		ORDER BY a.agent_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, inboxID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []*InboxAgent{}
+
+	for rows.Next() {
+		var agent InboxAgent
+		err := rows.Scan(&agent.AgentID, &agent.FullName, &agent.OpenAssignments, &agent.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, &agent)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return agents, nil
+}
+
+func (m InboxModel) IsAgent(ctx context.Context, organizationID, inboxID, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM inbox_agents
+			WHERE inbox_id = $1 AND agent_id = $2
+			AND inbox_id IN (SELECT id FROM users WHERE organization_id = $3)
+		)`
+
+	var isAgent bool
+
+	err := m.DB.QueryRowContext(ctx, query, inboxID, userID, organizationID).Scan(&isAgent)
+	if err != nil {
+		return false, err
+	}
+
+	return isAgent, nil
+}
@@ -3,18 +3,48 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/araaavind/zoko-im/internal/validator"
 )
 
+const (
+	MessageKindText      = "text"
+	MessageKindSystem    = "system"
+	MessageKindAutoReply = "auto_reply"
+)
+
+// Delivery statuses of messages handed to an external channel
+const (
+	DeliveryStatusSent      = "sent"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+// Moderation statuses of messages. Quarantined messages were caught by the
+// spam filters and are only visible to their sender and moderators.
+const (
+	ModerationClean       = "clean"
+	ModerationQuarantined = "quarantined"
+)
+
 type Message struct {
-	ID         int64     `json:"id"`
-	Timestamp  time.Time `json:"timestamp"`
-	Content    string    `json:"content"`
-	SenderID   int64     `json:"sender_id"`
-	ReceiverID int64     `json:"receiver_id"`
-	ReadStatus bool      `json:"read"`
+	ID               int64      `json:"id"`
+	OrganizationID   int64      `json:"organization_id"`
+	Timestamp        time.Time  `json:"timestamp"`
+	Content          string     `json:"content"`
+	SenderID         int64      `json:"sender_id"`
+	ReceiverID       int64      `json:"receiver_id"`
+	ReadStatus       bool       `json:"read"`
+	ModerationStatus string     `json:"moderation_status"`
+	Kind             string     `json:"kind"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Forwarded        bool       `json:"forwarded"`
+	ForwardedFromID  *int64     `json:"forwarded_from_id,omitempty"`
+	DeliveryStatus   string     `json:"delivery_status,omitempty"`
+	BroadcastID      *int64     `json:"broadcast_id,omitempty"`
 }
 
 type MessageModel struct {
@@ -22,26 +52,123 @@ type MessageModel struct {
 	DB *sql.DB
 }
 
+// MaxForwardRecipients caps how many chats a message can be forwarded to at once
+const MaxForwardRecipients = 20
+
 func ValidateMessage(v *validator.Validator, message *Message) {
 	v.Check(message.Content != "", "content", "Content is required")
 	v.Check(len(message.Content) <= 1000, "content", "Content must be less than 1000 characters")
 }
 
-func (m *MessageModel) Insert(ctx context.Context, message *Message) error {
-	query := `
-		INSERT INTO messages (timestamp, content, sender_id, receiver_id, read_status)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`
+func ValidateForwardRecipients(v *validator.Validator, receiverIDs []int64) {
+	v.Check(len(receiverIDs) > 0, "receiver_ids", "At least one receiver is required")
+	v.Check(len(receiverIDs) <= MaxForwardRecipients, "receiver_ids", fmt.Sprintf("Must not contain more than %d receivers", MaxForwardRecipients))
+	v.Check(validator.Unique(receiverIDs), "receiver_ids", "Must not contain duplicate receivers")
+
+	for _, id := range receiverIDs {
+		v.Check(id > 0, "receiver_ids", "Receiver IDs must be positive integers")
+	}
+}
+
+// Forward returns a copy of the message addressed from senderID to receiverID
+func (message *Message) Forward(senderID, receiverID int64) *Message {
+	forwardedFromID := message.ID
+
+	return &Message{
+		Timestamp:       time.Now(),
+		OrganizationID:  message.OrganizationID,
+		Content:         message.Content,
+		SenderID:        senderID,
+		ReceiverID:      receiverID,
+		ReadStatus:      false,
+		Kind:            MessageKindText,
+		Forwarded:       true,
+		ForwardedFromID: &forwardedFromID,
+	}
+}
+
+// The expiry of a message is derived from the disappearing messages timer of
+// its conversation at the time it is persisted. System messages never expire.
+// Messages enqueued without an organization, such as those still in the stream
+// from before organizations existed, take the sender's.
+const insertMessageQuery = `
+	INSERT INTO messages (timestamp, content, sender_id, receiver_id, read_status, kind, forwarded, forwarded_from_id, broadcast_id, organization_id, moderation_status, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE(NULLIF($10, 0), (SELECT organization_id FROM users WHERE id = $3)), COALESCE(NULLIF($11, ''), 'clean'), (
+		SELECT $1::timestamptz + make_interval(secs => message_ttl)
+		FROM conversations
+		WHERE user_a_id = LEAST($3::bigint, $4::bigint)
+		AND user_b_id = GREATEST($3::bigint, $4::bigint)
+		AND message_ttl > 0
+		AND $6::text <> 'system'
+	))
+	RETURNING id, organization_id, moderation_status, expires_at`
+
+func insertMessageArgs(message *Message) []any {
+	if message.Kind == "" {
+		message.Kind = MessageKindText
+	}
 
-	args := []any{
+	return []any{
 		message.Timestamp,
 		message.Content,
 		message.SenderID,
 		message.ReceiverID,
 		message.ReadStatus,
+		message.Kind,
+		message.Forwarded,
+		message.ForwardedFromID,
+		message.BroadcastID,
+		message.OrganizationID,
+		message.ModerationStatus,
+	}
+}
+
+func (m *MessageModel) Insert(ctx context.Context, message *Message) error {
+	args := insertMessageArgs(message)
+
+	return m.DB.QueryRowContext(ctx, insertMessageQuery, args...).Scan(&message.ID, &message.OrganizationID, &message.ModerationStatus, &message.ExpiresAt)
+}
+
+// Get returns a message of the organization by ID. Messages that have
+// disappeared or belong to another organization are reported as not found.
+func (m *MessageModel) Get(ctx context.Context, organizationID, id int64) (*Message, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, organization_id, timestamp, content, read_status, moderation_status, sender_id, receiver_id, kind, expires_at, forwarded, forwarded_from_id, COALESCE(delivery_status, '')
+		FROM messages
+		WHERE id = $1 AND organization_id = $2
+		AND (expires_at IS NULL OR expires_at > NOW())`
+
+	var message Message
+
+	err := m.DB.QueryRowContext(ctx, query, id, organizationID).Scan(
+		&message.ID,
+		&message.OrganizationID,
+		&message.Timestamp,
+		&message.Content,
+		&message.ReadStatus,
+		&message.ModerationStatus,
+		&message.SenderID,
+		&message.ReceiverID,
+		&message.Kind,
+		&message.ExpiresAt,
+		&message.Forwarded,
+		&message.ForwardedFromID,
+		&message.DeliveryStatus,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&message.ID)
+	return &message, nil
 }
 
 // Inserts multiple messages in a single transaction
@@ -56,27 +183,16 @@ func (m *MessageModel) BulkInsert(ctx context.Context, messages []*Message) erro
 	}
 	defer tx.Rollback()
 
-	query := `
-		INSERT INTO messages (timestamp, content, sender_id, receiver_id, read_status)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`
-
-	stmt, err := tx.PrepareContext(ctx, query)
+	stmt, err := tx.PrepareContext(ctx, insertMessageQuery)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, message := range messages {
-		args := []any{
-			message.Timestamp,
-			message.Content,
-			message.SenderID,
-			message.ReceiverID,
-			message.ReadStatus,
-		}
+		args := insertMessageArgs(message)
 
-		err = stmt.QueryRowContext(ctx, args...).Scan(&message.ID)
+		err = stmt.QueryRowContext(ctx, args...).Scan(&message.ID, &message.OrganizationID, &message.ModerationStatus, &message.ExpiresAt)
 		if err != nil {
 			return err
 		}
@@ -85,17 +201,66 @@ func (m *MessageModel) BulkInsert(ctx context.Context, messages []*Message) erro
 	return tx.Commit()
 }
 
-func (m *MessageModel) GetAllForSenderReceiver(ctx context.Context, senderID int64, receiverID int64, filters Filters) ([]*Message, Metadata, error) {
+// GetAllForSenderReceiver returns a page of the conversation as seen by
+// senderID, which includes their own quarantined messages
+func (m *MessageModel) GetAllForSenderReceiver(ctx context.Context, organizationID, senderID, receiverID int64, filters Filters) ([]*Message, Metadata, error) {
 	query := `
-		SELECT id, timestamp, content, read_status, sender_id, receiver_id
+		SELECT id, organization_id, timestamp, content, read_status, moderation_status, sender_id, receiver_id, kind, expires_at, forwarded, forwarded_from_id, COALESCE(delivery_status, '')
 		FROM messages
 		WHERE ((sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1))
-		AND (timestamp < $3)
+		AND organization_id = $3
+		AND (moderation_status = 'clean' OR sender_id = $1)
+		AND (timestamp < $4)
+		AND (expires_at IS NULL OR expires_at > NOW())
 		ORDER BY timestamp DESC
-		LIMIT $4
+		LIMIT $5
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query, senderID, receiverID, filters.Cursor, filters.PageSize)
+	rows, err := m.DB.QueryContext(ctx, query, senderID, receiverID, organizationID, filters.Cursor, filters.PageSize)
+	if err != nil {
+		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+
+	for rows.Next() {
+		var message Message
+		err := rows.Scan(&message.ID, &message.OrganizationID, &message.Timestamp, &message.Content, &message.ReadStatus, &message.ModerationStatus, &message.SenderID, &message.ReceiverID, &message.Kind, &message.ExpiresAt, &message.Forwarded, &message.ForwardedFromID, &message.DeliveryStatus)
+		if err != nil {
+			return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+		}
+		messages = append(messages, &message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+	}
+
+	var nextCursor time.Time
+
+	if len(messages) > 0 {
+		nextCursor = messages[len(messages)-1].Timestamp.UTC()
+	}
+
+	metadata := calculateMetadata(filters.Cursor, nextCursor, len(messages), filters.PageSize)
+
+	return messages, metadata, nil
+}
+
+// GetQuarantined returns a page of the organization's quarantined messages,
+// newest first
+func (m *MessageModel) GetQuarantined(ctx context.Context, organizationID int64, filters Filters) ([]*Message, Metadata, error) {
+	query := `
+		SELECT id, organization_id, timestamp, content, read_status, moderation_status, sender_id, receiver_id, kind, expires_at, forwarded, forwarded_from_id, COALESCE(delivery_status, '')
+		FROM messages
+		WHERE organization_id = $1
+		AND moderation_status = 'quarantined'
+		AND timestamp < $2
+		ORDER BY timestamp DESC
+		LIMIT $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID, filters.Cursor, filters.PageSize)
 	if err != nil {
 		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
 	}
@@ -105,7 +270,7 @@ func (m *MessageModel) GetAllForSenderReceiver(ctx context.Context, senderID int
 
 	for rows.Next() {
 		var message Message
-		err := rows.Scan(&message.ID, &message.Timestamp, &message.Content, &message.ReadStatus, &message.SenderID, &message.ReceiverID)
+		err := rows.Scan(&message.ID, &message.OrganizationID, &message.Timestamp, &message.Content, &message.ReadStatus, &message.ModerationStatus, &message.SenderID, &message.ReceiverID, &message.Kind, &message.ExpiresAt, &message.Forwarded, &message.ForwardedFromID, &message.DeliveryStatus)
 		if err != nil {
 			return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
 		}
@@ -127,14 +292,139 @@ func (m *MessageModel) GetAllForSenderReceiver(ctx context.Context, senderID int
 	return messages, metadata, nil
 }
 
-func (m *MessageModel) UpdateStatus(ctx context.Context, messageID int64, readStatus bool) error {
+// Release marks a quarantined message of the organization as clean, which
+// makes it visible to its receiver. Messages that are not quarantined are
+// reported as not found.
+func (m *MessageModel) Release(ctx context.Context, organizationID, id int64) error {
+	query := `
+		UPDATE messages
+		SET moderation_status = 'clean'
+		WHERE id = $1 AND organization_id = $2
+		AND moderation_status = 'quarantined'`
+
+	res, err := m.DB.ExecContext(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// HasConversation reports whether either user has messaged the other
+// before, not counting quarantined messages
+func (m *MessageModel) HasConversation(ctx context.Context, organizationID, userID, otherID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM messages
+			WHERE ((sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1))
+			AND organization_id = $3
+			AND moderation_status = 'clean'
+		)
+	`
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, otherID, organizationID).Scan(&exists)
+	return exists, err
+}
+
+func (m *MessageModel) UpdateStatus(ctx context.Context, organizationID, messageID int64, readStatus bool) error {
 	query := `
 		UPDATE messages
 		SET read_status = $1
-		WHERE id = $2
+		WHERE id = $2 AND organization_id = $3
+		AND moderation_status = 'clean'
+		AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, readStatus, messageID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteExpired hard-deletes up to limit messages whose expiry has passed, in
+// every organization, and returns how many were removed
+func (m *MessageModel) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	query := `
+		DELETE FROM messages
+		WHERE id IN (
+			SELECT id
+			FROM messages
+			WHERE expires_at <= NOW()
+			LIMIT $1
+		)
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// UpdateDelivery records the outcome of handing a message to an external
+// channel, along with the channel and the provider's ID for the message if
+// they are known
+func (m *MessageModel) UpdateDelivery(ctx context.Context, organizationID, messageID int64, status, channel, externalMessageID string) error {
+	query := `
+		UPDATE messages
+		SET delivery_status = $1,
+			delivery_channel = COALESCE(NULLIF($2, ''), delivery_channel),
+			external_message_id = COALESCE(NULLIF($3, ''), external_message_id)
+		WHERE id = $4 AND organization_id = $5
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, status, channel, externalMessageID, messageID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateDeliveryByExternalID applies a status callback from a provider to the
+// message it refers to. Provider IDs are unique within their channel. An
+// organizationID of 0 is for channels whose callbacks do not say which
+// organization they are for.
+func (m *MessageModel) UpdateDeliveryByExternalID(ctx context.Context, organizationID int64, channel, externalMessageID, status string) error {
+	query := `
+		UPDATE messages
+		SET delivery_status = $1
+		WHERE delivery_channel = $2 AND external_message_id = $3
+		AND ($4 = 0 OR organization_id = $4)
 	`
 
-	res, err := m.DB.ExecContext(ctx, query, readStatus, messageID)
+	res, err := m.DB.ExecContext(ctx, query, status, channel, externalMessageID, organizationID)
 	if err != nil {
 		return err
 	}
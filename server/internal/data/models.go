@@ -3,22 +3,70 @@ package data
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
 
 type Models struct {
-	Messages MessageModel
-	Users    UserModel
+	APIKeys            APIKeyModel
+	AuditEvents        AuditEventModel
+	Assignments        AssignmentModel
+	AutoReplyRules     AutoReplyRuleModel
+	Blocks             BlockModel
+	BroadcastLists     BroadcastListModel
+	Broadcasts         BroadcastModel
+	CannedResponses    CannedResponseModel
+	Contacts           ContactModel
+	Conversations      ConversationModel
+	ExternalIdentities ExternalIdentityModel
+	Inboxes            InboxModel
+	Messages           MessageModel
+	MessageTemplates   MessageTemplateModel
+	Organizations      OrganizationModel
+	Outbox             OutboxModel
+	Permissions        PermissionModel
+	Reports            ReportModel
+	ScheduledMessages  ScheduledMessageModel
+	Tokens             TokenModel
+	Users              UserModel
+	Webhooks           WebhookModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Messages: MessageModel{DB: db},
-		Users:    UserModel{DB: db},
+		APIKeys:            APIKeyModel{DB: db},
+		AuditEvents:        AuditEventModel{DB: db},
+		Assignments:        AssignmentModel{DB: db},
+		AutoReplyRules:     AutoReplyRuleModel{DB: db},
+		Blocks:             BlockModel{DB: db},
+		BroadcastLists:     BroadcastListModel{DB: db},
+		Broadcasts:         BroadcastModel{DB: db},
+		CannedResponses:    CannedResponseModel{DB: db},
+		Contacts:           ContactModel{DB: db},
+		Conversations:      ConversationModel{DB: db},
+		ExternalIdentities: ExternalIdentityModel{DB: db},
+		Inboxes:            InboxModel{DB: db},
+		Messages:           MessageModel{DB: db},
+		MessageTemplates:   MessageTemplateModel{DB: db},
+		Organizations:      OrganizationModel{DB: db},
+		Outbox:             OutboxModel{DB: db},
+		Permissions:        PermissionModel{DB: db},
+		Reports:            ReportModel{DB: db},
+		ScheduledMessages:  ScheduledMessageModel{DB: db},
+		Tokens:             TokenModel{DB: db},
+		Users:              UserModel{DB: db},
+		Webhooks:           WebhookModel{DB: db},
 	}
 }
 
+// pgArray wraps a pointer to a slice so that a PostgreSQL array column can be
+// scanned into it through database/sql
+func pgArray(dst any) sql.Scanner {
+	return pgtype.NewMap().SQLScanner(dst)
+}
+
 // func NewModelsWithPGX(db *pgxpool.Pool) Models {
 // 	return Models{
 // 		Messages: MessageModel{DB: db},
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultOrganizationID is the organization that users who existed before
+// organizations were introduced belong to
+const DefaultOrganizationID = 1
+
+// Organization is a business using Zoko. Its users can only see and message
+// each other.
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+// Roles of organization members, from most to least privileged
+const (
+	RoleOwner   = "owner"
+	RoleAdmin   = "admin"
+	RoleAgent   = "agent"
+	RoleEndUser = "end_user"
+)
+
+// Member is a user of an organization. Members with an email and password can
+// log in.
+type Member struct {
+	UserID    int64     `json:"user_id"`
+	FullName  string    `json:"full_name"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	Password  password  `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+func ValidateOrganization(v *validator.Validator, organization *Organization) {
+	v.Check(validator.NotBlank(organization.Name), "name", "Name is required")
+	v.Check(validator.MaxChars(organization.Name, 100), "name", "Name must not be more than 100 characters")
+}
+
+func ValidateMember(v *validator.Validator, member *Member) {
+	v.Check(validator.NotBlank(member.FullName), "full_name", "Full name is required")
+	v.Check(validator.MaxChars(member.FullName, 100), "full_name", "Full name must not be more than 100 characters")
+
+	ValidateRole(v, member.Role)
+
+	if member.Email != "" {
+		ValidateEmail(v, member.Email)
+	}
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, RoleOwner, RoleAdmin, RoleAgent, RoleEndUser), "role", "Must be one of owner, admin, agent or end_user")
+}
+
+func (m OrganizationModel) Get(ctx context.Context, id int64) (*Organization, error) {
+	query := `
+		SELECT id, name, created_at
+		FROM organizations
+		WHERE id = $1`
+
+	var organization Organization
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&organization.ID, &organization.Name, &organization.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+// InsertWithOwner creates the organization together with its first member,
+// who owns it
+func (m OrganizationModel) InsertWithOwner(ctx context.Context, organization *Organization, owner *Member) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (name)
+		VALUES ($1)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, organization.Name).Scan(&organization.ID, &organization.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	owner.Role = RoleOwner
+
+	err = insertMember(ctx, tx, organization.ID, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddMember creates a user in the organization along with their membership
+func (m OrganizationModel) AddMember(ctx context.Context, organizationID int64, member *Member) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMember(ctx, tx, organizationID, member)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertMember(ctx context.Context, tx *sql.Tx, organizationID int64, member *Member) error {
+	// Emails are unique across organizations regardless of case since they are
+	// used to log in
+	query := `
+		INSERT INTO users (organization_id, full_name, email, password_hash)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		RETURNING id`
+
+	err := tx.QueryRowContext(ctx, query, organizationID, member.FullName, member.Email, member.Password.hash).Scan(&member.UserID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.ConstraintName == "users_lower_email_key":
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO memberships (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING created_at`
+
+	return tx.QueryRowContext(ctx, query, organizationID, member.UserID, member.Role).Scan(&member.CreatedAt)
+}
+
+func (m OrganizationModel) GetMember(ctx context.Context, organizationID, userID int64) (*Member, error) {
+	query := `
+		SELECT m.user_id, u.full_name, COALESCE(u.email, ''), m.role, m.created_at
+		FROM memberships m
+		INNER JOIN users u ON u.organization_id = m.organization_id AND u.id = m.user_id
+		WHERE m.organization_id = $1 AND m.user_id = $2`
+
+	var member Member
+
+	err := m.DB.QueryRowContext(ctx, query, organizationID, userID).Scan(&member.UserID, &member.FullName, &member.Email, &member.Role, &member.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &member, nil
+}
+
+// SetRole changes the role of a member of the organization
+func (m OrganizationModel) SetRole(ctx context.Context, organizationID, userID int64, role string) error {
+	query := `
+		UPDATE memberships
+		SET role = $3
+		WHERE organization_id = $1 AND user_id = $2`
+
+	res, err := m.DB.ExecContext(ctx, query, organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m OrganizationModel) GetMembers(ctx context.Context, organizationID int64) ([]*Member, error) {
+	query := `
+		SELECT m.user_id, u.full_name, COALESCE(u.email, ''), m.role, m.created_at
+		FROM memberships m
+		INNER JOIN users u ON u.organization_id = m.organization_id AND u.id = m.user_id
+		WHERE m.organization_id = $1
+		ORDER BY m.user_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+
+	for rows.Next() {
+		var member Member
+		err := rows.Scan(&member.UserID, &member.FullName, &member.Email, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
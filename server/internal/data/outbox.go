@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// Insert durably records a message that still has to be published to the stream
+func (m OutboxModel) Insert(ctx context.Context, message *Message) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox (payload)
+		VALUES ($1)`
+
+	_, err = m.DB.ExecContext(ctx, query, payload)
+	return err
+}
+
+// BulkInsert records several messages in a single transaction
+func (m OutboxModel) BulkInsert(ctx context.Context, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO outbox (payload)
+		VALUES ($1)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.ExecContext(ctx, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RelayPending locks up to limit unsent rows, hands each message to publish in
+// insertion order and marks the published ones as sent. Publishing stops at the
+// first error so that ordering is preserved; the remaining rows are retried on
+// the next call. Rows locked by another relay are skipped.
+func (m OutboxModel) RelayPending(ctx context.Context, limit int, publish func(context.Context, *Message) error) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, payload
+		FROM outbox
+		WHERE sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	type pending struct {
+		id      int64
+		message Message
+	}
+
+	entries := []pending{}
+
+	for rows.Next() {
+		var (
+			entry   pending
+			payload []byte
+		)
+
+		err := rows.Scan(&entry.id, &payload)
+		if err != nil {
+			return 0, err
+		}
+
+		err = json.Unmarshal(payload, &entry.message)
+		if err != nil {
+			return 0, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := make([]int64, 0, len(entries))
+	var publishErr error
+
+	for _, entry := range entries {
+		publishErr = publish(ctx, &entry.message)
+		if publishErr != nil {
+			break
+		}
+		sent = append(sent, entry.id)
+	}
+
+	if len(sent) > 0 {
+		query = `
+			UPDATE outbox
+			SET sent_at = NOW()
+			WHERE id = ANY($1)`
+
+		_, err = tx.ExecContext(ctx, query, sent)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sent), publishErr
+}
+
+// DeleteSentBefore removes rows that were published before the given time
+func (m OutboxModel) DeleteSentBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM outbox
+		WHERE sent_at IS NOT NULL AND sent_at < $1`
+
+	res, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+)
+
+// Permission codes. Which roles hold them is stored in the role_permissions
+// table.
+const (
+	PermissionMessagesRead = "messages:read"
+	PermissionMessagesSend = "messages:send"
+	PermissionMembersRead  = "members:read"
+	PermissionMembersWrite = "members:write"
+	PermissionRolesWrite   = "roles:write"
+	PermissionAdminDLQ     = "admin:dlq"
+	PermissionAdminUsers   = "admin:users"
+	PermissionAPIKeysRead  = "api_keys:read"
+	PermissionAPIKeysWrite = "api_keys:write"
+	PermissionModeration   = "moderation:review"
+	PermissionAuditRead    = "audit:read"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser returns the permissions granted by the user's role in their
+// organization
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT rp.permission
+		FROM memberships m
+		INNER JOIN role_permissions rp ON rp.role = m.role
+		WHERE m.user_id = $1
+		ORDER BY rp.permission`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetUserIDsWithPermission returns the members of the organization whose role
+// grants the permission
+func (m PermissionModel) GetUserIDsWithPermission(ctx context.Context, organizationID int64, code string) ([]int64, error) {
+	query := `
+		SELECT m.user_id
+		FROM memberships m
+		INNER JOIN role_permissions rp ON rp.role = m.role
+		WHERE m.organization_id = $1 AND rp.permission = $2
+		ORDER BY m.user_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+
+	for rows.Next() {
+		var userID int64
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
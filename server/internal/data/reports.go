@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrDuplicateReport = errors.New("duplicate report")
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// How a moderator resolved a report
+const (
+	ResolutionDismissed      = "dismissed"
+	ResolutionMessageDeleted = "message_deleted"
+	ResolutionUserSuspended  = "user_suspended"
+)
+
+var ReportReasons = []string{
+	"spam",
+	"scam",
+	"harassment",
+	"hate_speech",
+	"sexual_content",
+	"violence",
+	"other",
+}
+
+// Report is a user flagging a message they received for review by their
+// organization's moderators. The message may be gone by the time the report
+// is looked at, so the reported user is kept on the report itself.
+type Report struct {
+	ID             int64      `json:"id"`
+	OrganizationID int64      `json:"organization_id"`
+	MessageID      *int64     `json:"message_id"`
+	ReporterID     int64      `json:"reporter_id"`
+	ReportedUserID int64      `json:"reported_user_id"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details,omitempty"`
+	Status         string     `json:"status"`
+	Resolution     *string    `json:"resolution,omitempty"`
+	ResolvedBy     *int64     `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type ReportModel struct {
+	DB *sql.DB
+}
+
+func ValidateReport(v *validator.Validator, report *Report) {
+	v.Check(validator.PermittedValue(report.Reason, ReportReasons...), "reason", "Must be one of spam, scam, harassment, hate_speech, sexual_content, violence or other")
+	v.Check(validator.MaxChars(report.Details, 1000), "details", "Details must not be more than 1000 characters")
+}
+
+func ValidateReportStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, ReportOpen, ReportResolved), "status", "Must be one of open or resolved")
+}
+
+func ValidateResolution(v *validator.Validator, resolution string) {
+	v.Check(validator.PermittedValue(resolution, ResolutionDismissed, ResolutionMessageDeleted, ResolutionUserSuspended), "resolution", "Must be one of dismissed, message_deleted or user_suspended")
+}
+
+const reportColumns = `id, organization_id, message_id, reporter_id, reported_user_id, reason, details, status, resolution, resolved_by, resolved_at, created_at`
+
+func scanReport(scanner interface{ Scan(...any) error }) (*Report, error) {
+	var report Report
+
+	err := scanner.Scan(
+		&report.ID,
+		&report.OrganizationID,
+		&report.MessageID,
+		&report.ReporterID,
+		&report.ReportedUserID,
+		&report.Reason,
+		&report.Details,
+		&report.Status,
+		&report.Resolution,
+		&report.ResolvedBy,
+		&report.ResolvedAt,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// Insert files the report. A user can only report the same message once.
+func (m ReportModel) Insert(ctx context.Context, report *Report) error {
+	query := `
+		INSERT INTO reports (organization_id, message_id, reporter_id, reported_user_id, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (message_id, reporter_id) DO NOTHING
+		RETURNING id, status, created_at`
+
+	args := []any{
+		report.OrganizationID,
+		report.MessageID,
+		report.ReporterID,
+		report.ReportedUserID,
+		report.Reason,
+		report.Details,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&report.ID, &report.Status, &report.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateReport
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ReportModel) Get(ctx context.Context, organizationID, id int64) (*Report, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE id = $1 AND organization_id = $2`
+
+	report, err := scanReport(m.DB.QueryRowContext(ctx, query, id, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// GetAll returns a page of the organization's reports, newest first. An empty
+// status returns reports of any status.
+func (m ReportModel) GetAll(ctx context.Context, organizationID int64, status string, filters Filters) ([]*Report, Metadata, error) {
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE organization_id = $1
+		AND (status = $2 OR $2 = '')
+		AND created_at < $3
+		ORDER BY created_at DESC
+		LIMIT $4`
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID, status, filters.Cursor, filters.PageSize)
+	if err != nil {
+		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+		}
+		reports = append(reports, report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, getEmptyMetadata(filters.Cursor, filters.PageSize), err
+	}
+
+	var nextCursor time.Time
+
+	if len(reports) > 0 {
+		nextCursor = reports[len(reports)-1].CreatedAt.UTC()
+	}
+
+	metadata := calculateMetadata(filters.Cursor, nextCursor, len(reports), filters.PageSize)
+
+	return reports, metadata, nil
+}
+
+// Resolve closes an open report and carries out the resolution in the same
+// transaction: the reported message is deleted or its sender is suspended.
+// Reports that are already resolved are reported as not found.
+func (m ReportModel) Resolve(ctx context.Context, organizationID, id int64, resolution string, resolvedBy int64) (*Report, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE reports
+		SET status = 'resolved', resolution = $3, resolved_by = NULLIF($4, 0), resolved_at = NOW()
+		WHERE id = $1 AND organization_id = $2 AND status = 'open'
+		RETURNING ` + reportColumns
+
+	report, err := scanReport(tx.QueryRowContext(ctx, query, id, organizationID, resolution, resolvedBy))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	switch resolution {
+	case ResolutionMessageDeleted:
+		if report.MessageID != nil {
+			query = `
+				DELETE FROM messages
+				WHERE id = $1 AND organization_id = $2`
+
+			_, err = tx.ExecContext(ctx, query, *report.MessageID, organizationID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case ResolutionUserSuspended:
+		query = `
+			UPDATE users
+			SET suspended_at = COALESCE(suspended_at, NOW())
+			WHERE id = $1 AND organization_id = $2`
+
+		_, err = tx.ExecContext(ctx, query, report.ReportedUserID, organizationID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Suspended users cannot send, so their scheduled messages are
+		// cancelled rather than held until they are reinstated
+		query = `
+			DELETE FROM scheduled_messages
+			WHERE sender_id = $1 AND organization_id = $2`
+
+		_, err = tx.ExecContext(ctx, query, report.ReportedUserID, organizationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
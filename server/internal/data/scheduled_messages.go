@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+type ScheduledMessage struct {
+	ID             int64     `json:"id"`
+	OrganizationID int64     `json:"organization_id"`
+	SendAt         time.Time `json:"send_at"`
+	Content        string    `json:"content"`
+	SenderID       int64     `json:"sender_id"`
+	ReceiverID     int64     `json:"receiver_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type ScheduledMessageModel struct {
+	DB *sql.DB
+}
+
+func ValidateSendAt(v *validator.Validator, sendAt time.Time) {
+	v.Check(validator.ValidTimestamp(sendAt), "send_at", "Send time must be a valid timestamp")
+	v.Check(validator.IsTimestampInFuture(sendAt), "send_at", "Send time must be in the future")
+	v.Check(sendAt.Before(time.Now().AddDate(1, 0, 0)), "send_at", "Send time must be within a year")
+}
+
+// Message builds the message that is enqueued once the scheduled message is due
+func (s *ScheduledMessage) Message() *Message {
+	return &Message{
+		OrganizationID: s.OrganizationID,
+		Timestamp:      s.SendAt,
+		Content:        s.Content,
+		SenderID:       s.SenderID,
+		ReceiverID:     s.ReceiverID,
+		ReadStatus:     false,
+		Kind:           MessageKindText,
+	}
+}
+
+func (m ScheduledMessageModel) Insert(ctx context.Context, scheduled *ScheduledMessage) error {
+	query := `
+		INSERT INTO scheduled_messages (organization_id, send_at, content, sender_id, receiver_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{
+		scheduled.OrganizationID,
+		scheduled.SendAt,
+		scheduled.Content,
+		scheduled.SenderID,
+		scheduled.ReceiverID,
+	}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&scheduled.ID, &scheduled.CreatedAt)
+}
+
+func (m ScheduledMessageModel) GetAllForSender(ctx context.Context, organizationID, senderID int64) ([]*ScheduledMessage, error) {
+	query := `
+		SELECT id, organization_id, send_at, content, sender_id, receiver_id, created_at
+		FROM scheduled_messages
+		WHERE sender_id = $1 AND organization_id = $2
+		ORDER BY send_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, senderID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scheduled := []*ScheduledMessage{}
+
+	for rows.Next() {
+		var s ScheduledMessage
+		err := rows.Scan(&s.ID, &s.OrganizationID, &s.SendAt, &s.Content, &s.SenderID, &s.ReceiverID, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		scheduled = append(scheduled, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+// Reschedule moves a pending scheduled message owned by senderID to a new send time
+func (m ScheduledMessageModel) Reschedule(ctx context.Context, organizationID, id, senderID int64, sendAt time.Time) (*ScheduledMessage, error) {
+	query := `
+		UPDATE scheduled_messages
+		SET send_at = $1
+		WHERE id = $2 AND sender_id = $3 AND organization_id = $4
+		RETURNING id, organization_id, send_at, content, sender_id, receiver_id, created_at`
+
+	var s ScheduledMessage
+
+	err := m.DB.QueryRowContext(ctx, query, sendAt, id, senderID, organizationID).Scan(&s.ID, &s.OrganizationID, &s.SendAt, &s.Content, &s.SenderID, &s.ReceiverID, &s.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+func (m ScheduledMessageModel) Delete(ctx context.Context, organizationID, id, senderID int64) error {
+	query := `
+		DELETE FROM scheduled_messages
+		WHERE id = $1 AND sender_id = $2 AND organization_id = $3`
+
+	res, err := m.DB.ExecContext(ctx, query, id, senderID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// PromoteDue locks up to limit scheduled messages whose send time has passed,
+// hands each one to publish and deletes the ones publish returned nil for,
+// including any it chose not to send. Rows locked by another scheduler are
+// skipped.
+func (m ScheduledMessageModel) PromoteDue(ctx context.Context, limit int, publish func(context.Context, *Message) error) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, organization_id, send_at, content, sender_id, receiver_id, created_at
+		FROM scheduled_messages
+		WHERE send_at <= NOW()
+		ORDER BY send_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	due := []*ScheduledMessage{}
+
+	for rows.Next() {
+		var s ScheduledMessage
+		err := rows.Scan(&s.ID, &s.OrganizationID, &s.SendAt, &s.Content, &s.SenderID, &s.ReceiverID, &s.CreatedAt)
+		if err != nil {
+			return 0, err
+		}
+		due = append(due, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	promoted := make([]int64, 0, len(due))
+	var publishErr error
+
+	for _, s := range due {
+		publishErr = publish(ctx, s.Message())
+		if publishErr != nil {
+			break
+		}
+		promoted = append(promoted, s.ID)
+	}
+
+	if len(promoted) > 0 {
+		query = `
+			DELETE FROM scheduled_messages
+			WHERE id = ANY($1)`
+
+		_, err = tx.ExecContext(ctx, query, promoted)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(promoted), publishErr
+}
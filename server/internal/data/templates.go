@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+var ErrDuplicateTemplate = errors.New("duplicate template")
+
+var (
+	TemplateNameRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	templateVarRX  = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+)
+
+// MessageTemplate is a named message body with {{placeholders}} that are
+// filled in when a message is sent from it
+type MessageTemplate struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MessageTemplateModel struct {
+	DB *sql.DB
+}
+
+func ValidateMessageTemplate(v *validator.Validator, template *MessageTemplate) {
+	v.Check(template.Name != "", "name", "Name is required")
+	v.Check(validator.MaxChars(template.Name, 100), "name", "Must not be more than 100 characters long")
+	v.Check(validator.Matches(template.Name, TemplateNameRX), "name", "Must only contain lowercase letters, digits, dashes and underscores")
+
+	v.Check(validator.NotBlank(template.Body), "body", "Body is required")
+	v.Check(len(template.Body) <= 1000, "body", "Body must be less than 1000 characters")
+
+	validatePlaceholderSyntax(v, "body", template.Body)
+}
+
+// validatePlaceholderSyntax reports malformed placeholders in body under key.
+// Anything left between braces once the valid placeholders are removed is
+// malformed.
+func validatePlaceholderSyntax(v *validator.Validator, key, body string) {
+	rest := templateVarRX.ReplaceAllString(body, "")
+	v.Check(!strings.Contains(rest, "{{") && !strings.Contains(rest, "}}"), key, "Placeholders must look like {{name}} and only contain letters, digits and underscores")
+}
+
+// Placeholders returns the names of the template's placeholders in order of
+// first appearance
+func (t *MessageTemplate) Placeholders() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+
+	for _, match := range templateVarRX.FindAllStringSubmatch(t.Body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+
+	return names
+}
+
+// ValidateTemplateParams checks that params supplies exactly the template's
+// placeholders. Errors are keyed by "params.<name>".
+func ValidateTemplateParams(v *validator.Validator, template *MessageTemplate, params map[string]string) {
+	placeholders := template.Placeholders()
+
+	for _, name := range placeholders {
+		_, ok := params[name]
+		v.Check(ok, "params."+name, "Must be provided")
+	}
+
+	for name := range params {
+		v.Check(validator.PermittedValue(name, placeholders...), "params."+name, "Is not a placeholder in this template")
+	}
+}
+
+// Render returns the template body with every placeholder replaced by its
+// value in params. Parameters should be checked with ValidateTemplateParams
+// first; placeholders without a value are left untouched.
+func (t *MessageTemplate) Render(params map[string]string) string {
+	return templateVarRX.ReplaceAllStringFunc(t.Body, func(placeholder string) string {
+		name := templateVarRX.FindStringSubmatch(placeholder)[1]
+		if value, ok := params[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+func (m MessageTemplateModel) Insert(ctx context.Context, template *MessageTemplate) error {
+	query := `
+		INSERT INTO message_templates (user_id, name, body)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, name) DO NOTHING
+		RETURNING id, created_at`
+
+	args := []any{template.UserID, template.Name, template.Body}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&template.ID, &template.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateTemplate
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m MessageTemplateModel) Get(ctx context.Context, organizationID, id, userID int64) (*MessageTemplate, error) {
+	query := `
+		SELECT id, user_id, name, body, created_at
+		FROM message_templates
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	return m.get(ctx, query, id, userID, organizationID)
+}
+
+func (m MessageTemplateModel) GetByName(ctx context.Context, organizationID, userID int64, name string) (*MessageTemplate, error) {
+	query := `
+		SELECT id, user_id, name, body, created_at
+		FROM message_templates
+		WHERE user_id = $1 AND name = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	return m.get(ctx, query, userID, name, organizationID)
+}
+
+func (m MessageTemplateModel) get(ctx context.Context, query string, args ...any) (*MessageTemplate, error) {
+	var template MessageTemplate
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&template.ID,
+		&template.UserID,
+		&template.Name,
+		&template.Body,
+		&template.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &template, nil
+}
+
+func (m MessageTemplateModel) GetAllForUser(ctx context.Context, organizationID, userID int64) ([]*MessageTemplate, error) {
+	query := `
+		SELECT id, user_id, name, body, created_at
+		FROM message_templates
+		WHERE user_id = $1
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $2)
+		ORDER BY name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*MessageTemplate{}
+
+	for rows.Next() {
+		var template MessageTemplate
+		err := rows.Scan(&template.ID, &template.UserID, &template.Name, &template.Body, &template.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, &template)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// Update renames and rewrites a template. It returns ErrRecordNotFound if the
+// template no longer exists and ErrDuplicateTemplate if the new name is taken.
+func (m MessageTemplateModel) Update(ctx context.Context, organizationID int64, template *MessageTemplate) error {
+	query := `
+		WITH target AS (
+			SELECT id, EXISTS (
+				SELECT 1 FROM message_templates
+				WHERE user_id = $4 AND name = $1 AND id <> $3
+			) AS name_taken
+			FROM message_templates
+			WHERE id = $3 AND user_id = $4
+			AND user_id IN (SELECT id FROM users WHERE organization_id = $5)
+		), updated AS (
+			UPDATE message_templates
+			SET name = $1, body = $2
+			WHERE id = (SELECT id FROM target WHERE NOT name_taken)
+			RETURNING id
+		)
+		SELECT name_taken FROM target`
+
+	args := []any{template.Name, template.Body, template.ID, template.UserID, organizationID}
+
+	var nameTaken bool
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&nameTaken)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if nameTaken {
+		return ErrDuplicateTemplate
+	}
+	return nil
+}
+
+func (m MessageTemplateModel) Delete(ctx context.Context, organizationID, id, userID int64) error {
+	query := `
+		DELETE FROM message_templates
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
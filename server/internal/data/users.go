@@ -2,33 +2,259 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Who is allowed to start messaging a user
+const (
+	MessagePrivacyEveryone = "everyone"
+	MessagePrivacyContacts = "contacts"
+	MessagePrivacyNobody   = "nobody"
 )
 
 type User struct {
-	ID       int64  `json:"id"`
-	FullName string `json:"full_name"`
+	ID             int64      `json:"id"`
+	OrganizationID int64      `json:"organization_id"`
+	FullName       string     `json:"full_name"`
+	MessagePrivacy string     `json:"message_privacy"`
+	SuspendedAt    *time.Time `json:"suspended_at,omitempty"`
+	Password       password   `json:"-"`
+}
+
+// IsSuspended reports whether a moderator has suspended the user. Suspended
+// users cannot send messages.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// AnonymousUser is the user of requests without credentials
+var AnonymousUser = &User{}
+
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+type password struct {
+	hash []byte
+}
+
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.hash = hash
+
+	return nil
+}
+
+// Matches reports whether the plaintext password matches the stored hash.
+// Users without a password never match.
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if p.hash == nil {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
 }
 
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "Email is required")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "Must be a valid email address")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "Password is required")
+	v.Check(len(password) >= 8, "password", "Password must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "Password must not be more than 72 bytes long")
+}
+
+func ValidateMessagePrivacy(v *validator.Validator, privacy string) {
+	v.Check(validator.PermittedValue(privacy, MessagePrivacyEveryone, MessagePrivacyContacts, MessagePrivacyNobody), "message_privacy", "Must be one of everyone, contacts or nobody")
+}
+
+// Get returns a user of the organization. Users of other organizations are
+// reported as not found.
+func (m UserModel) Get(ctx context.Context, organizationID, id int64) (*User, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-	SELECT id, full_name
+	SELECT id, organization_id, full_name, message_privacy, suspended_at
+	FROM users
+	WHERE id = $1 AND organization_id = $2
+	`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, id, organizationID).Scan(&user.ID, &user.OrganizationID, &user.FullName, &user.MessagePrivacy, &user.SuspendedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetMany fetches the users of the organization with the given IDs in a single
+// query. Duplicate IDs are allowed. ErrRecordNotFound is returned if any of the
+// users does not exist in the organization.
+func (m UserModel) GetMany(ctx context.Context, organizationID int64, ids []int64) ([]*User, error) {
+	unique := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		if id < 1 {
+			return nil, ErrRecordNotFound
+		}
+		unique[id] = struct{}{}
+	}
+
+	query := `
+	SELECT id, organization_id, full_name, message_privacy, suspended_at
+	FROM users
+	WHERE id = ANY($1) AND organization_id = $2
+	ORDER BY id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, ids, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.OrganizationID, &user.FullName, &user.MessagePrivacy, &user.SuspendedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(users) != len(unique) {
+		return nil, ErrRecordNotFound
+	}
+
+	return users, nil
+}
+
+func (m UserModel) UpdateMessagePrivacy(ctx context.Context, user *User) error {
+	query := `
+	UPDATE users
+	SET message_privacy = $1
+	WHERE id = $2 AND organization_id = $3
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, user.MessagePrivacy, user.ID, user.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetByPhone looks a user up by their normalized phone number. Phone numbers
+// are unique across organizations, so this is how inbound messages from
+// external channels find the organization they are for.
+func (m UserModel) GetByPhone(ctx context.Context, phone string) (*User, error) {
+	query := `
+	SELECT id, organization_id, full_name, message_privacy, suspended_at
 	FROM users
-	WHERE id = $1
+	WHERE phone = $1
+	`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, phone).Scan(&user.ID, &user.OrganizationID, &user.FullName, &user.MessagePrivacy, &user.SuspendedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetByEmail looks a user up by email for logging in. Emails are unique across
+// organizations and are matched regardless of case.
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+	SELECT id, organization_id, full_name, message_privacy, suspended_at, password_hash
+	FROM users
+	WHERE lower(email) = lower($1)
+	`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.OrganizationID, &user.FullName, &user.MessagePrivacy, &user.SuspendedAt, &user.Password.hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetForToken returns the user holding an unexpired token of the given scope
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT u.id, u.organization_id, u.full_name, u.message_privacy, u.suspended_at
+	FROM users u
+	INNER JOIN tokens t ON t.user_id = u.id
+	WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3
 	`
 
 	var user User
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.FullName)
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], tokenScope, time.Now()).Scan(&user.ID, &user.OrganizationID, &user.FullName, &user.MessagePrivacy, &user.SuspendedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -40,3 +266,56 @@ func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
 
 	return &user, nil
 }
+
+// Delete removes a user of the organization along with everything that
+// references them
+func (m UserModel) Delete(ctx context.Context, organizationID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM users
+	WHERE id = $1 AND organization_id = $2
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Reinstate lifts the suspension of a user of the organization. Users are
+// suspended by resolving a report against them.
+func (m UserModel) Reinstate(ctx context.Context, organizationID, id int64) error {
+	query := `
+	UPDATE users
+	SET suspended_at = NULL
+	WHERE id = $1 AND organization_id = $2
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/validator"
+)
+
+// Message lifecycle events webhooks can subscribe to
+const (
+	EventMessagePersisted = "message.persisted"
+	EventMessageRead      = "message.read"
+	EventMessageDeleted   = "message.deleted"
+)
+
+var WebhookEvents = []string{
+	EventMessagePersisted,
+	EventMessageRead,
+	EventMessageDeleted,
+}
+
+// Webhook is an HTTP endpoint notified about message events in conversations
+// its owner takes part in. The secret is only ever returned when the webhook
+// is created.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "Must be a valid http or https URL")
+	v.Check(validator.MaxBytes(webhook.URL, 2048), "url", "Must not be more than 2048 bytes long")
+
+	v.Check(len(webhook.Events) > 0, "events", "At least one event is required")
+	v.Check(validator.Unique(webhook.Events), "events", "Must not contain duplicate events")
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "Must only contain message.persisted, message.read or message.deleted")
+	}
+}
+
+func (m WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, events, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{webhook.UserID, webhook.URL, webhook.Secret, webhook.Events, webhook.Active}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+func (m WebhookModel) Get(ctx context.Context, organizationID, id, userID int64) (*Webhook, error) {
+	query := `
+		SELECT id, user_id, url, events, active, created_at
+		FROM webhooks
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	var webhook Webhook
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID, organizationID).Scan(
+		&webhook.ID,
+		&webhook.UserID,
+		&webhook.URL,
+		pgArray(&webhook.Events),
+		&webhook.Active,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+func (m WebhookModel) GetAllForUser(ctx context.Context, organizationID, userID int64) ([]*Webhook, error) {
+	query := `
+		SELECT id, user_id, url, events, active, created_at
+		FROM webhooks
+		WHERE user_id = $1
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $2)
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, pgArray(&webhook.Events), &webhook.Active, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// GetSubscribed returns the active webhooks of the given users that subscribe
+// to eventType, including their secrets for signing deliveries
+func (m WebhookModel) GetSubscribed(ctx context.Context, userIDs []int64, eventType string) ([]*Webhook, error) {
+	query := `
+		SELECT id, user_id, url, secret, events, active, created_at
+		FROM webhooks
+		WHERE user_id = ANY($1) AND $2 = ANY(events) AND active`
+
+	rows, err := m.DB.QueryContext(ctx, query, userIDs, eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, pgArray(&webhook.Events), &webhook.Active, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) Update(ctx context.Context, organizationID int64, webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, events = $2, active = $3
+		WHERE id = $4 AND user_id = $5
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $6)`
+
+	args := []any{webhook.URL, webhook.Events, webhook.Active, webhook.ID, webhook.UserID, organizationID}
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m WebhookModel) Delete(ctx context.Context, organizationID, id, userID int64) error {
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1 AND user_id = $2
+		AND user_id IN (SELECT id FROM users WHERE organization_id = $3)`
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Event is a short-lived notification delivered to a user's real-time
+// connections. Unlike messages, events are never persisted and are lost if the
+// user is not connected when they are published.
+type Event struct {
+	Type      string    `json:"type"`
+	Data      any       `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bus publishes ephemeral events over Redis pub/sub. It lives beside
+// queue.MessageQueue but never writes to the durable message stream.
+type Bus struct {
+	client *redis.Client
+}
+
+func NewBus(client *redis.Client) *Bus {
+	return &Bus{client: client}
+}
+
+// Channel is the pub/sub channel a user's real-time connections subscribe to.
+// Whatever serves those connections subscribes to it; the bus only publishes.
+func Channel(userID int64) string {
+	return fmt.Sprintf("events:user:%d", userID)
+}
+
+func throttleKey(key string) string {
+	return "events:throttle:" + key
+}
+
+// Publish sends an event to each of the given users
+func (b *Bus) Publish(ctx context.Context, event Event, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	pipe := b.client.Pipeline()
+	for _, id := range userIDs {
+		pipe.Publish(ctx, Channel(id), payload)
+	}
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Allow reports whether an event identified by key may be published now. It
+// returns true at most once per window for the same key, across all instances.
+func (b *Bus) Allow(ctx context.Context, key string, window time.Duration) (bool, error) {
+	return b.client.SetNX(ctx, throttleKey(key), 1, window).Result()
+}
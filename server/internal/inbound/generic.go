@@ -0,0 +1,127 @@
+package inbound
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/webhooks"
+)
+
+// Generic accepts JSON payloads signed the same way as our outbound webhooks:
+// an HMAC-SHA256 over the X-Zoko-Timestamp header and the body, sent in the
+// X-Zoko-Signature header.
+type Generic struct {
+	Secret string
+}
+
+func (c *Generic) Name() string {
+	return data.ChannelGeneric
+}
+
+func (c *Generic) Verify(r *http.Request, body []byte) error {
+	timestamp, err := verifyTimestamp(r.Header.Get("X-Zoko-Timestamp"))
+	if err != nil {
+		return err
+	}
+
+	expected := webhooks.Sign(c.Secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Zoko-Signature"))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (c *Generic) Signature(r *http.Request) string {
+	return r.Header.Get("X-Zoko-Signature")
+}
+
+func (c *Generic) Parse(r *http.Request, body []byte) (*Message, error) {
+	var payload struct {
+		From struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"from"`
+		OrganizationID int64  `json:"organization_id"`
+		To             int64  `json:"to"`
+		Text           string `json:"text"`
+		// Timestamp is accepted for compatibility but not used, see Parse
+		Timestamp *time.Time `json:"timestamp"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+	}
+
+	if payload.From.ID == "" || payload.To < 1 {
+		return nil, fmt.Errorf("%w: from.id and to are required", ErrInvalidPayload)
+	}
+
+	if payload.OrganizationID < 0 {
+		return nil, fmt.Errorf("%w: organization_id must be a positive integer", ErrInvalidPayload)
+	}
+
+	// The organization is part of the signed payload. Payloads from before
+	// organizations existed address users of the default one.
+	if payload.OrganizationID == 0 {
+		payload.OrganizationID = data.DefaultOrganizationID
+	}
+
+	// Messages are stamped with the time they are received, so that a sender
+	// cannot place them anywhere in the conversation's history
+	return &Message{
+		ExternalID:     payload.From.ID,
+		SenderName:     payload.From.Name,
+		OrganizationID: payload.OrganizationID,
+		ToUserID:       payload.To,
+		Content:        payload.Text,
+		Timestamp:      time.Now(),
+	}, nil
+}
+
+func (c *Generic) ParseStatus(r *http.Request, body []byte) (*Status, error) {
+	var payload struct {
+		OrganizationID int64  `json:"organization_id"`
+		MessageID      string `json:"message_id"`
+		Status         string `json:"status"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+	}
+
+	if payload.MessageID == "" {
+		return nil, fmt.Errorf("%w: message_id is required", ErrInvalidPayload)
+	}
+
+	if payload.OrganizationID < 0 {
+		return nil, fmt.Errorf("%w: organization_id must be a positive integer", ErrInvalidPayload)
+	}
+
+	// Like messages, callbacks from before organizations existed are for the
+	// default one
+	if payload.OrganizationID == 0 {
+		payload.OrganizationID = data.DefaultOrganizationID
+	}
+
+	switch payload.Status {
+	case data.DeliveryStatusSent, data.DeliveryStatusDelivered, data.DeliveryStatusFailed:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidPayload, payload.Status)
+	}
+
+	return &Status{OrganizationID: payload.OrganizationID, ExternalMessageID: payload.MessageID, Status: payload.Status}, nil
+}
@@ -0,0 +1,75 @@
+package inbound
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxClockSkew bounds how far a signed timestamp may be from now, so that a
+// captured request cannot be replayed once it is older. Within that window
+// the signature itself must be remembered and rejected when it is seen again.
+const maxClockSkew = 5 * time.Minute
+
+// ReplayWindow is how long a signature stays valid: from a timestamp
+// maxClockSkew in the future until it is maxClockSkew old. Signatures must be
+// remembered for at least this long to reject replays.
+const ReplayWindow = 2 * maxClockSkew
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidPayload   = errors.New("invalid payload")
+)
+
+// Message is a message received from an external channel, before its sender
+// has been mapped to a user. Exactly one of ToUserID and ToPhone identifies the
+// internal user the message is addressed to. A user ID is looked up in
+// OrganizationID, while a phone number identifies its organization itself.
+type Message struct {
+	ExternalID     string
+	SenderName     string
+	OrganizationID int64
+	ToUserID       int64
+	ToPhone        string
+	Content        string
+	Timestamp      time.Time
+}
+
+// Status is a delivery status callback for a message we sent out through an
+// external channel. Status is one of the data.DeliveryStatus values.
+// OrganizationID is 0 for channels whose callbacks do not carry one.
+type Status struct {
+	OrganizationID    int64
+	ExternalMessageID string
+	Status            string
+}
+
+// Channel verifies and parses the requests a provider sends for one external
+// channel. The raw body is passed in because signatures are computed over it.
+// Signature returns the signature of a request, which identifies it for
+// rejecting replays. ParseStatus returns a nil Status for callbacks that carry
+// nothing to record.
+type Channel interface {
+	Name() string
+	Verify(r *http.Request, body []byte) error
+	Signature(r *http.Request) string
+	Parse(r *http.Request, body []byte) (*Message, error)
+	ParseStatus(r *http.Request, body []byte) (*Status, error)
+}
+
+// verifyTimestamp parses a signed Unix timestamp header and returns
+// ErrInvalidSignature unless it is within maxClockSkew of now
+func verifyTimestamp(header string) (int64, error) {
+	timestamp, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > maxClockSkew || age < -maxClockSkew {
+		return 0, ErrInvalidSignature
+	}
+
+	return timestamp, nil
+}
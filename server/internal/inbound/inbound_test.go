@@ -0,0 +1,130 @@
+package inbound
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/webhooks"
+)
+
+func TestGenericVerify(t *testing.T) {
+	channel := &Generic{Secret: "secret"}
+	body := []byte(`{"from":{"id":"ext-1"},"to":1,"text":"Hi"}`)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", strconv.FormatInt(now, 10), webhooks.Sign("secret", now, body), false},
+		{"wrong secret", strconv.FormatInt(now, 10), webhooks.Sign("other", now, body), true},
+		{"timestamp not signed", strconv.FormatInt(now+1, 10), webhooks.Sign("secret", now, body), true},
+		{"stale", strconv.FormatInt(now-600, 10), webhooks.Sign("secret", now-600, body), true},
+		{"in the future", strconv.FormatInt(now+600, 10), webhooks.Sign("secret", now+600, body), true},
+		{"missing timestamp", "", webhooks.Sign("secret", now, body), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/inbound/generic", strings.NewReader(string(body)))
+			r.Header.Set("X-Zoko-Timestamp", tt.timestamp)
+			r.Header.Set("X-Zoko-Signature", tt.signature)
+
+			err := channel.Verify(r, body)
+			if tt.wantErr && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("got %v; want ErrInvalidSignature", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got %v; want no error", err)
+			}
+		})
+	}
+}
+
+func TestSMSVerify(t *testing.T) {
+	channel := &SMS{Secret: "secret"}
+	path := "/v1/inbound/sms"
+	form := url.Values{"From": {"+15550001111"}, "To": {"+15550002222"}, "Body": {"Hi"}}
+	body := []byte(form.Encode())
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", strconv.FormatInt(now, 10), SignSMS("secret", now, path, form), false},
+		{"wrong path", strconv.FormatInt(now, 10), SignSMS("secret", now, "/other", form), true},
+		{"timestamp not signed", strconv.FormatInt(now+1, 10), SignSMS("secret", now, path, form), true},
+		{"stale", strconv.FormatInt(now-600, 10), SignSMS("secret", now-600, path, form), true},
+		{"missing timestamp", "", SignSMS("secret", now, path, form), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", path, strings.NewReader(string(body)))
+			r.Header.Set("X-Gateway-Timestamp", tt.timestamp)
+			r.Header.Set("X-Gateway-Signature", tt.signature)
+
+			err := channel.Verify(r, body)
+			if tt.wantErr && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("got %v; want ErrInvalidSignature", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got %v; want no error", err)
+			}
+		})
+	}
+}
+
+// TestGenericParseIgnoresTimestamp checks that messages are stamped with the
+// time they are received rather than the time the sender claims
+func TestGenericParseIgnoresTimestamp(t *testing.T) {
+	body := []byte(`{"from":{"id":"ext-1"},"to":1,"text":"Hi","timestamp":"2001-01-01T00:00:00Z"}`)
+
+	before := time.Now()
+	message, err := (&Generic{}).Parse(httptest.NewRequest("POST", "/", nil), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Timestamp.Before(before) {
+		t.Errorf("got timestamp %v; want the time of receipt", message.Timestamp)
+	}
+}
+
+// TestGenericParseStatus checks that status callbacks name the organization
+// of the message they are for, defaulting to the one that existed before
+// organizations
+func TestGenericParseStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int64
+	}{
+		{"organization given", `{"organization_id":7,"message_id":"ext-9","status":"delivered"}`, 7},
+		{"organization left out", `{"message_id":"ext-9","status":"delivered"}`, data.DefaultOrganizationID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := (&Generic{}).ParseStatus(httptest.NewRequest("POST", "/", nil), []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status.OrganizationID != tt.want || status.ExternalMessageID != "ext-9" {
+				t.Errorf("got status %+v; want organization %d and message ext-9", status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// SMS accepts form posts in the style of common SMS gateways, with From, To
+// and Body fields. The X-Gateway-Signature header carries a base64 encoded
+// HMAC-SHA256 over the Unix time in the X-Gateway-Timestamp header, the request
+// path and every form field name and value, sorted by name.
+type SMS struct {
+	Secret string
+}
+
+func (c *SMS) Name() string {
+	return data.ChannelSMS
+}
+
+// SignSMS computes the signature an SMS gateway sends for the given timestamp,
+// path and form values
+func SignSMS(secret string, timestamp int64, path string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(strconv.FormatInt(timestamp, 10))
+	b.WriteString(path)
+	for _, key := range keys {
+		for _, value := range form[key] {
+			b.WriteString(key)
+			b.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *SMS) Verify(r *http.Request, body []byte) error {
+	timestamp, err := verifyTimestamp(r.Header.Get("X-Gateway-Timestamp"))
+	if err != nil {
+		return err
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected := SignSMS(c.Secret, timestamp, r.URL.Path, form)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Gateway-Signature"))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (c *SMS) Signature(r *http.Request) string {
+	return r.Header.Get("X-Gateway-Signature")
+}
+
+func (c *SMS) Parse(r *http.Request, body []byte) (*Message, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+	}
+
+	from := form.Get("From")
+	to := form.Get("To")
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("%w: From and To are required", ErrInvalidPayload)
+	}
+
+	return &Message{
+		ExternalID: from,
+		ToPhone:    to,
+		Content:    form.Get("Body"),
+		Timestamp:  time.Now(),
+	}, nil
+}
+
+// smsStatuses maps the message statuses SMS gateways report to ours. Statuses
+// that are neither final nor a send confirmation, like "queued", are ignored.
+var smsStatuses = map[string]string{
+	"sent":        data.DeliveryStatusSent,
+	"delivered":   data.DeliveryStatusDelivered,
+	"failed":      data.DeliveryStatusFailed,
+	"undelivered": data.DeliveryStatusFailed,
+}
+
+func (c *SMS) ParseStatus(r *http.Request, body []byte) (*Status, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+	}
+
+	id := form.Get("MessageSid")
+	if id == "" {
+		return nil, fmt.Errorf("%w: MessageSid is required", ErrInvalidPayload)
+	}
+
+	status, ok := smsStatuses[strings.ToLower(form.Get("MessageStatus"))]
+	if !ok {
+		return nil, nil
+	}
+
+	return &Status{ExternalMessageID: id, Status: status}, nil
+}
@@ -0,0 +1,164 @@
+package moderation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/textmatch"
+	"github.com/redis/go-redis/v9"
+)
+
+// countDistinct adds member to a sliding window set and returns how many
+// distinct members it has seen within the window
+func countDistinct(ctx context.Context, client *redis.Client, key, member string, window time.Duration) (int64, error) {
+	now := time.Now()
+
+	pipe := client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+	count := pipe.ZCard(ctx, key)
+	pipe.PExpire(ctx, key, window)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return count.Val(), nil
+}
+
+// NewReceiverRate limits how many people a sender can start conversations with
+// per window, which is what bulk spam from fresh accounts looks like. Receivers
+// the sender has talked to before do not count.
+type NewReceiverRate struct {
+	Client *redis.Client
+	Models data.Models
+	Limit  int
+	Window time.Duration
+}
+
+func (f *NewReceiverRate) Name() string {
+	return "new_receiver_rate"
+}
+
+func (f *NewReceiverRate) Check(ctx context.Context, message *data.Message) (*Violation, error) {
+	known, err := f.Models.Messages.HasConversation(ctx, message.OrganizationID, message.SenderID, message.ReceiverID)
+	if err != nil {
+		return nil, err
+	}
+	if known {
+		return nil, nil
+	}
+
+	key := fmt.Sprintf("spam:new_receivers:%d", message.SenderID)
+
+	count, err := countDistinct(ctx, f.Client, key, strconv.FormatInt(message.ReceiverID, 10), f.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > int64(f.Limit) {
+		return &Violation{
+			Filter: f.Name(),
+			Reason: fmt.Sprintf("messaged more than %d new receivers within %s", f.Limit, f.Window),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// DuplicateContent catches a sender pasting the same text to many receivers.
+// Content is compared after folding case and whitespace. Broadcasts are the
+// supported way to reach many people with one message, so their messages are
+// not counted here, although every other filter still checks them.
+type DuplicateContent struct {
+	Client *redis.Client
+	Limit  int
+	Window time.Duration
+}
+
+func (f *DuplicateContent) Name() string {
+	return "duplicate_content"
+}
+
+func (f *DuplicateContent) Check(ctx context.Context, message *data.Message) (*Violation, error) {
+	if message.BroadcastID != nil {
+		return nil, nil
+	}
+
+	normalized := strings.Join(strings.Fields(strings.ToLower(message.Content)), " ")
+	hash := sha256.Sum256([]byte(normalized))
+
+	key := fmt.Sprintf("spam:content:%d:%s", message.SenderID, hex.EncodeToString(hash[:16]))
+
+	count, err := countDistinct(ctx, f.Client, key, strconv.FormatInt(message.ReceiverID, 10), f.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > int64(f.Limit) {
+		return &Violation{
+			Filter: f.Name(),
+			Reason: fmt.Sprintf("sent the same content to more than %d receivers within %s", f.Limit, f.Window),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+var linkRX = regexp.MustCompile(`(?i)\b(?:https?://)?((?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63})(?:[:/?#]\S*)?`)
+
+// LinkBlocklist quarantines messages linking to blocked domains or any of their
+// subdomains
+type LinkBlocklist struct {
+	Domains []string
+}
+
+func (f *LinkBlocklist) Name() string {
+	return "link_blocklist"
+}
+
+func (f *LinkBlocklist) Check(ctx context.Context, message *data.Message) (*Violation, error) {
+	for _, match := range linkRX.FindAllStringSubmatch(message.Content, -1) {
+		host := strings.ToLower(match[1])
+
+		for _, domain := range f.Domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return &Violation{
+					Filter: f.Name(),
+					Reason: "links to blocked domain " + domain,
+				}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// KeywordBlocklist quarantines messages containing any of the keywords as whole
+// words, ignoring case
+type KeywordBlocklist struct {
+	Keywords []string
+}
+
+func (f *KeywordBlocklist) Name() string {
+	return "keyword_blocklist"
+}
+
+func (f *KeywordBlocklist) Check(ctx context.Context, message *data.Message) (*Violation, error) {
+	if textmatch.MatchKeywords(f.Keywords, message.Content) {
+		return &Violation{
+			Filter: f.Name(),
+			Reason: "contains a blocked keyword",
+		}, nil
+	}
+
+	return nil, nil
+}
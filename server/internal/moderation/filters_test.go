@@ -0,0 +1,181 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLinkBlocklist(t *testing.T) {
+	f := &LinkBlocklist{Domains: []string{"spam.example", "bad.test"}}
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"bare domain", "visit spam.example now", true},
+		{"with scheme", "https://spam.example/offer", true},
+		{"with port and query", "http://spam.example:8080/?a=b", true},
+		{"subdomain", "see www.spam.example", true},
+		{"nested subdomain", "https://a.b.spam.example/x", true},
+		{"ignores case", "HTTPS://WWW.Spam.Example", true},
+		{"second domain", "bad.test#top", true},
+		{"any link in the message", "ok.example and spam.example", true},
+		{"suffix without a dot", "notspam.example", false},
+		{"blocked name as a subdomain", "spam.example.org", false},
+		{"other domain", "https://good.example/spam.example", false},
+		{"no link", "hello there", false},
+		{"empty content", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violation, err := f.Check(context.Background(), &data.Message{Content: tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := violation != nil; got != tt.want {
+				t.Errorf("Check(%q) quarantined = %t; want %t", tt.content, got, tt.want)
+			}
+			if violation != nil && violation.Filter != f.Name() {
+				t.Errorf("got filter %q; want %q", violation.Filter, f.Name())
+			}
+		})
+	}
+}
+
+func TestKeywordBlocklist(t *testing.T) {
+	f := &KeywordBlocklist{Keywords: []string{"lottery", "wire transfer"}}
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"keyword", "You won the lottery", true},
+		{"ignores case", "LOTTERY winner", true},
+		{"phrase", "send a wire   transfer today", true},
+		{"part of a word", "lotteryland", false},
+		{"no keyword", "See you tomorrow", false},
+		{"empty content", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violation, err := f.Check(context.Background(), &data.Message{Content: tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := violation != nil; got != tt.want {
+				t.Errorf("Check(%q) quarantined = %t; want %t", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestRedis connects to the Redis server in IM_TEST_REDIS_ADDR and skips
+// the test unless it is set. Each test uses its own sender ID so that counters
+// from earlier runs do not interfere.
+func newTestRedis(t *testing.T) (*redis.Client, int64) {
+	t.Helper()
+
+	addr := os.Getenv("IM_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("IM_TEST_REDIS_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+
+	return client, time.Now().UnixNano()
+}
+
+func TestCountDistinct(t *testing.T) {
+	client, id := newTestRedis(t)
+	ctx := context.Background()
+
+	key := "test:moderation:count:" + strconv.FormatInt(id, 10)
+	defer client.Del(ctx, key)
+
+	window := 200 * time.Millisecond
+
+	for i, member := range []string{"a", "b", "a", "c"} {
+		count, err := countDistinct(ctx, client, key, member, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []int64{1, 2, 2, 3}[i]
+		if count != want {
+			t.Errorf("got count %d after adding %q; want %d", count, member, want)
+		}
+	}
+
+	// Members older than the window are dropped
+	time.Sleep(window + 50*time.Millisecond)
+
+	count, err := countDistinct(ctx, client, key, "d", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got count %d after the window passed; want 1", count)
+	}
+}
+
+func TestDuplicateContent(t *testing.T) {
+	client, senderID := newTestRedis(t)
+	ctx := context.Background()
+
+	f := &DuplicateContent{Client: client, Limit: 2, Window: time.Minute}
+
+	send := func(receiverID int64, content string, broadcastID *int64) *Violation {
+		t.Helper()
+
+		violation, err := f.Check(ctx, &data.Message{
+			SenderID:    senderID,
+			ReceiverID:  receiverID,
+			Content:     content,
+			BroadcastID: broadcastID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return violation
+	}
+
+	if send(1, "Buy now", nil) != nil || send(2, "buy   NOW", nil) != nil {
+		t.Fatal("got violation within the limit")
+	}
+
+	// Sending again to the same receiver is not a new receiver
+	if send(2, "Buy now", nil) != nil {
+		t.Error("got violation for a repeated receiver")
+	}
+
+	// Broadcasts are exempt and do not count towards the limit
+	broadcastID := int64(1)
+	for receiverID := int64(10); receiverID < 15; receiverID++ {
+		if send(receiverID, "Buy now", &broadcastID) != nil {
+			t.Fatal("got violation for a broadcast message")
+		}
+	}
+
+	if send(3, "Something else", nil) != nil {
+		t.Error("got violation for different content")
+	}
+
+	violation := send(3, "Buy now", nil)
+	if violation == nil {
+		t.Fatal("got no violation over the limit")
+	}
+	if violation.Filter != f.Name() {
+		t.Errorf("got filter %q; want %q", violation.Filter, f.Name())
+	}
+}
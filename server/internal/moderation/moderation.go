@@ -0,0 +1,44 @@
+package moderation
+
+import (
+	"context"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// Events published to an organization's moderators
+const (
+	EventQuarantined = "message.quarantined"
+	EventReported    = "message.reported"
+)
+
+// Violation explains why a filter stopped a message
+type Violation struct {
+	Filter string `json:"filter"`
+	Reason string `json:"reason"`
+}
+
+// Filter inspects a message before it is enqueued. It returns a violation if
+// the message should be quarantined instead of delivered, or nil.
+type Filter interface {
+	Name() string
+	Check(ctx context.Context, message *data.Message) (*Violation, error)
+}
+
+// Chain runs filters in order and stops at the first violation. Filters that
+// keep counters only count the messages that reach them.
+type Chain []Filter
+
+func (c Chain) Check(ctx context.Context, message *data.Message) (*Violation, error) {
+	for _, filter := range c {
+		violation, err := filter.Check(ctx, message)
+		if err != nil {
+			return nil, err
+		}
+		if violation != nil {
+			return violation, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,91 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// stubFilter returns a fixed result and counts how often it was asked
+type stubFilter struct {
+	name      string
+	violation *Violation
+	err       error
+	calls     int
+}
+
+func (f *stubFilter) Name() string {
+	return f.name
+}
+
+func (f *stubFilter) Check(ctx context.Context, message *data.Message) (*Violation, error) {
+	f.calls++
+	return f.violation, f.err
+}
+
+func TestChain(t *testing.T) {
+	errFilter := errors.New("filter failed")
+
+	tests := []struct {
+		name       string
+		results    []stubFilter
+		wantFilter string
+		wantErr    error
+		wantCalls  []int
+	}{
+		{
+			name:      "no filters",
+			results:   nil,
+			wantCalls: nil,
+		},
+		{
+			name:      "all pass",
+			results:   []stubFilter{{name: "a"}, {name: "b"}},
+			wantCalls: []int{1, 1},
+		},
+		{
+			name:       "first violation stops the chain",
+			results:    []stubFilter{{name: "a"}, {name: "b", violation: &Violation{Filter: "b"}}, {name: "c", violation: &Violation{Filter: "c"}}},
+			wantFilter: "b",
+			wantCalls:  []int{1, 1, 0},
+		},
+		{
+			name:      "error stops the chain",
+			results:   []stubFilter{{name: "a", err: errFilter}, {name: "b", violation: &Violation{Filter: "b"}}},
+			wantErr:   errFilter,
+			wantCalls: []int{1, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chain Chain
+			for i := range tt.results {
+				chain = append(chain, &tt.results[i])
+			}
+
+			violation, err := chain.Check(context.Background(), &data.Message{Content: "hello"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if err != nil && violation != nil {
+				t.Errorf("got violation %v with an error; want nil", violation)
+			}
+
+			switch {
+			case tt.wantFilter == "" && violation != nil:
+				t.Errorf("got violation from %q; want none", violation.Filter)
+			case tt.wantFilter != "" && (violation == nil || violation.Filter != tt.wantFilter):
+				t.Errorf("got violation %v; want one from %q", violation, tt.wantFilter)
+			}
+
+			for i, want := range tt.wantCalls {
+				if got := tt.results[i].calls; got != want {
+					t.Errorf("got %d calls to %q; want %d", got, tt.results[i].name, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Package policy decides who may message whom. The API checks it when a
+// message is sent, and the worker checks it again for messages it sends on a
+// user's behalf, such as auto replies and scheduled messages.
+package policy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+var (
+	ErrSenderBlockedReceiver = errors.New("sender has blocked the receiver")
+	ErrReceiverBlockedSender = errors.New("receiver has blocked the sender")
+	ErrMessagingRestricted   = errors.New("receiver does not accept messages from the sender")
+	ErrSenderSuspended       = errors.New("sender is suspended")
+)
+
+// CanSend reports ErrSenderSuspended if moderators have suspended the sender
+func CanSend(sender *data.User) error {
+	if sender.IsSuspended() {
+		return ErrSenderSuspended
+	}
+	return nil
+}
+
+// CanMessage enforces blocks and the receiver's privacy settings. It has to
+// pass before a message from senderID to receiver is enqueued.
+func CanMessage(ctx context.Context, models data.Models, senderID int64, receiver *data.User) error {
+	if senderID == receiver.ID {
+		return nil
+	}
+
+	blocked, blockedBy, err := models.Blocks.Between(ctx, senderID, receiver.ID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case blocked:
+		return ErrSenderBlockedReceiver
+	case blockedBy:
+		return ErrReceiverBlockedSender
+	}
+
+	switch receiver.MessagePrivacy {
+	case data.MessagePrivacyNobody:
+		return ErrMessagingRestricted
+	case data.MessagePrivacyContacts:
+		known, err := models.Contacts.IsContact(ctx, receiver.ID, senderID)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return ErrMessagingRestricted
+		}
+	}
+
+	return nil
+}
+
+// IsDenied reports whether err is one of the policy's refusals, as opposed to
+// a failure to check it
+func IsDenied(err error) bool {
+	return errors.Is(err, ErrSenderBlockedReceiver) ||
+		errors.Is(err, ErrReceiverBlockedSender) ||
+		errors.Is(err, ErrMessagingRestricted) ||
+		errors.Is(err, ErrSenderSuspended)
+}
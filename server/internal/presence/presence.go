@@ -0,0 +1,182 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+type Config struct {
+	// StatusTTL is how long a user stays online or away without further
+	// activity or heartbeats before they are considered offline
+	StatusTTL time.Duration
+	// LastSeenRetention is how long the last seen timestamp is kept
+	LastSeenRetention time.Duration
+}
+
+type Presence struct {
+	UserID     int64      `json:"user_id"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// Tracker keeps per-user presence in Redis so that it is shared by every API
+// instance. Statuses live in keys that expire after StatusTTL, which is what
+// turns an inactive user offline. Every user with a status is also kept in a
+// sorted set scored by when their status expires, so that Expire can find the
+// users who went offline that way.
+type Tracker struct {
+	client *redis.Client
+	config Config
+}
+
+func NewTracker(client *redis.Client, config Config) *Tracker {
+	return &Tracker{
+		client: client,
+		config: config,
+	}
+}
+
+const statusKeyPrefix = "presence:status:"
+
+func statusKey(userID int64) string {
+	return statusKeyPrefix + strconv.FormatInt(userID, 10)
+}
+
+func lastSeenKey(userID int64) string {
+	return fmt.Sprintf("presence:last_seen:%d", userID)
+}
+
+// expiriesKey is the sorted set of users with a status, scored by the Unix
+// time in milliseconds at which their status expires
+const expiriesKey = "presence:expiries"
+
+// expireScript removes up to ARGV[2] users whose status was due to expire by
+// ARGV[1] from the sorted set in KEYS[1] and returns them. Users whose status
+// key, named by ARGV[3] and the user ID, still exists are left for a later
+// run. Removing members one at a time, only while their score is still due,
+// keeps a user who came back online in between from being reported offline.
+var expireScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+local expired = {}
+for _, member in ipairs(due) do
+	if redis.call('EXISTS', ARGV[3] .. member) == 0 then
+		redis.call('ZREM', KEYS[1], member)
+		table.insert(expired, member)
+	end
+end
+return expired
+`)
+
+func ValidStatus(status string) bool {
+	return status == StatusOnline || status == StatusAway || status == StatusOffline
+}
+
+// Update records activity for a user and reports whether their status changed.
+// Going offline clears the status straight away instead of waiting for it to
+// expire.
+func (t *Tracker) Update(ctx context.Context, userID int64, status string) (*Presence, bool, error) {
+	now := time.Now()
+
+	member := strconv.FormatInt(userID, 10)
+
+	// GETDEL and SET with GET both reply with the previous status
+	var previous interface{ Val() string }
+
+	_, err := t.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if status == StatusOffline {
+			previous = pipe.GetDel(ctx, statusKey(userID))
+			pipe.ZRem(ctx, expiriesKey, member)
+		} else {
+			previous = pipe.SetArgs(ctx, statusKey(userID), status, redis.SetArgs{TTL: t.config.StatusTTL, Get: true})
+			pipe.ZAdd(ctx, expiriesKey, redis.Z{
+				Score:  float64(now.Add(t.config.StatusTTL).UnixMilli()),
+				Member: member,
+			})
+		}
+		pipe.Set(ctx, lastSeenKey(userID), now.UnixMilli(), t.config.LastSeenRetention)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
+
+	previousStatus := previous.Val()
+	if previousStatus == "" {
+		previousStatus = StatusOffline
+	}
+
+	return &Presence{UserID: userID, Status: status, LastSeenAt: &now}, previousStatus != status, nil
+}
+
+// Get returns the presence of each of the given users, in the same order
+func (t *Tracker) Get(ctx context.Context, userIDs ...int64) ([]*Presence, error) {
+	if len(userIDs) == 0 {
+		return []*Presence{}, nil
+	}
+
+	keys := make([]string, 0, 2*len(userIDs))
+	for _, id := range userIDs {
+		keys = append(keys, statusKey(id), lastSeenKey(id))
+	}
+
+	values, err := t.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	presences := make([]*Presence, 0, len(userIDs))
+
+	for i, id := range userIDs {
+		p := &Presence{UserID: id, Status: StatusOffline}
+
+		if status, ok := values[2*i].(string); ok {
+			p.Status = status
+		}
+
+		if lastSeen, ok := values[2*i+1].(string); ok {
+			ms, err := strconv.ParseInt(lastSeen, 10, 64)
+			if err == nil {
+				t := time.UnixMilli(ms).UTC()
+				p.LastSeenAt = &t
+			}
+		}
+
+		presences = append(presences, p)
+	}
+
+	return presences, nil
+}
+
+// Expire returns the presence of up to limit users whose status has expired
+// since it was last updated. Each user is only returned once, to whichever
+// caller finds them first.
+func (t *Tracker) Expire(ctx context.Context, limit int) ([]*Presence, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	members, err := expireScript.Run(ctx, t.client, []string{expiriesKey}, now, limit, statusKeyPrefix).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int64, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return t.Get(ctx, userIDs...)
+}
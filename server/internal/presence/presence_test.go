@@ -0,0 +1,87 @@
+package presence
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TestExpire checks that users whose status expired are reported offline
+// exactly once, and that users who went offline explicitly or are still active
+// are not reported. It needs the Redis server in IM_TEST_REDIS_ADDR and is
+// skipped unless it is set.
+func TestExpire(t *testing.T) {
+	addr := os.Getenv("IM_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("IM_TEST_REDIS_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	ctx := context.Background()
+
+	short := NewTracker(client, Config{StatusTTL: 100 * time.Millisecond, LastSeenRetention: time.Minute})
+	long := NewTracker(client, Config{StatusTTL: time.Minute, LastSeenRetention: time.Minute})
+
+	base := time.Now().UnixNano()
+	expired, active, loggedOut := base, base+1, base+2
+	defer func() {
+		for _, id := range []int64{expired, active, loggedOut} {
+			client.Del(ctx, statusKey(id), lastSeenKey(id))
+			client.ZRem(ctx, expiriesKey, id)
+		}
+	}()
+
+	updates := []struct {
+		tracker *Tracker
+		userID  int64
+		status  string
+	}{
+		{short, expired, StatusOnline},
+		{long, active, StatusAway},
+		{short, loggedOut, StatusOnline},
+		{short, loggedOut, StatusOffline},
+	}
+	for _, u := range updates {
+		_, _, err := u.tracker.Update(ctx, u.userID, u.status)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	expire := func() []int64 {
+		t.Helper()
+
+		presences, err := short.Expire(ctx, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		for _, p := range presences {
+			if p.UserID < base || p.UserID > loggedOut {
+				continue
+			}
+			if p.Status != StatusOffline || p.LastSeenAt == nil {
+				t.Errorf("got presence %+v for user %d; want offline with a last seen time", p, p.UserID)
+			}
+			ids = append(ids, p.UserID)
+		}
+		return ids
+	}
+
+	if got := expire(); !slices.Equal(got, []int64{expired}) {
+		t.Errorf("got expired users %v; want [%d]", got, expired)
+	}
+
+	if got := expire(); len(got) != 0 {
+		t.Errorf("got expired users %v on the second run; want none", got)
+	}
+}
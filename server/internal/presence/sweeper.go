@@ -0,0 +1,81 @@
+package presence
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/events"
+	"github.com/redis/go-redis/v9"
+)
+
+type SweeperConfig struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Sweeper tells the people who have a user in their contacts when that user
+// goes offline because their status expired. Users who go offline explicitly
+// are announced by the API as their status changes.
+type Sweeper struct {
+	tracker *Tracker
+	config  SweeperConfig
+	events  *events.Bus
+	logger  *slog.Logger
+	models  data.Models
+}
+
+func NewSweeper(client *redis.Client, config SweeperConfig, bus *events.Bus, logger *slog.Logger, models data.Models) *Sweeper {
+	return &Sweeper{
+		tracker: NewTracker(client, Config{}),
+		config:  config,
+		events:  bus,
+		logger:  logger,
+		models:  models,
+	}
+}
+
+// Run periodically publishes a presence event for every user whose status
+// expired, until the context is cancelled
+func (s *Sweeper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	s.logger.Info("presence sweeper started", "interval", s.config.Interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			for {
+				presences, err := s.tracker.Expire(ctx, s.config.BatchSize)
+				if err != nil {
+					s.logger.Error("failed to expire presence", "error", err)
+					break
+				}
+
+				for _, p := range presences {
+					err := s.notify(ctx, p)
+					if err != nil {
+						s.logger.Error("failed to publish presence", "error", err, "user_id", p.UserID)
+					}
+				}
+
+				if len(presences) < s.config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (s *Sweeper) notify(ctx context.Context, p *Presence) error {
+	watcherIDs, err := s.models.Contacts.GetOwnerIDs(ctx, p.UserID)
+	if err != nil {
+		return err
+	}
+
+	return s.events.Publish(ctx, events.Event{Type: "presence", Data: p}, watcherIDs...)
+}
@@ -0,0 +1,15 @@
+package queue
+
+import "expvar"
+
+// Queue metrics are published through expvar and keyed by stream name
+var (
+	streamLength  = expvar.NewMap("queue_stream_length")
+	streamTrimmed = expvar.NewMap("queue_stream_trimmed_total")
+)
+
+func setStreamLength(key string, length int64) {
+	v := new(expvar.Int)
+	v.Set(length)
+	streamLength.Set(key, v)
+}
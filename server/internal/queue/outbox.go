@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+)
+
+// Enqueuer accepts messages for asynchronous persistence by the worker.
+// EnqueueMessages accepts either all of the messages or none of them.
+type Enqueuer interface {
+	EnqueueMessage(ctx context.Context, message *data.Message) error
+	EnqueueMessages(ctx context.Context, messages []*data.Message) error
+}
+
+type OutboxConfig struct {
+	RelayInterval time.Duration
+	BatchSize     int
+	Retention     time.Duration
+}
+
+// Outbox writes messages to Postgres instead of Redis. A relay then publishes
+// the pending rows to the message stream, so accepted messages survive a Redis
+// outage.
+type Outbox struct {
+	queue  *MessageQueue
+	config OutboxConfig
+	logger *slog.Logger
+	models data.Models
+}
+
+func NewOutbox(queue *MessageQueue, config OutboxConfig, logger *slog.Logger, models data.Models) *Outbox {
+	return &Outbox{
+		queue:  queue,
+		config: config,
+		logger: logger,
+		models: models,
+	}
+}
+
+// EnqueueMessage records the message in the outbox table
+func (o *Outbox) EnqueueMessage(ctx context.Context, message *data.Message) error {
+	return o.models.Outbox.Insert(ctx, message)
+}
+
+func (o *Outbox) EnqueueMessages(ctx context.Context, messages []*data.Message) error {
+	return o.models.Outbox.BulkInsert(ctx, messages)
+}
+
+// Relay publishes pending outbox rows to the message stream until the context
+// is cancelled
+func (o *Outbox) Relay(ctx context.Context) error {
+	ticker := time.NewTicker(o.config.RelayInterval)
+	defer ticker.Stop()
+
+	o.logger.Info("outbox relay started", "interval", o.config.RelayInterval, "batch size", o.config.BatchSize)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			o.relayBatches(ctx)
+			o.purgeSent(ctx)
+		}
+	}
+}
+
+// relayBatches keeps relaying while full batches are being published so that a
+// backlog built up during a Redis outage drains quickly
+func (o *Outbox) relayBatches(ctx context.Context) {
+	for {
+		sent, err := o.models.Outbox.RelayPending(ctx, o.config.BatchSize, o.queue.EnqueueMessage)
+		if sent > 0 {
+			o.logger.Info("outbox batch relayed", "count", sent)
+		}
+		if err != nil {
+			o.logger.Error("failed to relay outbox", "error", err)
+			return
+		}
+		if sent < o.config.BatchSize {
+			return
+		}
+	}
+}
+
+func (o *Outbox) purgeSent(ctx context.Context) {
+	if o.config.Retention <= 0 {
+		return
+	}
+
+	deleted, err := o.models.Outbox.DeleteSentBefore(ctx, time.Now().Add(-o.config.Retention))
+	if err != nil {
+		o.logger.Error("failed to purge sent outbox rows", "error", err)
+		return
+	}
+	if deleted > 0 {
+		o.logger.Info("sent outbox rows purged", "count", deleted)
+	}
+}
@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// ReapExpired periodically hard-deletes messages whose disappearing timer has
+// run out, in batches, until the context is cancelled. Expired messages are
+// already hidden from reads, so this only reclaims storage.
+func (q *MessageQueue) ReapExpired(ctx context.Context) error {
+	ticker := time.NewTicker(q.config.ReapInterval)
+	defer ticker.Stop()
+
+	q.logger.Info("expired message reaper started", "interval", q.config.ReapInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			for {
+				deleted, err := q.models.Messages.DeleteExpired(ctx, q.config.BatchSize)
+				if err != nil {
+					q.logger.Error("failed to delete expired messages", "error", err)
+					break
+				}
+				if deleted > 0 {
+					q.logger.Info("expired messages deleted", "count", deleted)
+				}
+				if deleted < int64(q.config.BatchSize) {
+					break
+				}
+			}
+		}
+	}
+}
@@ -3,7 +3,9 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"strconv"
 	"time"
 
 	"github.com/araaavind/zoko-im/internal/data"
@@ -18,16 +20,24 @@ type Config struct {
 	MaxRetries       int
 	RetryDelay       time.Duration
 	BatchSize        int
-	dlq              struct {
-		key string
-	}
+	DLQKey           string
+	StreamTrim       TrimPolicy
+	DLQTrim          TrimPolicy
+	TrimInterval     time.Duration
+	ScheduleInterval time.Duration
+	ReapInterval     time.Duration
 }
 
+// PersistHook is called by the worker with messages right after they have been
+// written to Postgres
+type PersistHook func(ctx context.Context, messages []*data.Message)
+
 type MessageQueue struct {
 	client *redis.Client
 	config Config
 	logger *slog.Logger
 	models data.Models
+	hooks  []PersistHook
 }
 
 func NewMessageQueue(client *redis.Client, config Config, logger *slog.Logger, models data.Models) *MessageQueue {
@@ -39,29 +49,99 @@ func NewMessageQueue(client *redis.Client, config Config, logger *slog.Logger, m
 	}
 }
 
-// EnqueueMessage adds a message to the Redis stream
-func (q *MessageQueue) EnqueueMessage(ctx context.Context, message *data.Message) error {
-	messageJSON, err := json.Marshal(message)
+// OnPersist registers a hook that runs after each successfully persisted batch
+func (q *MessageQueue) OnPersist(hook PersistHook) {
+	q.hooks = append(q.hooks, hook)
+}
+
+func (q *MessageQueue) runPersistHooks(ctx context.Context, messages []*data.Message) {
+	for _, hook := range q.hooks {
+		hook(ctx, messages)
+	}
+}
+
+// streamKey returns the stream that holds an organization's messages. Each
+// organization gets its own stream so that a burst from one of them cannot
+// delay everyone else's messages. Messages without an organization go to the
+// base stream.
+func (q *MessageQueue) streamKey(organizationID int64) string {
+	if organizationID == 0 {
+		return q.config.StreamKey
+	}
+	return fmt.Sprintf("%s:%d", q.config.StreamKey, organizationID)
+}
+
+// organizationsKey is the set of organizations that have a message stream
+func (q *MessageQueue) organizationsKey() string {
+	return q.config.StreamKey + ":organizations"
+}
+
+// streamKeys returns the base stream followed by every organization's stream
+func (q *MessageQueue) streamKeys(ctx context.Context) ([]string, error) {
+	members, err := q.client.SMembers(ctx, q.organizationsKey()).Result()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(members)+1)
+	keys = append(keys, q.config.StreamKey)
+
+	for _, member := range members {
+		organizationID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil || organizationID < 1 {
+			continue
+		}
+		keys = append(keys, q.streamKey(organizationID))
 	}
 
-	return q.client.XAdd(ctx, &redis.XAddArgs{
-		Stream: q.config.StreamKey,
-		Values: map[string]any{
-			"message": string(messageJSON),
-		},
-	}).Err()
+	return keys, nil
 }
 
-// ProcessMessages starts a worker to process messages from the stream
-func (q *MessageQueue) ProcessMessages(ctx context.Context) error {
-	// Create consumer group if it doesn't exist
-	err := q.client.XGroupCreateMkStream(ctx, q.config.StreamKey, q.config.ConsumerGroup, "0").Err()
-	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
-		return err
+// EnqueueMessage adds a message to its organization's Redis stream
+func (q *MessageQueue) EnqueueMessage(ctx context.Context, message *data.Message) error {
+	return q.EnqueueMessages(ctx, []*data.Message{message})
+}
+
+// EnqueueMessages adds messages to their organizations' Redis streams in a
+// single transaction
+func (q *MessageQueue) EnqueueMessages(ctx context.Context, messages []*data.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	payloads := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageJSON, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, string(messageJSON))
 	}
 
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, message := range messages {
+			if message.OrganizationID != 0 {
+				pipe.SAdd(ctx, q.organizationsKey(), message.OrganizationID)
+			}
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: q.streamKey(message.OrganizationID),
+				Values: map[string]any{
+					"message": payloads[i],
+				},
+			})
+		}
+		return nil
+	})
+	return err
+}
+
+// ProcessMessages starts a worker to process messages from the streams of all
+// organizations. Every read takes up to a batch from each stream, so
+// organizations are served in turn however busy one of them is.
+func (q *MessageQueue) ProcessMessages(ctx context.Context) error {
+	// Consumer groups are created as streams are discovered
+	groups := make(map[string]bool)
+
 	q.logger.Info(
 		"message worker started",
 		"group", q.config.ConsumerGroup,
@@ -74,11 +154,41 @@ func (q *MessageQueue) ProcessMessages(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			// Read a batch of messages
+			keys, err := q.streamKeys(ctx)
+			if err != nil {
+				q.logger.Error("failed to list message streams", "error", err)
+				time.Sleep(q.config.RetryDelay)
+				continue
+			}
+
+			readable := make([]string, 0, len(keys))
+			for _, key := range keys {
+				if !groups[key] {
+					err := q.createGroup(ctx, key)
+					if err != nil {
+						q.logger.Error("failed to create consumer group", "stream", key, "error", err)
+						continue
+					}
+					groups[key] = true
+				}
+				readable = append(readable, key)
+			}
+
+			if len(readable) == 0 {
+				time.Sleep(q.config.RetryDelay)
+				continue
+			}
+
+			ids := make([]string, len(readable))
+			for i := range ids {
+				ids[i] = ">"
+			}
+
+			// Read a batch of messages from every stream
 			streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
 				Group:    q.config.ConsumerGroup,
 				Consumer: q.config.ConsumerName,
-				Streams:  []string{q.config.StreamKey, ">"},
+				Streams:  append(readable, ids...),
 				Block:    q.config.BlockingDuration,
 				Count:    int64(q.config.BatchSize),
 			}).Result()
@@ -91,74 +201,91 @@ func (q *MessageQueue) ProcessMessages(ctx context.Context) error {
 				continue
 			}
 
-			if len(streams) == 0 || len(streams[0].Messages) == 0 {
-				continue
+			for _, stream := range streams {
+				if len(stream.Messages) > 0 {
+					q.processBatch(ctx, stream.Stream, stream.Messages)
+				}
 			}
+		}
+	}
+}
 
-			messages := make([]*data.Message, 0, len(streams[0].Messages))
-			messageIDs := make([]string, 0, len(streams[0].Messages))
+func (q *MessageQueue) createGroup(ctx context.Context, key string) error {
+	err := q.client.XGroupCreateMkStream(ctx, key, q.config.ConsumerGroup, "0").Err()
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return err
+	}
+	return nil
+}
 
-			// Parse messages
-			for _, redisMsg := range streams[0].Messages {
-				messageJSON, ok := redisMsg.Values["message"].(string)
-				if !ok {
-					q.logger.Error("invalid message format", "message_id", redisMsg.ID)
-					q.client.XAck(ctx, q.config.StreamKey, q.config.ConsumerGroup, redisMsg.ID)
-					continue
-				}
+// processBatch persists a batch read from one stream and acknowledges it
+func (q *MessageQueue) processBatch(ctx context.Context, key string, entries []redis.XMessage) {
+	messages := make([]*data.Message, 0, len(entries))
+	messageIDs := make([]string, 0, len(entries))
 
-				var msg data.Message
-				err := json.Unmarshal([]byte(messageJSON), &msg)
-				if err != nil {
-					q.logger.Error("failed to unmarshal message", "error", err, "message_id", redisMsg.ID)
-					q.client.XAck(ctx, q.config.StreamKey, q.config.ConsumerGroup, redisMsg.ID)
-					continue
-				}
+	// Parse messages
+	for _, redisMsg := range entries {
+		messageJSON, ok := redisMsg.Values["message"].(string)
+		if !ok {
+			q.logger.Error("invalid message format", "message_id", redisMsg.ID)
+			q.client.XAck(ctx, key, q.config.ConsumerGroup, redisMsg.ID)
+			continue
+		}
 
-				messages = append(messages, &msg)
-				messageIDs = append(messageIDs, redisMsg.ID)
-			}
+		var msg data.Message
+		err := json.Unmarshal([]byte(messageJSON), &msg)
+		if err != nil {
+			q.logger.Error("failed to unmarshal message", "error", err, "message_id", redisMsg.ID)
+			q.client.XAck(ctx, key, q.config.ConsumerGroup, redisMsg.ID)
+			continue
+		}
 
-			// Process batch with retries
-			success := false
-			for i := range q.config.MaxRetries {
-				err = q.models.Messages.BulkInsert(ctx, messages)
-				if err == nil {
-					success = true
-					break
-				}
-				q.logger.Error("failed to process message batch",
-					"error", err,
-					"retry", i+1,
-					"batch_size", len(messages))
-				time.Sleep(q.config.RetryDelay)
-			}
+		messages = append(messages, &msg)
+		messageIDs = append(messageIDs, redisMsg.ID)
+	}
 
-			// Acknowledge messages or send to DLQ
-			if success {
-				q.logger.Info("message batch processed successfully",
-					"count", len(messages))
-				if len(messageIDs) > 0 {
-					q.client.XAck(ctx, q.config.StreamKey, q.config.ConsumerGroup, messageIDs...)
-				}
-			} else {
-				q.logger.Error("message batch processing failed after retries",
-					"batch_size", len(messages))
-				// Send to DLQ
-				for _, msg := range messages {
-					msgJSON, _ := json.Marshal(msg)
-					q.client.XAdd(ctx, &redis.XAddArgs{
-						Stream: q.config.dlq.key,
-						Values: map[string]any{
-							"message": string(msgJSON),
-						},
-					})
-				}
-				// Still acknowledge to prevent blocking
-				if len(messageIDs) > 0 {
-					q.client.XAck(ctx, q.config.StreamKey, q.config.ConsumerGroup, messageIDs...)
-				}
-			}
+	// Process batch with retries
+	success := false
+	for i := range q.config.MaxRetries {
+		err := q.models.Messages.BulkInsert(ctx, messages)
+		if err == nil {
+			success = true
+			break
+		}
+		q.logger.Error("failed to process message batch",
+			"error", err,
+			"stream", key,
+			"retry", i+1,
+			"batch_size", len(messages))
+		time.Sleep(q.config.RetryDelay)
+	}
+
+	// Acknowledge messages or send to DLQ
+	if success {
+		q.logger.Info("message batch processed successfully",
+			"stream", key,
+			"count", len(messages))
+		if len(messageIDs) > 0 {
+			q.client.XAck(ctx, key, q.config.ConsumerGroup, messageIDs...)
+		}
+		q.runPersistHooks(ctx, messages)
+	} else {
+		q.logger.Error("message batch processing failed after retries",
+			"stream", key,
+			"batch_size", len(messages))
+		// Send to DLQ
+		for _, msg := range messages {
+			msgJSON, _ := json.Marshal(msg)
+			q.client.XAdd(ctx, &redis.XAddArgs{
+				Stream: q.config.DLQKey,
+				Values: map[string]any{
+					"message": string(msgJSON),
+				},
+			})
+		}
+		// Still acknowledge to prevent blocking
+		if len(messageIDs) > 0 {
+			q.client.XAck(ctx, key, q.config.ConsumerGroup, messageIDs...)
 		}
 	}
 }
@@ -171,7 +298,7 @@ func (q *MessageQueue) ProcessDLQ(ctx context.Context) error {
 		default:
 			// Read a batch of messages from the DLQ
 			streams, err := q.client.XRead(ctx, &redis.XReadArgs{
-				Streams: []string{q.config.dlq.key, "$"},
+				Streams: []string{q.config.DLQKey, "$"},
 				Block:   q.config.BlockingDuration,
 				Count:   100,
 			}).Result()
@@ -208,9 +335,11 @@ func (q *MessageQueue) ProcessDLQ(ctx context.Context) error {
 					continue
 				}
 
+				q.runPersistHooks(ctx, []*data.Message{&msg})
+
 				// Acknowledge the message from DLQ
 				q.logger.Info("message reprocessed successfully from DLQ")
-				q.client.XAck(ctx, q.config.dlq.key, "dlq_processor", redisMsg.ID)
+				q.client.XAck(ctx, q.config.DLQKey, "dlq_processor", redisMsg.ID)
 			}
 		}
 	}
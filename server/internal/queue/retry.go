@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// promoteRetriesScript moves up to ARGV[2] entries due by ARGV[1] from the
+// retry set in KEYS[1] onto the stream in KEYS[2]. Each member holds the
+// entry's fields as a JSON object. Doing this in one script keeps an entry from
+// being added twice or lost between the two keys.
+var promoteRetriesScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, member in ipairs(due) do
+	local fields = cjson.decode(member)
+	local args = {}
+	for field, value in pairs(fields) do
+		table.insert(args, field)
+		table.insert(args, value)
+	end
+	redis.call('XADD', KEYS[2], '*', unpack(args))
+	redis.call('ZREM', KEYS[1], member)
+end
+return #due
+`)
+
+// RetrySet holds stream entries whose processing failed until they are due to
+// be retried, so that consumers never wait out a backoff while holding up the
+// rest of their stream. Entries are scored by when they are due and moved back
+// onto the stream by Run.
+type RetrySet struct {
+	client    *redis.Client
+	key       string
+	streamKey string
+	interval  time.Duration
+	batchSize int
+	logger    *slog.Logger
+}
+
+func NewRetrySet(client *redis.Client, key, streamKey string, interval time.Duration, batchSize int, logger *slog.Logger) *RetrySet {
+	return &RetrySet{
+		client:    client,
+		key:       key,
+		streamKey: streamKey,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// Add schedules an entry with the given fields to be added to the stream again
+// once the delay has passed. Fields should identify the attempt, since equal
+// entries are only kept once.
+func (s *RetrySet) Add(ctx context.Context, fields map[string]string, delay time.Duration) error {
+	member, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	return s.client.ZAdd(ctx, s.key, redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixMilli()),
+		Member: string(member),
+	}).Err()
+}
+
+// Run periodically moves entries that are due back onto the stream, until the
+// context is cancelled
+func (s *RetrySet) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			for {
+				now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+				promoted, err := promoteRetriesScript.Run(ctx, s.client, []string{s.key, s.streamKey}, now, s.batchSize).Int()
+				if err != nil {
+					s.logger.Error("failed to promote retries", "error", err, "key", s.key)
+					break
+				}
+				if promoted < s.batchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Backoff returns how long to wait before the given attempt, doubling the
+// initial backoff with every attempt after the first
+func Backoff(initial time.Duration, attempt int) time.Duration {
+	if attempt < 2 {
+		return initial
+	}
+	return initial << (attempt - 2)
+}
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/policy"
+)
+
+// PromoteScheduled periodically moves scheduled messages that are due into the
+// message stream, until the context is cancelled
+func (q *MessageQueue) PromoteScheduled(ctx context.Context) error {
+	ticker := time.NewTicker(q.config.ScheduleInterval)
+	defer ticker.Stop()
+
+	q.logger.Info("message scheduler started", "interval", q.config.ScheduleInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			for {
+				promoted, err := q.models.ScheduledMessages.PromoteDue(ctx, q.config.BatchSize, q.publishScheduled)
+				if promoted > 0 {
+					q.logger.Info("scheduled messages promoted", "count", promoted)
+				}
+				if err != nil {
+					q.logger.Error("failed to promote scheduled messages", "error", err)
+					break
+				}
+				if promoted < q.config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// publishScheduled enqueues a scheduled message that is due, unless its sender
+// is no longer allowed to send it to its receiver. Messages that are not
+// allowed are dropped along with their schedule.
+func (q *MessageQueue) publishScheduled(ctx context.Context, message *data.Message) error {
+	users, err := q.models.Users.GetMany(ctx, message.OrganizationID, []int64{message.SenderID, message.ReceiverID})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			q.logger.Info("dropped scheduled message for deleted user", "sender_id", message.SenderID, "receiver_id", message.ReceiverID)
+			return nil
+		}
+		return err
+	}
+
+	var sender, receiver *data.User
+	for _, user := range users {
+		switch user.ID {
+		case message.SenderID:
+			sender = user
+		case message.ReceiverID:
+			receiver = user
+		}
+	}
+
+	// Blocks and privacy settings may have changed since the message was
+	// scheduled
+	err = policy.CanSend(sender)
+	if err == nil {
+		err = policy.CanMessage(ctx, q.models, message.SenderID, receiver)
+	}
+	if err != nil {
+		if !policy.IsDenied(err) {
+			return err
+		}
+		q.logger.Info("dropped scheduled message", "reason", err, "sender_id", message.SenderID, "receiver_id", message.ReceiverID)
+		return nil
+	}
+
+	return q.EnqueueMessage(ctx, message)
+}
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TrimNone   = "none"
+	TrimMaxLen = "maxlen"
+	TrimMinID  = "minid"
+)
+
+// TrimPolicy describes how a single stream is kept from growing without bound.
+// With TrimMaxLen the stream is capped at roughly MaxLen entries. With TrimMinID
+// every entry that all consumer groups have acknowledged is removed.
+type TrimPolicy struct {
+	Strategy string
+	MaxLen   int64
+}
+
+// Validate reports whether the policy can be applied
+func (p TrimPolicy) Validate() error {
+	switch p.Strategy {
+	case TrimNone, TrimMinID:
+		return nil
+	case TrimMaxLen:
+		if p.MaxLen < 1 {
+			return fmt.Errorf("trim policy: maxlen must be greater than zero")
+		}
+		return nil
+	default:
+		return fmt.Errorf("trim policy: unknown strategy %q", p.Strategy)
+	}
+}
+
+// TrimStreams periodically trims the message streams of all organizations and
+// the DLQ according to their configured policies, until the context is
+// cancelled
+func (q *MessageQueue) TrimStreams(ctx context.Context) error {
+	ticker := time.NewTicker(q.config.TrimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			keys, err := q.streamKeys(ctx)
+			if err != nil {
+				q.logger.Error("failed to list message streams", "error", err)
+				keys = []string{q.config.StreamKey}
+			}
+
+			for _, key := range keys {
+				q.trimStream(ctx, key, q.config.StreamTrim)
+			}
+			q.trimStream(ctx, q.config.DLQKey, q.config.DLQTrim)
+		}
+	}
+}
+
+func (q *MessageQueue) trimStream(ctx context.Context, key string, policy TrimPolicy) {
+	var (
+		trimmed int64
+		err     error
+	)
+
+	switch policy.Strategy {
+	case TrimMaxLen:
+		trimmed, err = q.client.XTrimMaxLenApprox(ctx, key, policy.MaxLen, 0).Result()
+	case TrimMinID:
+		var minID string
+		minID, err = q.lowestUnackedID(ctx, key)
+		if err == nil && minID != "" {
+			trimmed, err = q.client.XTrimMinID(ctx, key, minID).Result()
+		}
+	}
+
+	if err != nil {
+		q.logger.Error("failed to trim stream", "stream", key, "strategy", policy.Strategy, "error", err)
+		return
+	}
+
+	streamTrimmed.Add(key, trimmed)
+
+	length, err := q.client.XLen(ctx, key).Result()
+	if err != nil {
+		q.logger.Error("failed to read stream length", "stream", key, "error", err)
+		return
+	}
+	setStreamLength(key, length)
+
+	if trimmed > 0 {
+		q.logger.Info("stream trimmed", "stream", key, "trimmed", trimmed, "length", length)
+	}
+}
+
+// lowestUnackedID returns the smallest stream ID that at least one consumer group
+// still needs. Everything before it has been acknowledged by every group and is
+// safe to delete. An empty string means nothing can be trimmed.
+func (q *MessageQueue) lowestUnackedID(ctx context.Context, key string) (string, error) {
+	groups, err := q.client.XInfoGroups(ctx, key).Result()
+	if err != nil {
+		// The stream has not been created yet
+		if strings.HasPrefix(err.Error(), "ERR no such key") {
+			return "", nil
+		}
+		return "", err
+	}
+
+	// A stream without consumer groups has no notion of acknowledgement
+	if len(groups) == 0 {
+		return "", nil
+	}
+
+	var lowest string
+	for _, group := range groups {
+		var candidate string
+
+		if group.Pending > 0 {
+			pending, err := q.client.XPending(ctx, key, group.Name).Result()
+			if err != nil {
+				return "", err
+			}
+			candidate = pending.Lower
+		} else {
+			candidate, err = nextStreamID(group.LastDeliveredID)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if lowest == "" || compareStreamIDs(candidate, lowest) < 0 {
+			lowest = candidate
+		}
+	}
+
+	return lowest, nil
+}
+
+func parseStreamID(id string) (uint64, uint64, error) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid stream ID %q", id)
+	}
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid stream ID %q", id)
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid stream ID %q", id)
+	}
+
+	return ms, seq, nil
+}
+
+func nextStreamID(id string) (string, error) {
+	ms, seq, err := parseStreamID(id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", ms, seq+1), nil
+}
+
+// compareStreamIDs returns -1, 0 or 1. IDs that fail to parse sort last so
+// they can never cause more entries to be trimmed.
+func compareStreamIDs(a, b string) int {
+	aMs, aSeq, aErr := parseStreamID(a)
+	bMs, bSeq, bErr := parseStreamID(b)
+
+	switch {
+	case aErr != nil && bErr != nil:
+		return 0
+	case aErr != nil:
+		return 1
+	case bErr != nil:
+		return -1
+	case aMs != bMs:
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	case aSeq != bSeq:
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
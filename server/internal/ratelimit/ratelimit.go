@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Policy is a token bucket that holds up to Burst tokens and regains Rate
+// tokens per second
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+// Result describes the state of a bucket after a request was checked against
+// it
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is how many whole tokens are left
+	Remaining int
+	// RetryAfter is how long until the request would have been allowed. It is
+	// zero for allowed requests.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Limiter keeps token buckets in Redis so that every API replica spends from
+// the same budget. Buckets are refilled lazily by a Lua script that reads and
+// updates them atomically using the Redis server's clock.
+type Limiter struct {
+	client *redis.Client
+}
+
+func NewLimiter(client *redis.Client) *Limiter {
+	return &Limiter{client: client}
+}
+
+func bucketKey(key string) string {
+	return "ratelimit:" + key
+}
+
+// tokenBucket takes the rate, burst and cost and returns whether the cost was
+// spent, the whole tokens left, and the seconds until the cost would have been
+// available and until the bucket is full. Fractional seconds are returned as
+// strings since Lua numbers are truncated to integers in replies. Idle buckets
+// expire once they would have refilled.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry_after = 0
+
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+else
+	retry_after = (cost - tokens) / rate
+end
+
+local reset_after = (burst - tokens) / rate
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset_after * 1000) + 1000)
+
+return {allowed, math.floor(tokens), tostring(retry_after), tostring(reset_after)}
+`)
+
+// Allow spends one token from the bucket identified by key
+func (l *Limiter) Allow(ctx context.Context, key string, policy Policy) (*Result, error) {
+	return l.AllowN(ctx, key, policy, 1)
+}
+
+// AllowN spends n tokens from the bucket identified by key if they are all
+// available. Otherwise nothing is spent.
+func (l *Limiter) AllowN(ctx context.Context, key string, policy Policy, n int) (*Result, error) {
+	values, err := tokenBucket.Run(ctx, l.client, []string{bucketKey(key)}, policy.Rate, policy.Burst, n).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+
+	retryAfter, err := parseSeconds(values[2])
+	if err != nil {
+		return nil, err
+	}
+
+	resetAfter, err := parseSeconds(values[3])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Allowed:    allowed == 1,
+		Limit:      policy.Burst,
+		Remaining:  int(remaining),
+		RetryAfter: retryAfter,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+func parseSeconds(value any) (time.Duration, error) {
+	s, _ := value.(string)
+
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
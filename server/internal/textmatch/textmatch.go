@@ -0,0 +1,24 @@
+// Package textmatch holds the text matching shared by the features that look
+// for words in messages, such as auto replies and the spam filters.
+package textmatch
+
+import "strings"
+
+// MatchKeywords reports whether content contains any of the keywords as whole
+// words, ignoring case
+func MatchKeywords(keywords []string, content string) bool {
+	words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(content), isSeparator), " ") + " "
+
+	for _, keyword := range keywords {
+		phrase := strings.Join(strings.FieldsFunc(strings.ToLower(keyword), isSeparator), " ")
+		if phrase != "" && strings.Contains(words, " "+phrase+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSeparator(r rune) bool {
+	return !(r == '_' || r == '\'' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r > 127)
+}
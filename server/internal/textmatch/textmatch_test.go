@@ -0,0 +1,36 @@
+package textmatch
+
+import "testing"
+
+func TestMatchKeywords(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords []string
+		content  string
+		want     bool
+	}{
+		{"whole word", []string{"price"}, "What is the price?", true},
+		{"ignores case", []string{"PRICE"}, "what is the Price", true},
+		{"part of a word", []string{"price"}, "That is priceless", false},
+		{"any keyword", []string{"refund", "hours"}, "What are your hours", true},
+		{"no keyword", []string{"refund", "hours"}, "Hello there", false},
+		{"phrase", []string{"opening hours"}, "What are your opening   hours?", true},
+		{"phrase split by punctuation", []string{"opening hours"}, "opening, hours", true},
+		{"phrase out of order", []string{"opening hours"}, "hours opening", false},
+		{"apostrophe", []string{"don't"}, "I don't know", true},
+		{"digits", []string{"24x7"}, "Are you open 24x7?", true},
+		{"non-ASCII", []string{"café"}, "Is the Café open?", true},
+		{"start and end", []string{"hi"}, "hi", true},
+		{"blank keyword", []string{"", "  "}, "anything", false},
+		{"no keywords", nil, "anything", false},
+		{"empty content", []string{"hi"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchKeywords(tt.keywords, tt.content); got != tt.want {
+				t.Errorf("MatchKeywords(%q, %q) = %t; want %t", tt.keywords, tt.content, got, tt.want)
+			}
+		})
+	}
+}
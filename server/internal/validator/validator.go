@@ -1,11 +1,17 @@
 package validator
 
 import (
+	"regexp"
 	"strings"
 	"time"
 	"unicode/utf8"
 )
 
+var (
+	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	PhoneRX = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+)
+
 type Validator struct {
 	Errors map[string]string
 }
@@ -53,3 +59,30 @@ func ValidTimestamp(value time.Time) bool {
 func IsTimestampInPast(value time.Time) bool {
 	return value.Before(time.Now().Add(time.Millisecond * 200))
 }
+
+func IsTimestampInFuture(value time.Time) bool {
+	return value.After(time.Now())
+}
+
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool)
+
+	for _, value := range values {
+		uniqueValues[value] = true
+	}
+
+	return len(values) == len(uniqueValues)
+}
+
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	for i := range permittedValues {
+		if value == permittedValues[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenDestination is returned for webhook URLs that point into private
+// networks, such as the worker's own host or a cloud metadata service
+var ErrForbiddenDestination = errors.New("webhook destination is not a public address")
+
+// reservedPrefixes are ranges that are not reachable on the public internet
+// but are not covered by the netip classification methods
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// IsPublicAddress reports whether webhooks may be delivered to the address.
+// Loopback, private, link-local and other reserved addresses are refused.
+func IsPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckDestination resolves the host of a webhook URL and returns
+// ErrForbiddenDestination unless every address it resolves to is public.
+// Deliveries check the address they connect to again, since DNS answers can
+// change after the webhook was saved.
+func CheckDestination(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: %s could not be resolved", ErrForbiddenDestination, u.Hostname())
+	}
+
+	for _, addr := range addrs {
+		if !IsPublicAddress(addr) {
+			return ErrForbiddenDestination
+		}
+	}
+
+	return nil
+}
+
+// newHTTPClient returns the client deliveries are made with. Unless private
+// networks are allowed, it refuses to connect to anything but public
+// addresses. The check runs on the address actually dialled, so it also covers
+// redirects and hosts that resolve differently than when they were saved.
+func newHTTPClient(timeout time.Duration, allowPrivateNetworks bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	if !allowPrivateNetworks {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !IsPublicAddress(addrPort.Addr()) {
+				return ErrForbiddenDestination
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Requests through a proxy would only have the proxy's address checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
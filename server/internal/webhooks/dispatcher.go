@@ -0,0 +1,293 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/araaavind/zoko-im/internal/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+type Config struct {
+	StreamKey        string
+	ConsumerGroup    string
+	ConsumerName     string
+	RetryKey         string
+	DLQKey           string
+	BlockingDuration time.Duration
+	BatchSize        int
+	MaxAttempts      int
+	InitialBackoff   time.Duration
+	RetryInterval    time.Duration
+	Timeout          time.Duration
+	Concurrency      int
+	// AllowPrivateNetworks lets webhooks reach loopback and private addresses,
+	// which is only meant for development
+	AllowPrivateNetworks bool
+}
+
+// Dispatcher consumes message events and delivers them to subscribed
+// webhooks. Failed deliveries are retried with exponential backoff without
+// holding up the stream, and end up in their own DLQ once all attempts are
+// used.
+type Dispatcher struct {
+	client  *redis.Client
+	config  Config
+	retries *queue.RetrySet
+	logger  *slog.Logger
+	models  data.Models
+	http    *http.Client
+}
+
+func NewDispatcher(client *redis.Client, config Config, logger *slog.Logger, models data.Models) *Dispatcher {
+	return &Dispatcher{
+		client:  client,
+		config:  config,
+		retries: queue.NewRetrySet(client, config.RetryKey, config.StreamKey, config.RetryInterval, config.BatchSize, logger),
+		logger:  logger,
+		models:  models,
+		http:    newHTTPClient(config.Timeout, config.AllowPrivateNetworks),
+	}
+}
+
+// Run processes events until the context is cancelled. It also moves failed
+// deliveries back onto the stream once they are due to be retried.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	err := d.client.XGroupCreateMkStream(ctx, d.config.StreamKey, d.config.ConsumerGroup, "0").Err()
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return err
+	}
+
+	go func() {
+		err := d.retries.Run(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			d.logger.Error("webhook retries stopped", "error", err)
+		}
+	}()
+
+	d.logger.Info(
+		"webhook dispatcher started",
+		"group", d.config.ConsumerGroup,
+		"consumer", d.config.ConsumerName,
+		"concurrency", d.config.Concurrency,
+	)
+
+	// Start with whatever this consumer left unacknowledged before it stopped
+	pending := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			id := ">"
+			if pending {
+				id = "0"
+			}
+
+			streams, err := d.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    d.config.ConsumerGroup,
+				Consumer: d.config.ConsumerName,
+				Streams:  []string{d.config.StreamKey, id},
+				Block:    d.config.BlockingDuration,
+				Count:    int64(d.config.BatchSize),
+			}).Result()
+
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				d.logger.Error("Error reading webhook events", "error", err)
+				time.Sleep(d.config.InitialBackoff)
+				continue
+			}
+
+			if len(streams) == 0 || len(streams[0].Messages) == 0 {
+				pending = false
+				continue
+			}
+
+			pending = !d.dispatchBatch(ctx, streams[0].Messages)
+		}
+	}
+}
+
+// dispatchBatch makes one delivery attempt for every event in the batch to its
+// webhooks, running at most Concurrency deliveries at a time. Failed
+// deliveries are scheduled for a retry or moved to the DLQ, so the batch is
+// acknowledged as soon as every attempt has finished. It returns false if the
+// batch could not be acknowledged.
+func (d *Dispatcher) dispatchBatch(ctx context.Context, entries []redis.XMessage) bool {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.config.Concurrency)
+
+	ids := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+
+		eventJSON, ok := entry.Values["event"].(string)
+		if !ok {
+			d.logger.Error("invalid webhook event format", "event_id", entry.ID)
+			continue
+		}
+
+		var event Event
+		err := json.Unmarshal([]byte(eventJSON), &event)
+		if err != nil || event.Data == nil {
+			d.logger.Error("failed to unmarshal webhook event", "error", err, "event_id", entry.ID)
+			continue
+		}
+
+		// Retries name the one webhook they are for and the attempt they are
+		webhookID, _ := strconv.ParseInt(fmt.Sprint(entry.Values["webhook_id"]), 10, 64)
+		attempt, err := strconv.Atoi(fmt.Sprint(entry.Values["attempt"]))
+		if err != nil || attempt < 1 {
+			attempt = 1
+		}
+
+		participants := []int64{event.Data.SenderID, event.Data.ReceiverID}
+		hooks, err := d.models.Webhooks.GetSubscribed(ctx, participants, event.Type)
+		if err != nil {
+			d.deadLetter(ctx, webhookID, eventJSON, fmt.Errorf("failed to load webhooks: %w", err))
+			continue
+		}
+
+		for _, hook := range hooks {
+			// Webhooks deleted or deactivated since a failed attempt are not
+			// retried
+			if webhookID != 0 && hook.ID != webhookID {
+				continue
+			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+
+			go func(hook *data.Webhook) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				d.attempt(ctx, hook, &event, eventJSON, attempt)
+			}(hook)
+		}
+	}
+
+	wg.Wait()
+
+	if len(ids) > 0 {
+		// The batch is acknowledged even while shutting down, so that events
+		// are not delivered twice
+		ackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		err := d.client.XAck(ackCtx, d.config.StreamKey, d.config.ConsumerGroup, ids...).Err()
+		if err != nil {
+			d.logger.Error("failed to acknowledge webhook events", "error", err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// attempt delivers the event to the webhook once. If that fails the delivery
+// is scheduled for a retry, or moved to the DLQ once all attempts are used or
+// the retry cannot be scheduled.
+func (d *Dispatcher) attempt(ctx context.Context, hook *data.Webhook, event *Event, eventJSON string, attempt int) {
+	err := d.deliver(ctx, hook, event, []byte(eventJSON))
+	if err == nil {
+		return
+	}
+
+	d.logger.Warn("webhook delivery failed",
+		"error", err,
+		"webhook_id", hook.ID,
+		"event_id", event.ID,
+		"attempt", attempt)
+
+	if attempt < d.config.MaxAttempts {
+		// Deliveries cut short by a shutdown are retried as well
+		retryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		retryErr := d.retries.Add(retryCtx, map[string]string{
+			"event":      eventJSON,
+			"webhook_id": strconv.FormatInt(hook.ID, 10),
+			"attempt":    strconv.Itoa(attempt + 1),
+		}, queue.Backoff(d.config.InitialBackoff, attempt+1))
+		if retryErr == nil {
+			return
+		}
+		d.logger.Error("failed to schedule webhook retry", "error", retryErr, "webhook_id", hook.ID)
+	}
+
+	d.deadLetter(ctx, hook.ID, eventJSON, err)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, hook *data.Webhook, event *Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Zoko-Webhooks/1.0")
+	req.Header.Set("X-Zoko-Event", event.Type)
+	req.Header.Set("X-Zoko-Delivery", event.ID)
+	req.Header.Set("X-Zoko-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Zoko-Signature", Sign(hook.Secret, timestamp, body))
+
+	res, err := d.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// deadLetter moves an event that could not be delivered to the webhook DLQ. A
+// zero webhookID means the event failed before it was matched to webhooks.
+func (d *Dispatcher) deadLetter(ctx context.Context, webhookID int64, eventJSON string, deliveryErr error) {
+	d.logger.Error("webhook delivery failed after retries",
+		"error", deliveryErr,
+		"webhook_id", webhookID)
+
+	// The delivery is settled even while shutting down, so that it is not lost
+	// when the batch is acknowledged
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	err := d.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: d.config.DLQKey,
+		Values: map[string]any{
+			"webhook_id": webhookID,
+			"event":      eventJSON,
+			"error":      deliveryErr.Error(),
+		},
+	}).Err()
+	if err != nil {
+		d.logger.Error("failed to add webhook delivery to DLQ", "error", err, "webhook_id", webhookID)
+	}
+}
@@ -0,0 +1,231 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestDispatcher(client *redis.Client, config Config) *Dispatcher {
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	return NewDispatcher(client, config, slog.New(slog.NewTextHandler(io.Discard, nil)), data.Models{})
+}
+
+func newTestEvent(t *testing.T) (*Event, []byte) {
+	t.Helper()
+
+	event := &Event{
+		ID:        "evt_1",
+		Type:      data.EventMessagePersisted,
+		Timestamp: time.Now(),
+		Data:      &data.Message{ID: 1, SenderID: 1, ReceiverID: 2, Content: "Hello"},
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return event, body
+}
+
+func TestDeliver(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher(nil, Config{AllowPrivateNetworks: true})
+	hook := &data.Webhook{ID: 1, URL: server.URL, Secret: "whsec_test"}
+	event, body := newTestEvent(t)
+
+	err := d.deliver(context.Background(), hook, event, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]string{
+		"Content-Type":    "application/json",
+		"X-Zoko-Event":    data.EventMessagePersisted,
+		"X-Zoko-Delivery": "evt_1",
+	}
+	for header, want := range headers {
+		if value := got.Header.Get(header); value != want {
+			t.Errorf("got %s %q; want %q", header, value, want)
+		}
+	}
+
+	if string(gotBody) != string(body) {
+		t.Errorf("got body %s; want %s", gotBody, body)
+	}
+
+	timestamp, err := strconv.ParseInt(got.Header.Get("X-Zoko-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid X-Zoko-Timestamp: %v", err)
+	}
+	if signature := got.Header.Get("X-Zoko-Signature"); signature != Sign(hook.Secret, timestamp, gotBody) {
+		t.Errorf("got signature %q; want %q", signature, Sign(hook.Secret, timestamp, gotBody))
+	}
+}
+
+func TestDeliverResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"accepted", http.StatusAccepted, false},
+		{"redirect", http.StatusNotModified, true},
+		{"client error", http.StatusGone, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			d := newTestDispatcher(nil, Config{AllowPrivateNetworks: true})
+			event, body := newTestEvent(t)
+
+			err := d.deliver(context.Background(), &data.Webhook{URL: server.URL}, event, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeliverTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	d := newTestDispatcher(nil, Config{AllowPrivateNetworks: true, Timeout: 100 * time.Millisecond})
+	event, body := newTestEvent(t)
+
+	err := d.deliver(context.Background(), &data.Webhook{URL: server.URL}, event, body)
+	if err == nil {
+		t.Fatal("got no error from an endpoint that never answers")
+	}
+}
+
+// TestDeliverRefusesPrivateAddresses checks the address that is dialled, which
+// also catches hosts that resolved to a public address when the webhook was
+// saved and redirects into the private network
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	called := false
+
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer internal.Close()
+
+	d := newTestDispatcher(nil, Config{})
+	event, body := newTestEvent(t)
+
+	err := d.deliver(context.Background(), &data.Webhook{URL: internal.URL}, event, body)
+	if !errors.Is(err, ErrForbiddenDestination) {
+		t.Errorf("got error %v; want %v", err, ErrForbiddenDestination)
+	}
+	if called {
+		t.Error("the private endpoint was called")
+	}
+}
+
+// TestAttempt checks that failed deliveries are retried out of band and dead
+// lettered once all attempts are used. It needs the Redis server in
+// IM_TEST_REDIS_ADDR and is skipped unless it is set.
+func TestAttempt(t *testing.T) {
+	addr := os.Getenv("IM_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("IM_TEST_REDIS_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	ctx := context.Background()
+	prefix := "test:webhooks:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	config := Config{
+		StreamKey:            prefix + ":events",
+		RetryKey:             prefix + ":retry",
+		DLQKey:               prefix + ":dlq",
+		MaxAttempts:          2,
+		InitialBackoff:       time.Minute,
+		AllowPrivateNetworks: true,
+	}
+	defer client.Del(ctx, config.StreamKey, config.RetryKey, config.DLQKey)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher(client, config)
+	hook := &data.Webhook{ID: 7, URL: server.URL}
+	event, body := newTestEvent(t)
+
+	start := time.Now()
+	d.attempt(ctx, hook, event, string(body), 1)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the first attempt took %s; want the backoff to be waited out of band", elapsed)
+	}
+
+	retries, err := client.ZRangeWithScores(ctx, config.RetryKey, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 {
+		t.Fatalf("got %d scheduled retries; want 1", len(retries))
+	}
+
+	var fields map[string]string
+	err = json.Unmarshal([]byte(retries[0].Member.(string)), &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["webhook_id"] != "7" || fields["attempt"] != "2" || fields["event"] != string(body) {
+		t.Errorf("got retry %v", fields)
+	}
+	if due := time.UnixMilli(int64(retries[0].Score)); due.Before(start.Add(config.InitialBackoff)) {
+		t.Errorf("retry is due at %s; want at least %s after %s", due, config.InitialBackoff, start)
+	}
+
+	d.attempt(ctx, hook, event, string(body), 2)
+
+	dead, err := client.XRange(ctx, config.DLQKey, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Values["webhook_id"] != "7" {
+		t.Errorf("got DLQ entries %v; want one for webhook 7", dead)
+	}
+}
@@ -0,0 +1,102 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/araaavind/zoko-im/internal/data"
+	"github.com/redis/go-redis/v9"
+)
+
+// Event is the payload POSTed to webhook URLs
+type Event struct {
+	ID        string        `json:"id"`
+	Type      string        `json:"type"`
+	Timestamp time.Time     `json:"timestamp"`
+	Data      *data.Message `json:"data"`
+}
+
+// Publisher adds message events to the stream consumed by the Dispatcher
+type Publisher struct {
+	client    *redis.Client
+	streamKey string
+}
+
+func NewPublisher(client *redis.Client, streamKey string) *Publisher {
+	return &Publisher{
+		client:    client,
+		streamKey: streamKey,
+	}
+}
+
+// Publish records one event of the given type per message
+func (p *Publisher) Publish(ctx context.Context, eventType string, messages ...*data.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	pipe := p.client.Pipeline()
+
+	for _, message := range messages {
+		id, err := newEventID()
+		if err != nil {
+			return err
+		}
+
+		event := Event{
+			ID:        id,
+			Type:      eventType,
+			Timestamp: time.Now(),
+			Data:      message,
+		}
+
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: p.streamKey,
+			Values: map[string]any{
+				"event": string(eventJSON),
+			},
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewSecret generates a random secret for signing a webhook's deliveries
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature sent in the X-Zoko-Signature header. Receivers
+// recompute it over the X-Zoko-Timestamp header and the raw request body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id bigserial PRIMARY KEY,
+    send_at timestamp(3) with time zone NOT NULL,
+    content text NOT NULL,
+    sender_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    receiver_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_scheduled_messages_send_at ON scheduled_messages (send_at);
+CREATE INDEX idx_scheduled_messages_sender ON scheduled_messages (sender_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_scheduled_messages_sender;
+DROP INDEX IF EXISTS idx_scheduled_messages_send_at;
+DROP TABLE IF EXISTS scheduled_messages;
+-- +goose StatementEnd
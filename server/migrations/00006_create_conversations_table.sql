@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS conversations (
+    id bigserial PRIMARY KEY,
+    user_a_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    user_b_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    message_ttl integer NOT NULL DEFAULT 0,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (user_a_id < user_b_id),
+    CHECK (message_ttl >= 0),
+    UNIQUE (user_a_id, user_b_id)
+);
+
+CREATE INDEX idx_conversations_user_b ON conversations (user_b_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_conversations_user_b;
+DROP TABLE IF EXISTS conversations;
+-- +goose StatementEnd
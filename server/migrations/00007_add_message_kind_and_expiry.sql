@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE messages ADD COLUMN kind text NOT NULL DEFAULT 'text';
+ALTER TABLE messages ADD COLUMN expires_at timestamp(3) with time zone;
+
+CREATE INDEX idx_messages_expires_at ON messages (expires_at) WHERE expires_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS kind;
+-- +goose StatementEnd
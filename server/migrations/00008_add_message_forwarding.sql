@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- forwarded_from_id is deliberately not a foreign key: the original message may
+-- disappear or be deleted before the forwarded copy is persisted by the worker.
+ALTER TABLE messages ADD COLUMN forwarded boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN forwarded_from_id bigint;
+
+CREATE INDEX idx_messages_forwarded_from_id ON messages (forwarded_from_id) WHERE forwarded_from_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_forwarded_from_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded;
+-- +goose StatementEnd
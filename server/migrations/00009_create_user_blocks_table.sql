@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    blocked_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked ON user_blocks (blocked_id);
+
+ALTER TABLE users ADD COLUMN message_privacy text NOT NULL DEFAULT 'everyone'
+    CHECK (message_privacy IN ('everyone', 'contacts', 'nobody'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS message_privacy;
+DROP INDEX IF EXISTS idx_user_blocks_blocked;
+DROP TABLE IF EXISTS user_blocks;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email text UNIQUE;
+ALTER TABLE users ADD COLUMN phone text UNIQUE;
+
+CREATE TABLE IF NOT EXISTS contacts (
+    id bigserial PRIMARY KEY,
+    owner_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    contact_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    nickname text NOT NULL DEFAULT '',
+    favorite boolean NOT NULL DEFAULT FALSE,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (owner_id, contact_id),
+    CHECK (owner_id <> contact_id)
+);
+
+CREATE INDEX idx_contacts_contact_id ON contacts (contact_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_contacts_contact_id;
+DROP TABLE IF EXISTS contacts;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
+-- +goose StatementEnd
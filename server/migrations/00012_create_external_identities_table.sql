@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS external_identities (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL UNIQUE REFERENCES users ON DELETE CASCADE,
+    channel text NOT NULL,
+    external_id text NOT NULL,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (channel, external_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS external_identities;
+-- +goose StatementEnd
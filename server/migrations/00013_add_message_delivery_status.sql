@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE messages ADD COLUMN delivery_status text;
+ALTER TABLE messages ADD COLUMN external_message_id text;
+
+CREATE INDEX idx_messages_external_message_id ON messages (external_message_id) WHERE external_message_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_external_message_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS external_message_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS delivery_status;
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS broadcast_lists (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    receiver_ids bigint[] NOT NULL,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id bigserial PRIMARY KEY,
+    sender_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    list_id bigint REFERENCES broadcast_lists ON DELETE SET NULL,
+    content text NOT NULL,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_broadcasts_sender_id ON broadcasts (sender_id);
+
+CREATE TABLE IF NOT EXISTS broadcast_recipients (
+    broadcast_id bigint NOT NULL REFERENCES broadcasts ON DELETE CASCADE,
+    receiver_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL,
+    PRIMARY KEY (broadcast_id, receiver_id)
+);
+
+ALTER TABLE messages ADD COLUMN broadcast_id bigint;
+
+CREATE INDEX idx_messages_broadcast_id ON messages (broadcast_id) WHERE broadcast_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_broadcast_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS broadcast_id;
+DROP TABLE IF EXISTS broadcast_recipients;
+DROP INDEX IF EXISTS idx_broadcasts_sender_id;
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS broadcast_lists;
+-- +goose StatementEnd
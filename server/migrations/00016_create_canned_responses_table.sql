@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS canned_responses (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    shortcut text NOT NULL,
+    body text NOT NULL,
+    shared boolean NOT NULL DEFAULT FALSE,
+    usage_count bigint NOT NULL DEFAULT 0,
+    last_used_at timestamp(3) with time zone,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, shortcut)
+);
+
+CREATE INDEX idx_canned_responses_shared ON canned_responses (shortcut) WHERE shared;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_canned_responses_shared;
+DROP TABLE IF EXISTS canned_responses;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS auto_reply_rules (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    match_type text NOT NULL,
+    keywords text[] NOT NULL DEFAULT '{}',
+    pattern text NOT NULL DEFAULT '',
+    hours_condition text NOT NULL DEFAULT 'any',
+    business_hours jsonb,
+    reply text NOT NULL,
+    priority integer NOT NULL DEFAULT 0,
+    active boolean NOT NULL DEFAULT TRUE,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_auto_reply_rules_user_id ON auto_reply_rules (user_id) WHERE active;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_auto_reply_rules_user_id;
+DROP TABLE IF EXISTS auto_reply_rules;
+-- +goose StatementEnd
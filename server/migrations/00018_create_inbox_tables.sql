@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS inboxes (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    strategy text NOT NULL DEFAULT 'round_robin',
+    first_response_sla integer NOT NULL DEFAULT 0,
+    resolution_sla integer NOT NULL DEFAULT 0,
+    last_assigned_agent_id bigint,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS inbox_agents (
+    inbox_id bigint NOT NULL REFERENCES inboxes ON DELETE CASCADE,
+    agent_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (inbox_id, agent_id),
+    CHECK (inbox_id <> agent_id)
+);
+
+CREATE TABLE IF NOT EXISTS assignments (
+    id bigserial PRIMARY KEY,
+    conversation_id bigint NOT NULL REFERENCES conversations ON DELETE CASCADE,
+    inbox_id bigint NOT NULL REFERENCES inboxes ON DELETE CASCADE,
+    customer_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    agent_id bigint REFERENCES users ON DELETE SET NULL,
+    opened_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    first_response_at timestamp(3) with time zone,
+    resolved_at timestamp(3) with time zone,
+    first_response_breached_at timestamp(3) with time zone,
+    resolution_breached_at timestamp(3) with time zone
+);
+
+-- A customer has at most one open assignment per inbox
+CREATE UNIQUE INDEX idx_assignments_open ON assignments (inbox_id, customer_id) WHERE resolved_at IS NULL;
+CREATE INDEX idx_assignments_agent_id ON assignments (agent_id) WHERE resolved_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_assignments_agent_id;
+DROP INDEX IF EXISTS idx_assignments_open;
+DROP TABLE IF EXISTS assignments;
+DROP TABLE IF EXISTS inbox_agents;
+DROP TABLE IF EXISTS inboxes;
+-- +goose StatementEnd
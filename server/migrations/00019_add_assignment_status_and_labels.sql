@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE assignments
+    ADD COLUMN status text NOT NULL DEFAULT 'open',
+    ADD COLUMN labels text[] NOT NULL DEFAULT '{}';
+
+UPDATE assignments SET status = 'resolved' WHERE resolved_at IS NOT NULL;
+
+CREATE INDEX idx_assignments_labels ON assignments USING GIN (labels);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_assignments_labels;
+ALTER TABLE assignments DROP COLUMN IF EXISTS labels;
+ALTER TABLE assignments DROP COLUMN IF EXISTS status;
+-- +goose StatementEnd
@@ -0,0 +1,70 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Everyone who signed up before organizations existed shares the default one
+INSERT INTO organizations (id, name) VALUES (1, 'Default');
+SELECT setval(pg_get_serial_sequence('organizations', 'id'), 1);
+
+ALTER TABLE users ADD COLUMN organization_id bigint NOT NULL DEFAULT 1 REFERENCES organizations ON DELETE CASCADE;
+ALTER TABLE users ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE users ADD CONSTRAINT users_organization_id_id_key UNIQUE (organization_id, id);
+
+-- A user belongs to a single organization for now
+CREATE TABLE IF NOT EXISTS memberships (
+    organization_id bigint NOT NULL,
+    user_id bigint PRIMARY KEY,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (organization_id, user_id) REFERENCES users (organization_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_memberships_organization_id ON memberships (organization_id);
+
+INSERT INTO memberships (organization_id, user_id)
+SELECT organization_id, id FROM users;
+
+-- Both participants of a message or conversation must be in its organization
+ALTER TABLE messages ADD COLUMN organization_id bigint NOT NULL DEFAULT 1;
+ALTER TABLE messages ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE messages
+    ADD CONSTRAINT messages_sender_organization_fkey FOREIGN KEY (organization_id, sender_id) REFERENCES users (organization_id, id) ON DELETE CASCADE,
+    ADD CONSTRAINT messages_receiver_organization_fkey FOREIGN KEY (organization_id, receiver_id) REFERENCES users (organization_id, id) ON DELETE CASCADE;
+
+ALTER TABLE conversations ADD COLUMN organization_id bigint NOT NULL DEFAULT 1;
+ALTER TABLE conversations ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE conversations
+    ADD CONSTRAINT conversations_user_a_organization_fkey FOREIGN KEY (organization_id, user_a_id) REFERENCES users (organization_id, id) ON DELETE CASCADE,
+    ADD CONSTRAINT conversations_user_b_organization_fkey FOREIGN KEY (organization_id, user_b_id) REFERENCES users (organization_id, id) ON DELETE CASCADE;
+
+ALTER TABLE scheduled_messages ADD COLUMN organization_id bigint NOT NULL DEFAULT 1;
+ALTER TABLE scheduled_messages ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE scheduled_messages
+    ADD CONSTRAINT scheduled_messages_sender_organization_fkey FOREIGN KEY (organization_id, sender_id) REFERENCES users (organization_id, id) ON DELETE CASCADE;
+
+-- The same phone number can be a customer of several organizations, each with
+-- their own user for it
+ALTER TABLE external_identities ADD COLUMN organization_id bigint NOT NULL DEFAULT 1;
+ALTER TABLE external_identities ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE external_identities DROP CONSTRAINT IF EXISTS external_identities_channel_external_id_key;
+ALTER TABLE external_identities ADD CONSTRAINT external_identities_organization_channel_external_id_key UNIQUE (organization_id, channel, external_id);
+
+CREATE INDEX idx_messages_organization_id ON messages (organization_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_organization_id;
+ALTER TABLE external_identities DROP CONSTRAINT IF EXISTS external_identities_organization_channel_external_id_key;
+ALTER TABLE external_identities ADD CONSTRAINT external_identities_channel_external_id_key UNIQUE (channel, external_id);
+ALTER TABLE external_identities DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE scheduled_messages DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE conversations DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS memberships;
+ALTER TABLE users DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organizations;
+-- +goose StatementEnd
@@ -0,0 +1,81 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN password_hash bytea;
+
+CREATE TABLE IF NOT EXISTS tokens (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL,
+    scope text NOT NULL
+);
+
+CREATE INDEX idx_tokens_user_id ON tokens (user_id);
+
+-- Existing members keep the least privileged role until an owner promotes them
+ALTER TABLE memberships ADD COLUMN role text NOT NULL DEFAULT 'end_user'
+    CHECK (role IN ('owner', 'admin', 'agent', 'end_user'));
+
+-- Every existing organization needs an owner to promote anyone, so its
+-- earliest member becomes one. Members from before this migration have no
+-- password, so an operator gives the owner credentials to log in with, for
+-- example:
+--   UPDATE users SET email = 'owner@example.com',
+--       password_hash = convert_to(crypt('a long password', gen_salt('bf', 12)), 'UTF8')
+--   WHERE id = <owner's user ID>;
+-- using pgcrypto, whose bcrypt hashes the API accepts.
+UPDATE memberships m SET role = 'owner'
+FROM (
+    SELECT DISTINCT ON (organization_id) organization_id, user_id
+    FROM memberships
+    ORDER BY organization_id, created_at, user_id
+) first
+WHERE m.organization_id = first.organization_id AND m.user_id = first.user_id;
+
+CREATE TABLE IF NOT EXISTS permissions (
+    code text PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role text NOT NULL,
+    permission text NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO permissions (code) VALUES
+    ('messages:read'),
+    ('messages:send'),
+    ('members:read'),
+    ('members:write'),
+    ('roles:write'),
+    ('admin:dlq'),
+    ('admin:users');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('end_user', 'messages:read'),
+    ('end_user', 'messages:send'),
+    ('agent', 'messages:read'),
+    ('agent', 'messages:send'),
+    ('agent', 'members:read'),
+    ('admin', 'messages:read'),
+    ('admin', 'messages:send'),
+    ('admin', 'members:read'),
+    ('admin', 'members:write'),
+    ('admin', 'admin:dlq'),
+    ('admin', 'admin:users'),
+    ('owner', 'messages:read'),
+    ('owner', 'messages:send'),
+    ('owner', 'members:read'),
+    ('owner', 'members:write'),
+    ('owner', 'roles:write'),
+    ('owner', 'admin:dlq'),
+    ('owner', 'admin:users');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+ALTER TABLE memberships DROP COLUMN IF EXISTS role;
+DROP TABLE IF EXISTS tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS password_hash;
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name text NOT NULL,
+    -- The start of the key, so that keys can be told apart without storing them
+    prefix text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    rate_limit_rps integer NOT NULL,
+    rate_limit_burst integer NOT NULL,
+    usage_count bigint NOT NULL DEFAULT 0,
+    last_used_at timestamp(3) with time zone,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    revoked_at timestamp(3) with time zone
+);
+
+CREATE INDEX idx_api_keys_organization_id ON api_keys (organization_id);
+
+INSERT INTO permissions (code) VALUES
+    ('api_keys:read'),
+    ('api_keys:write');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'api_keys:read'),
+    ('admin', 'api_keys:write'),
+    ('owner', 'api_keys:read'),
+    ('owner', 'api_keys:write');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code IN ('api_keys:read', 'api_keys:write');
+DROP TABLE IF EXISTS api_keys;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Quarantined messages were caught by the spam filters. They are stored for
+-- review but never delivered to their receiver.
+ALTER TABLE messages ADD COLUMN moderation_status text NOT NULL DEFAULT 'clean'
+    CHECK (moderation_status IN ('clean', 'quarantined'));
+
+CREATE INDEX idx_messages_quarantined ON messages (organization_id, timestamp DESC) WHERE moderation_status = 'quarantined';
+
+INSERT INTO permissions (code) VALUES ('moderation:review');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'moderation:review'),
+    ('owner', 'moderation:review');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'moderation:review';
+DROP INDEX IF EXISTS idx_messages_quarantined;
+ALTER TABLE messages DROP COLUMN IF EXISTS moderation_status;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN suspended_at timestamp(3) with time zone;
+
+-- Reports outlive the messages they are about, which may be deleted when the
+-- report is resolved
+CREATE TABLE IF NOT EXISTS reports (
+    id bigserial PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    message_id bigint REFERENCES messages ON DELETE SET NULL,
+    reporter_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reported_user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    details text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved')),
+    resolution text CHECK (resolution IN ('dismissed', 'message_deleted', 'user_suspended')),
+    resolved_by bigint REFERENCES users ON DELETE SET NULL,
+    resolved_at timestamp(3) with time zone,
+    created_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (message_id, reporter_id)
+);
+
+CREATE INDEX idx_reports_organization_id_status ON reports (organization_id, status, created_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS reports;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
+-- +goose StatementEnd
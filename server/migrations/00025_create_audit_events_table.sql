@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Audit events are written by the worker from the audit stream. event_id is
+-- set by the API so that an event delivered twice is only stored once. Actors
+-- and targets are not foreign keys, since the trail has to outlive the users
+-- and records it mentions.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    event_id text NOT NULL UNIQUE,
+    organization_id bigint NOT NULL,
+    action text NOT NULL,
+    actor_type text NOT NULL CHECK (actor_type IN ('user', 'api_key')),
+    actor_id bigint NOT NULL,
+    target_type text NOT NULL,
+    target_id text NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    before jsonb,
+    after jsonb,
+    created_at timestamp(3) with time zone NOT NULL
+);
+
+CREATE INDEX idx_audit_events_organization_id_created_at ON audit_events (organization_id, created_at DESC);
+
+-- The log is append-only
+CREATE FUNCTION reject_audit_event_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION reject_audit_event_change();
+
+INSERT INTO permissions (code) VALUES ('audit:read');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'audit:read'),
+    ('owner', 'audit:read');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'audit:read';
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS reject_audit_event_change();
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Messages cannot be edited, so message.edited was never sent. It is dropped
+-- from existing subscriptions so that they pass validation when updated.
+UPDATE webhooks
+SET events = array_remove(events, 'message.edited')
+WHERE 'message.edited' = ANY(events);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Emails are unique regardless of case and stored lower-cased. Users whose
+-- emails only differ in case cannot be told apart when logging in, so they
+-- must be merged or renamed by hand before this migration can run.
+DO $$
+DECLARE
+    duplicates text;
+BEGIN
+    SELECT string_agg(email, ', ') INTO duplicates
+    FROM (
+        SELECT lower(email) AS email
+        FROM users
+        WHERE email IS NOT NULL
+        GROUP BY lower(email)
+        HAVING count(*) > 1
+    ) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users have emails that only differ in case: %', duplicates
+            USING HINT = 'Change the emails of all but one user of each so that they are unique regardless of case';
+    END IF;
+END $$;
+
+UPDATE users
+SET email = lower(email)
+WHERE email <> lower(email);
+
+CREATE UNIQUE INDEX users_lower_email_key ON users (lower(email));
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+DROP INDEX IF EXISTS users_lower_email_key;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Provider message IDs are only unique within their channel, so messages
+-- record the channel they were delivered through. Messages delivered before
+-- this went through their receiver's channel.
+ALTER TABLE messages ADD COLUMN delivery_channel text;
+
+UPDATE messages m
+SET delivery_channel = e.channel
+FROM external_identities e
+WHERE e.user_id = m.receiver_id AND m.delivery_status IS NOT NULL;
+
+-- A provider ID reused within a channel cannot be told apart in status
+-- callbacks, so only the newest message keeps it
+UPDATE messages m
+SET external_message_id = NULL
+WHERE m.external_message_id IS NOT NULL
+AND EXISTS (
+    SELECT 1 FROM messages o
+    WHERE o.delivery_channel IS NOT DISTINCT FROM m.delivery_channel
+    AND o.external_message_id = m.external_message_id
+    AND o.id > m.id
+);
+
+DROP INDEX IF EXISTS idx_messages_external_message_id;
+CREATE UNIQUE INDEX messages_delivery_channel_external_message_id_key ON messages (delivery_channel, external_message_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS messages_delivery_channel_external_message_id_key;
+CREATE INDEX idx_messages_external_message_id ON messages (external_message_id) WHERE external_message_id IS NOT NULL;
+ALTER TABLE messages DROP COLUMN IF EXISTS delivery_channel;
+-- +goose StatementEnd